@@ -57,6 +57,9 @@ func Decode(value string) ([]byte, error) {
 	}
 
 	encodedChecksum := publicKeyInt.Bytes()
+	if len(encodedChecksum) < 4 {
+		return nil, fmt.Errorf("%s is too short", value)
+	}
 	encoded := encodedChecksum[:len(encodedChecksum)-4]
 	cksum := encodedChecksum[len(encodedChecksum)-4:]
 
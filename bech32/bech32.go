@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+//Package bech32 encodes and decodes bech32 and bech32m strings
+//and segwit addresses (BIP173, BIP350).
+package bech32
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+//Encoding is a variant of the checksum.
+type Encoding int
+
+//Variants of the checksum.
+const (
+	//Bech32 is used for witness version 0 (BIP173).
+	Bech32 Encoding = iota
+	//Bech32m is used for witness version 1 and later (BIP350).
+	Bech32m
+)
+
+//constant returns the constant xored to the checksum of enc.
+func (enc Encoding) constant() uint32 {
+	if enc == Bech32m {
+		return 0x2bc830a3
+	}
+	return 1
+}
+
+var generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	r := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		r = append(r, hrp[i]>>5)
+	}
+	r = append(r, 0)
+	for i := 0; i < len(hrp); i++ {
+		r = append(r, hrp[i]&31)
+	}
+	return r
+}
+
+func checksum(hrp string, data []byte, enc Encoding) []byte {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := polymod(values) ^ enc.constant()
+	r := make([]byte, 6)
+	for i := range r {
+		r[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return r
+}
+
+//Encode encodes hrp and data, which are 5 bits per byte, to a bech32 string
+//with the checksum of enc.
+func Encode(hrp string, data []byte, enc Encoding) (string, error) {
+	if len(hrp) == 0 || len(hrp)+len(data)+7 > 90 {
+		return "", errors.New("illegal length of bech32 string")
+	}
+	hrp = strings.ToLower(hrp)
+	r := make([]byte, 0, len(hrp)+len(data)+7)
+	r = append(r, hrp...)
+	r = append(r, '1')
+	combined := make([]byte, 0, len(data)+6)
+	combined = append(combined, data...)
+	for _, d := range append(combined, checksum(hrp, data, enc)...) {
+		if d > 31 {
+			return "", errors.New("data is not 5 bits")
+		}
+		r = append(r, charset[d])
+	}
+	return string(r), nil
+}
+
+//Decode decodes a bech32 string to hrp and data, which are 5 bits per byte,
+//and returns the variant of its checksum.
+func Decode(s string) (string, []byte, Encoding, error) {
+	if len(s) > 90 {
+		return "", nil, 0, errors.New("too long bech32 string")
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, errors.New("mixed case in bech32 string")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, 0, errors.New("illegal position of separator")
+	}
+	hrp := s[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, errors.New("illegal character in hrp")
+		}
+	}
+	data := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		d := strings.IndexByte(charset, s[i])
+		if d < 0 {
+			return "", nil, 0, fmt.Errorf("illegal character %q in bech32 string", s[i])
+		}
+		data = append(data, byte(d))
+	}
+	var enc Encoding
+	switch polymod(append(hrpExpand(hrp), data...)) {
+	case Bech32.constant():
+		enc = Bech32
+	case Bech32m.constant():
+		enc = Bech32m
+	default:
+		return "", nil, 0, errors.New("bech32 checksum unmatches")
+	}
+	return hrp, data[:len(data)-6], enc, nil
+}
+
+//ConvertBits regroups data of frombits per byte to tobits per byte.
+//If pad is false, data must not have remaining bits.
+func ConvertBits(data []byte, frombits, tobits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<tobits - 1
+	r := make([]byte, 0, len(data)*int(frombits)/int(tobits)+1)
+	for _, d := range data {
+		if uint32(d)>>frombits != 0 {
+			return nil, errors.New("illegal data to convert bits")
+		}
+		acc = acc<<frombits | uint32(d)
+		bits += frombits
+		for bits >= tobits {
+			bits -= tobits
+			r = append(r, byte(acc>>bits&maxv))
+		}
+	}
+	switch {
+	case pad && bits > 0:
+		r = append(r, byte(acc<<(tobits-bits)&maxv))
+	case !pad && (bits >= frombits || acc<<(tobits-bits)&maxv != 0):
+		return nil, errors.New("illegal padding")
+	}
+	return r, nil
+}
+
+//encoding returns the variant of the checksum for witness version ver.
+func encoding(ver int) Encoding {
+	if ver == 0 {
+		return Bech32
+	}
+	return Bech32m
+}
+
+//EncodeAddress encodes witness program prog with version ver to a segwit address.
+func EncodeAddress(hrp string, ver int, prog []byte) (string, error) {
+	if err := checkProgram(ver, prog); err != nil {
+		return "", err
+	}
+	data, err := ConvertBits(prog, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return Encode(hrp, append([]byte{byte(ver)}, data...), encoding(ver))
+}
+
+//DecodeAddress decodes a segwit address whose hrp must be hrp to
+//the version and witness program.
+func DecodeAddress(hrp, adr string) (int, []byte, error) {
+	h, data, enc, err := Decode(adr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if h != hrp {
+		return 0, nil, fmt.Errorf("hrp %s is not %s", h, hrp)
+	}
+	if len(data) == 0 {
+		return 0, nil, errors.New("empty segwit address")
+	}
+	prog, err := ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	ver := int(data[0])
+	if err := checkProgram(ver, prog); err != nil {
+		return 0, nil, err
+	}
+	if enc != encoding(ver) {
+		return 0, nil, fmt.Errorf("illegal checksum variant for witness version %d", ver)
+	}
+	return ver, prog, nil
+}
+
+func checkProgram(ver int, prog []byte) error {
+	switch {
+	case ver < 0 || ver > 16:
+		return errors.New("illegal witness version")
+	case len(prog) < 2 || len(prog) > 40:
+		return errors.New("illegal length of witness program")
+	case ver == 0 && len(prog) != 20 && len(prog) != 32:
+		return errors.New("illegal length of witness program version 0")
+	}
+	return nil
+}
@@ -32,6 +32,7 @@ import (
 	"bytes"
 	"errors"
 	"log"
+	"math/big"
 	"sort"
 
 	"encoding/binary"
@@ -52,31 +53,45 @@ func (p UInt64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
 var (
 	zero        = make([]byte, 32)
-	checkpoints = make(UInt64Slice, len(params.CheckPoints))
+	checkpoints UInt64Slice
 	genesis     *Block
+	wdb         *db.DB
 )
 
-func init() {
-	log.SetFlags(log.Ldate | log.Lshortfile | log.Ltime)
-	i := 0
-	for k := range params.CheckPoints {
-		checkpoints[i] = k
-		i++
+//Init sets the database used in this package and
+//registers checkpoints of the selected network to it.
+func Init(d *db.DB) error {
+	wdb = d
+	checkpoints = make(UInt64Slice, 0, len(params.Net.CheckPoints))
+	for k := range params.Net.CheckPoints {
+		checkpoints = append(checkpoints, k)
 	}
 	sort.Sort(checkpoints)
 
 	genesis = &Block{
-		Hash:   params.GenesisHash,
+		Hash:   params.Net.GenesisHash,
 		Height: 0,
-		Prev:   params.Prevs[0],
+		Work:   big.NewInt(0),
+		HBlockHeader: msg.HBlockHeader{
+			Prev: params.Net.Prevs[0],
+		},
 	}
 
-	err := db.DB.Update(func(tx *bolt.Tx) error {
-		for k, v := range params.CheckPoints {
+	return wdb.Update(func(tx *bolt.Tx) error {
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		for k, v := range params.Net.CheckPoints {
+			if db.HasKey(tx, "block", v) {
+				continue
+			}
 			b := &Block{
 				Hash:   v,
 				Height: k,
-				Prev:   params.Prevs[k],
+				Work:   big.NewInt(0),
+				HBlockHeader: msg.HBlockHeader{
+					Prev: params.Net.Prevs[k],
+				},
 			}
 			if errr := b.addDB(tx); errr != nil {
 				return errr
@@ -87,29 +102,63 @@ func init() {
 		}
 		return nil
 	})
-	if err != nil {
-		log.Fatal(err)
-	}
 }
 
 //Block is block info for database.
 type Block struct {
 	Hash   []byte
-	Prev   []byte
 	Height uint64
+	//Work is the total work of the chain from the last checkpoint.
+	//Blocks stored by older versions have no work.
+	Work *big.Int
+	//Fields in the header except Prev are zero for checkpoints
+	//and blocks stored by older versions.
+	msg.HBlockHeader
 }
 
-func (b *Block) packHeightPrev() []byte {
-	out := make([]byte, 8+32)
+//HasHeader returns true if the full header of b is stored.
+func (b *Block) HasHeader() bool {
+	return b.Timestamp != 0
+}
+
+//pack packs b to height(8 bytes), work(32) and the header(80).
+func (b *Block) pack() ([]byte, error) {
+	out := make([]byte, 8+32, 8+32+80)
 	binary.LittleEndian.PutUint64(out[:8], b.Height)
-	copy(out[8:], b.Prev)
-	return out
+	if b.Work != nil {
+		w := b.Work.Bytes()
+		copy(out[40-len(w):], w)
+	}
+	h := b.HBlockHeader
+	if h.Merkle == nil {
+		h.Merkle = make([]byte, 32)
+	}
+	if h.Nonce == nil {
+		h.Nonce = make([]byte, 4)
+	}
+	buf := bytes.NewBuffer(out)
+	if err := msg.Pack(buf, h); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//unpack unpacks dat packed by pack to b.
+func (b *Block) unpack(dat []byte) error {
+	if len(dat) != 8+32+80 {
+		return errors.New("invalid block record")
+	}
+	b.Height = binary.LittleEndian.Uint64(dat[:8])
+	b.Work = new(big.Int).SetBytes(dat[8:40])
+	h := make([]byte, 80)
+	copy(h, dat[40:])
+	return msg.Unpack(bytes.NewBuffer(h), &b.HBlockHeader)
 }
 
 //LoadBlock loads and returns Block struct from hash.
 func LoadBlock(hash []byte) (*Block, error) {
 	var b *Block
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
 		var err error
 		b, err = loadBlock(tx, hash)
 		return err
@@ -120,7 +169,7 @@ func LoadBlock(hash []byte) (*Block, error) {
 //HasBlock returns true is block hash is in blocks.
 func HasBlock(hash []byte) bool {
 	has := false
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
 		var err error
 		has = db.HasKey(tx, "block", hash)
 		return err
@@ -136,20 +185,25 @@ func loadBlock(tx *bolt.Tx, hash []byte) (*Block, error) {
 	if dat, err = db.Get(tx, "block", hash, nil); err != nil {
 		return nil, err
 	}
-	height := binary.LittleEndian.Uint64(dat[:8])
-	return &Block{
-		Hash:   hash,
-		Prev:   dat[8:],
-		Height: height,
-	}, nil
+	b := &Block{
+		Hash: make([]byte, 32),
+	}
+	copy(b.Hash, hash)
+	return b, b.unpack(dat)
 }
 
 func (b *Block) addDB(tx *bolt.Tx) error {
-	err := db.Put(tx, "block", b.Hash, b.packHeightPrev())
+	dat, err := b.pack()
 	if err != nil {
 		return err
 	}
-	if _, ok := params.CheckPoints[b.Height]; ok {
+	if err = db.Put(tx, "block", b.Hash, dat); err != nil {
+		return err
+	}
+	if len(checkpoints) > 0 && b.Height > topCheckpoint() {
+		return updateTip(tx, b)
+	}
+	if _, ok := params.Net.CheckPoints[b.Height]; ok {
 		bdb := b
 		var i uint64
 		for i = 0; i < params.Nconfirmed; i++ {
@@ -177,7 +231,7 @@ func (b *Block) addDB(tx *bolt.Tx) error {
 //Lastblocks returns last blocks in blocks.
 func Lastblocks() []*Block {
 	var b []*Block
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
 		b = lastblocks(tx)
 		return nil
 	})
@@ -190,8 +244,9 @@ func Lastblocks() []*Block {
 //Confirmed returns true if hash is confirmed.
 func Confirmed(b *Block) bool {
 	confirmed := false
-	err := db.DB.View(func(tx *bolt.Tx) error {
-		if db.HasKey(tx, "blockheight", db.ToKey(b.Height)) {
+	err := wdb.View(func(tx *bolt.Tx) error {
+		h, err := db.Get(tx, "blockheight", db.ToKey(b.Height), nil)
+		if err == nil && bytes.Equal(h, b.Hash) {
 			confirmed = true
 		}
 		return nil
@@ -205,7 +260,7 @@ func Confirmed(b *Block) bool {
 //Lastblock returns the last block.
 func Lastblock() *Block {
 	var lastb *Block
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
 		for i := 0; i < len(checkpoints); i++ {
 			var end uint64
 			if i == len(checkpoints)-1 {
@@ -279,7 +334,7 @@ func lastblocks(tx *bolt.Tx) []*Block {
 //DownloadedBlockNumber returns downloaded block number.
 func DownloadedBlockNumber() uint64 {
 	var num uint64
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
 		for i := 0; i < len(checkpoints); i++ {
 			var end uint64
 			if i == len(checkpoints)-1 {
@@ -302,7 +357,7 @@ func DownloadedBlockNumber() uint64 {
 //block must be confirmed, i.e. whose hwight is more than Nconfirmed(5).
 func GetHashes(height uint64, n uint64) ([][]byte, error) {
 	hashes := make([][]byte, n)
-	errr := db.DB.View(func(tx *bolt.Tx) error {
+	errr := wdb.View(func(tx *bolt.Tx) error {
 		var i uint64
 		for i = 0; i < n; i++ {
 			hash, err := db.Get(tx, "blockheight", db.ToKey(height+i), nil)
@@ -333,7 +388,7 @@ func AddMerkle(mbs *msg.Merkleblock) (bool, error) {
 //We must add blocks in height order.
 func Add(mbs msg.Headers) (bool, error) {
 	finished := false
-	errr := db.DB.Update(func(tx *bolt.Tx) error {
+	errr := wdb.Update(func(tx *bolt.Tx) error {
 		for i, b := range mbs.Inventory {
 			h := b.Hash()
 			previous, err := loadBlock(tx, b.Prev)
@@ -343,11 +398,12 @@ func Add(mbs msg.Headers) (bool, error) {
 				return err
 			}
 			block := &Block{
-				Hash:   h,
-				Prev:   b.Prev,
-				Height: previous.Height + 1,
+				Hash:         h,
+				Height:       previous.Height + 1,
+				Work:         new(big.Int).Add(previous.Work, calcWork(b.Bits)),
+				HBlockHeader: b.HBlockHeader,
 			}
-			c, isCheckPoint := params.CheckPoints[block.Height]
+			c, isCheckPoint := params.Net.CheckPoints[block.Height]
 			if !isCheckPoint && db.HasKey(tx, "block", h) {
 				continue
 			}
@@ -357,10 +413,17 @@ func Add(mbs msg.Headers) (bool, error) {
 					return err
 				}
 				finished = true
+				block.Work = big.NewInt(0)
 			}
 			if err = b.IsOK(block.Height); err != nil {
 				return err
 			}
+			if err = checkBits(tx, previous, &b.HBlockHeader); err != nil {
+				return err
+			}
+			if err = checkTimestamp(tx, previous, &b.HBlockHeader); err != nil {
+				return err
+			}
 			if err = block.addDB(tx); err != nil {
 				return err
 			}
@@ -422,7 +485,7 @@ func addHistory(tx *bolt.Tx, bdb *Block, indexes []msg.Hash) []msg.Hash {
 //LocatorHash is processed by a node in the order as they appear in the message.
 func LocatorHash(lasthash []byte) ([]msg.Hash, error) {
 	var indexes []msg.Hash
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
 		bdb, err := loadBlock(tx, lasthash)
 		if err != nil {
 			log.Print(err)
@@ -432,8 +495,8 @@ func LocatorHash(lasthash []byte) ([]msg.Hash, error) {
 		indexes = addHistory(tx, bdb, indexes)
 		return nil
 	})
-	if !bytes.Equal(indexes[len(indexes)-1].Hash, params.GenesisHash) {
-		indexes = append(indexes, msg.Hash{Hash: params.GenesisHash})
+	if !bytes.Equal(indexes[len(indexes)-1].Hash, params.Net.GenesisHash) {
+		indexes = append(indexes, msg.Hash{Hash: params.Net.GenesisHash})
 	}
 	return indexes, err
 }
@@ -31,7 +31,10 @@ package block
 import (
 	"bytes"
 	"encoding/hex"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/boltdb/bolt"
@@ -82,9 +85,31 @@ func init() {
 	}
 }
 
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "block")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := db.Open(filepath.Join(dir, db.FileName), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = Init(d); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	if err = d.Close(); err != nil {
+		log.Print(err)
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
+
 func del() {
 	var err error
-	errr := db.DB.Update(func(tx *bolt.Tx) error {
+	errr := wdb.Update(func(tx *bolt.Tx) error {
 		for _, h := range hash {
 			err = db.Del(tx, "block", h)
 			if err != nil {
@@ -129,7 +154,7 @@ func TestBlock2(t *testing.T) {
 		t.Fatalf("illegal tail height %d", b.Height)
 	}
 	lb := Lastblock()
-	if !bytes.Equal(lb.Hash, params.GenesisHash) {
+	if !bytes.Equal(lb.Hash, params.Net.GenesisHash) {
 		t.Error("tail unmatched", behex.EncodeToString(lb.Hash))
 	}
 	if lb.Height != 0 {
@@ -177,7 +202,7 @@ func TestLocator(t *testing.T) {
 			t.Fatal("illegal locator", i)
 		}
 	}
-	if !bytes.Equal(h[len(h)-1].Hash, params.GenesisHash) {
+	if !bytes.Equal(h[len(h)-1].Hash, params.Net.GenesisHash) {
 		t.Fatal("illegal locator")
 	}
 
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+)
+
+//DisconnectFunc is called in the db transaction for each block which
+//is disconnected from the main chain by a reorganization.
+//Blocks are disconnected from the tip to the fork point.
+type DisconnectFunc func(tx *bolt.Tx, b *Block) error
+
+var (
+	disconnectFuncs []DisconnectFunc
+	oneLsh256       = new(big.Int).Lsh(big.NewInt(1), 256)
+)
+
+//OnDisconnect registers f to be called when a block is disconnected.
+func OnDisconnect(f DisconnectFunc) {
+	disconnectFuncs = append(disconnectFuncs, f)
+}
+
+//compactToBig converts compact nBits to a target.
+func compactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+	var n *big.Int
+	if exponent <= 3 {
+		n = big.NewInt(mantissa >> (8 * (3 - exponent)))
+	} else {
+		n = new(big.Int).Lsh(big.NewInt(mantissa), 8*(exponent-3))
+	}
+	if bits&0x00800000 != 0 {
+		n = n.Neg(n)
+	}
+	return n
+}
+
+//calcWork returns the expected number of hashes to find a block with bits,
+//i.e. 2^256/(target+1).
+func calcWork(bits uint32) *big.Int {
+	target := compactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Div(oneLsh256, target.Add(target, big.NewInt(1)))
+}
+
+//topCheckpoint returns the height of the highest checkpoint.
+//Blocks above it are managed as a tree and the chain with the most work
+//is selected as the main chain.
+func topCheckpoint() uint64 {
+	return checkpoints[len(checkpoints)-1]
+}
+
+//Tip returns the tip of the main chain.
+func Tip() *Block {
+	var b *Block
+	err := wdb.View(func(tx *bolt.Tx) error {
+		var err error
+		b, err = tip(tx)
+		return err
+	})
+	if err != nil {
+		log.Print(err)
+		return genesis
+	}
+	return b
+}
+
+func tip(tx *bolt.Tx) (*Block, error) {
+	hash, err := db.Get(tx, "status", []byte("besttip"), nil)
+	if err != nil {
+		return loadBlock(tx, params.Net.CheckPoints[topCheckpoint()])
+	}
+	return loadBlock(tx, hash)
+}
+
+//ByHeight returns the block at height in the main chain.
+func ByHeight(height uint64) (*Block, error) {
+	var b *Block
+	err := wdb.View(func(tx *bolt.Tx) error {
+		if h, err := db.Get(tx, "blockheight", db.ToKey(height), nil); err == nil {
+			b, err = loadBlock(tx, h)
+			return err
+		}
+		t, err := tip(tx)
+		if err != nil {
+			return err
+		}
+		if t.Height < height {
+			return fmt.Errorf("no block at height %d", height)
+		}
+		b, err = ancestor(tx, t, height)
+		return err
+	})
+	return b, err
+}
+
+//Header returns the header of the block hash.
+//It returns an error if only the hash of the block is known.
+func Header(hash []byte) (*msg.HBlockHeader, error) {
+	b, err := LoadBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !b.HasHeader() {
+		return nil, errors.New("header is not stored")
+	}
+	return &b.HBlockHeader, nil
+}
+
+//Confirmations returns the number of confirmations of the block hash,
+//or 0 if it is not in the main chain.
+func Confirmations(hash []byte) uint64 {
+	var n uint64
+	err := wdb.View(func(tx *bolt.Tx) error {
+		t, err := tip(tx)
+		if err != nil {
+			return err
+		}
+		b, err := loadBlock(tx, hash)
+		if err != nil {
+			return err
+		}
+		main, err := onMain(tx, t, b)
+		if err != nil {
+			return err
+		}
+		if main {
+			n = t.Height - b.Height + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+//onMain returns true if b is in the chain whose tip is t.
+func onMain(tx *bolt.Tx, t, b *Block) (bool, error) {
+	if b.Height > t.Height {
+		return false, nil
+	}
+	if h, err := db.Get(tx, "blockheight", db.ToKey(b.Height), nil); err == nil {
+		return bytes.Equal(h, b.Hash), nil
+	}
+	a, err := ancestor(tx, t, b.Height)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a.Hash, b.Hash), nil
+}
+
+//ancestor returns the ancestor of b at height.
+func ancestor(tx *bolt.Tx, b *Block, height uint64) (*Block, error) {
+	var err error
+	for b.Height > height {
+		if b, err = loadBlock(tx, b.Prev); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+//forkPoint returns the last common block of a and b.
+func forkPoint(tx *bolt.Tx, a, b *Block) (*Block, error) {
+	var err error
+	if a.Height > b.Height {
+		a, err = ancestor(tx, a, b.Height)
+	} else {
+		b, err = ancestor(tx, b, a.Height)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for !bytes.Equal(a.Hash, b.Hash) {
+		if a.Height <= topCheckpoint() {
+			return nil, errors.New("fork below the checkpoint")
+		}
+		if a, err = loadBlock(tx, a.Prev); err != nil {
+			return nil, err
+		}
+		if b, err = loadBlock(tx, b.Prev); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+//updateTip makes b the tip of the main chain if it has more work than
+//the current tip, disconnecting blocks of the current chain back to
+//the fork point.
+func updateTip(tx *bolt.Tx, b *Block) error {
+	old, err := tip(tx)
+	if err != nil {
+		return err
+	}
+	if b.Work.Cmp(old.Work) <= 0 {
+		return nil
+	}
+	fork, err := forkPoint(tx, old, b)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(fork.Hash, old.Hash) {
+		log.Println("reorganizing from", behex.EncodeToString(old.Hash),
+			"to", behex.EncodeToString(b.Hash), "fork at", fork.Height)
+	}
+	for d := old; d.Height > fork.Height; {
+		for _, f := range disconnectFuncs {
+			if err = f(tx, d); err != nil {
+				return err
+			}
+		}
+		if err = db.Del(tx, "blockheight", db.ToKey(d.Height)); err != nil {
+			return err
+		}
+		if d, err = loadBlock(tx, d.Prev); err != nil {
+			return err
+		}
+	}
+	//index blocks in the new chain which are confirmed.
+	for c := b; c.Height > topCheckpoint(); {
+		if c.Height+params.Nconfirmed <= b.Height {
+			h, errr := db.Get(tx, "blockheight", db.ToKey(c.Height), nil)
+			if errr == nil && bytes.Equal(h, c.Hash) {
+				break
+			}
+			if err = db.Put(tx, "blockheight", db.ToKey(c.Height), c.Hash); err != nil {
+				return err
+			}
+		}
+		if c, err = loadBlock(tx, c.Prev); err != nil {
+			return err
+		}
+	}
+	return db.Put(tx, "status", []byte("besttip"), b.Hash)
+}
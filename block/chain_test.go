@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+)
+
+//mine makes n regtest headers after prev.
+func mine(prev []byte, n int, tag byte) []msg.BlockHeader {
+	hs := make([]msg.BlockHeader, n)
+	for i := range hs {
+		h := msg.BlockHeader{
+			HBlockHeader: msg.HBlockHeader{
+				Version:   2,
+				Prev:      prev,
+				Merkle:    bytes.Repeat([]byte{tag}, 32),
+				Timestamp: uint32(1500000000 + i*90),
+				Bits:      params.Net.ProofOfWorkLimit,
+				Nonce:     make([]byte, 4),
+			},
+		}
+		for nonce := uint32(0); h.IsOK(0) != nil; nonce++ {
+			binary.LittleEndian.PutUint32(h.Nonce, nonce)
+		}
+		hs[i] = h
+		prev = h.Hash()
+	}
+	return hs
+}
+
+func useRegTest(t *testing.T) func() {
+	old := wdb
+	oldFuncs := disconnectFuncs
+	if err := params.Select(params.RegTest); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.Open(filepath.Join(dir, db.FileName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Init(d); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if err := d.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+		disconnectFuncs = oldFuncs
+		if err := params.Select(params.MainNet); err != nil {
+			t.Fatal(err)
+		}
+		if err := Init(old); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkHeight(t *testing.T, height uint64, h []byte) {
+	err := wdb.View(func(tx *bolt.Tx) error {
+		hash, err := db.Get(tx, "blockheight", db.ToKey(height), nil)
+		if h == nil && err == nil {
+			t.Error("block at", height, "should not be confirmed")
+		}
+		if h != nil && !bytes.Equal(hash, h) {
+			t.Error("invalid confirmed block at", height)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReorg(t *testing.T) {
+	defer useRegTest(t)()
+	var disconnected []uint64
+	OnDisconnect(func(tx *bolt.Tx, b *Block) error {
+		disconnected = append(disconnected, b.Height)
+		return nil
+	})
+
+	a := mine(params.Net.GenesisHash, 7, 0xa)
+	if _, err := Add(msg.Headers{Inventory: a}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(Tip().Hash, a[6].Hash()) {
+		t.Fatal("tip is not the last block")
+	}
+	checkHeight(t, 2, a[1].Hash())
+	checkHeight(t, 3, nil)
+
+	b := mine(params.Net.GenesisHash, 8, 0xb)
+	if _, err := Add(msg.Headers{Inventory: b[:7]}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(Tip().Hash, a[6].Hash()) || len(disconnected) != 0 {
+		t.Fatal("reorganized to a chain with the same work")
+	}
+	if _, err := Add(msg.Headers{Inventory: b[7:]}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(Tip().Hash, b[7].Hash()) {
+		t.Fatal("not reorganized")
+	}
+	if len(disconnected) != 7 || disconnected[0] != 7 || disconnected[6] != 1 {
+		t.Error("invalid disconnected blocks", disconnected)
+	}
+	checkHeight(t, 2, b[1].Hash())
+	checkHeight(t, 3, b[2].Hash())
+	checkHeight(t, 4, nil)
+	if n := Confirmations(a[1].Hash()); n != 0 {
+		t.Error("orphaned block has confirmations", n)
+	}
+	if n := Confirmations(b[0].Hash()); n != 8 {
+		t.Error("invalid confirmations", n)
+	}
+	for _, height := range []uint64{2, 7} {
+		bl, err := ByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(bl.Hash, b[height-1].Hash()) {
+			t.Error("invalid block at height", height)
+		}
+	}
+	h, err := Header(b[6].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h.Merkle, b[6].Merkle) || !bytes.Equal(h.Nonce, b[6].Nonce) {
+		t.Error("header is not stored")
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package block
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+)
+
+//errUnverifiable is returned when nBits or median-time-past cannot be
+//calculated because previous blocks are not stored or not verifiable.
+var errUnverifiable = errors.New("cannot calculate nBits")
+
+//bigToCompact converts a target to compact nBits.
+func bigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Int64()) << (8 * (3 - exponent))
+	} else {
+		tn := new(big.Int).Rsh(n, 8*(exponent-3))
+		mantissa = uint32(tn.Int64())
+	}
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent<<24) | mantissa
+}
+
+//checkBits returns an error if nBits of header h whose previous block is
+//prev is different from the one calculated by the retarget algorithm.
+//Headers are not checked if previous blocks needed to calculate are not stored.
+func checkBits(tx *bolt.Tx, prev *Block, h *msg.HBlockHeader) error {
+	if params.Net.NoRetargeting {
+		return nil
+	}
+	if params.Net.AllowMinDifficulty && h.Bits == params.Net.ProofOfWorkLimit &&
+		prev.Timestamp != 0 && h.Timestamp > prev.Timestamp+2*params.TargetSpacing {
+		return nil
+	}
+	bits, err := nextBits(tx, prev)
+	if err == errUnverifiable {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bits != h.Bits {
+		return fmt.Errorf("nBits %x is different from expected %x at height %d",
+			h.Bits, bits, prev.Height+1)
+	}
+	return nil
+}
+
+//nextBits returns nBits of the block after prev.
+func nextBits(tx *bolt.Tx, prev *Block) (uint32, error) {
+	if prev.Bits == 0 {
+		return 0, errUnverifiable
+	}
+	height := prev.Height + 1
+	switch {
+	case height >= params.Net.DGWHeight:
+		return darkGravityWave(tx, prev)
+	case height >= params.Net.DigiShieldHeight:
+		return digiShield(tx, prev)
+	case height >= params.Net.KGWHeight:
+		//KimotoGravityWell blocks are between checkpoints on mainnet.
+		return 0, errUnverifiable
+	default:
+		return retargetV1(tx, prev)
+	}
+}
+
+//prevBlock returns the previous block of b whose header is stored.
+func prevBlock(tx *bolt.Tx, b *Block) (*Block, error) {
+	p, err := loadBlock(tx, b.Prev)
+	if err != nil || p.Bits == 0 {
+		return nil, errUnverifiable
+	}
+	return p, nil
+}
+
+//limit returns the target calculated from t*actual/timespan,
+//which doesn't exceed ProofOfWorkLimit.
+func limit(t *big.Int, actual, timespan int64) uint32 {
+	t.Mul(t, big.NewInt(actual))
+	t.Div(t, big.NewInt(timespan))
+	if powLimit := compactToBig(params.Net.ProofOfWorkLimit); t.Cmp(powLimit) > 0 {
+		return params.Net.ProofOfWorkLimit
+	}
+	return bigToCompact(t)
+}
+
+//retargetV1 is the original retarget algorithm which retargets
+//every TargetTimespan/TargetSpacing blocks.
+func retargetV1(tx *bolt.Tx, prev *Block) (uint32, error) {
+	var err error
+	interval := uint64(params.TargetTimespan / params.TargetSpacing)
+	height := prev.Height + 1
+	if height%interval != 0 {
+		b := prev
+		if params.Net.AllowMinDifficulty {
+			//return the last non-special-min-difficulty-rules-block.
+			for b.Height%interval != 0 && b.Bits == params.Net.ProofOfWorkLimit {
+				if b, err = prevBlock(tx, b); err != nil {
+					return 0, err
+				}
+			}
+		}
+		return b.Bits, nil
+	}
+	back := interval
+	if height == interval {
+		back = interval - 1
+	}
+	first := prev
+	for i := uint64(0); i < back; i++ {
+		if first, err = prevBlock(tx, first); err != nil {
+			return 0, err
+		}
+	}
+	actual := int64(prev.Timestamp) - int64(first.Timestamp)
+	if actual < params.TargetTimespan/4 {
+		actual = params.TargetTimespan / 4
+	}
+	if actual > params.TargetTimespan*4 {
+		actual = params.TargetTimespan * 4
+	}
+	t := compactToBig(prev.Bits)
+	//same as the original, to avoid overflow of uint256.
+	shift := t.BitLen() > compactToBig(params.Net.ProofOfWorkLimit).BitLen()-1
+	if shift {
+		t.Rsh(t, 1)
+	}
+	t.Mul(t, big.NewInt(actual))
+	t.Div(t, big.NewInt(params.TargetTimespan))
+	if shift {
+		t.Lsh(t, 1)
+	}
+	return limit(t, 1, 1), nil
+}
+
+//digiShield retargets every block by the timespan of the previous block.
+func digiShield(tx *bolt.Tx, prev *Block) (uint32, error) {
+	const timespan = params.TargetSpacing
+	first, err := prevBlock(tx, prev)
+	if err != nil {
+		return 0, err
+	}
+	actual := int64(prev.Timestamp) - int64(first.Timestamp)
+	actual = timespan + (actual-timespan)/4
+	if actual < timespan-timespan/4 {
+		actual = timespan - timespan/4
+	}
+	if actual > timespan+timespan/2 {
+		actual = timespan + timespan/2
+	}
+	return limit(compactToBig(prev.Bits), actual, timespan), nil
+}
+
+//darkGravityWave retargets every block by the average target
+//and the timespan of the previous 24 blocks.
+func darkGravityWave(tx *bolt.Tx, prev *Block) (uint32, error) {
+	const pastBlocks = 24
+	if prev.Height < pastBlocks {
+		return params.Net.ProofOfWorkLimit, nil
+	}
+	var err error
+	b := prev
+	avg := new(big.Int)
+	for i := int64(1); i <= pastBlocks; i++ {
+		t := compactToBig(b.Bits)
+		if i == 1 {
+			avg.Set(t)
+		} else {
+			avg.Mul(avg, big.NewInt(i))
+			avg.Add(avg, t)
+			avg.Div(avg, big.NewInt(i+1))
+		}
+		if i != pastBlocks {
+			if b, err = prevBlock(tx, b); err != nil {
+				return 0, err
+			}
+		}
+	}
+	actual := int64(prev.Timestamp) - int64(b.Timestamp)
+	target := int64(pastBlocks * params.TargetSpacing)
+	if actual < target/3 {
+		actual = target / 3
+	}
+	if actual > target*3 {
+		actual = target * 3
+	}
+	return limit(avg, actual, target), nil
+}
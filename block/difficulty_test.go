@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package block
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/msg"
+)
+
+//addChain adds n dummy blocks from height with nBits bits
+//which are created every spacing seconds, and returns the last one.
+func addChain(t *testing.T, height uint64, n int, bits uint32, spacing uint32) *Block {
+	var last *Block
+	err := wdb.Update(func(tx *bolt.Tx) error {
+		prev := make([]byte, 32)
+		for i := 0; i < n; i++ {
+			hash := make([]byte, 32)
+			binary.LittleEndian.PutUint64(hash, height+uint64(i))
+			hash[31] = 0xdd
+			last = &Block{
+				Hash:   hash,
+				Height: height + uint64(i),
+				Work:   big.NewInt(0),
+				HBlockHeader: msg.HBlockHeader{
+					Prev:      prev,
+					Timestamp: 1500000000 + uint32(i)*spacing,
+					Bits:      bits,
+				},
+			}
+			dat, err := last.pack()
+			if err != nil {
+				return err
+			}
+			if err := db.Put(tx, "block", last.Hash, dat); err != nil {
+				return err
+			}
+			prev = hash
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return last
+}
+
+func TestRetarget(t *testing.T) {
+	const bits = 0x1b0404cb
+	target := compactToBig(bits)
+	ratio := func(n, d int64) uint32 {
+		tt := new(big.Int).Mul(target, big.NewInt(n))
+		return bigToCompact(tt.Div(tt, big.NewInt(d)))
+	}
+	tests := []struct {
+		height   uint64
+		spacing  uint32
+		expected uint32
+	}{
+		//DigiShield
+		{200000, 90, bits},
+		{200000, 1000, ratio(3, 2)},
+		{200000, 1, ratio(68, 90)},
+		//DarkGravityWave
+		{500000, 90, ratio(23, 24)},
+		{500000, 1, ratio(1, 3)},
+		{500000, 1000, ratio(3, 1)},
+	}
+	for i, test := range tests {
+		last := addChain(t, test.height, 30, bits, test.spacing)
+		h := &msg.HBlockHeader{
+			Timestamp: last.Timestamp + test.spacing,
+			Bits:      test.expected,
+		}
+		err := wdb.View(func(tx *bolt.Tx) error {
+			if err := checkBits(tx, last, h); err != nil {
+				t.Error(i, err)
+			}
+			h.Bits++
+			if err := checkBits(tx, last, h); err == nil {
+				t.Error(i, "invalid nBits is accepted")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c := bigToCompact(compactToBig(bits)); c != bits {
+		t.Errorf("compact conversion failed %x", c)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/params"
+)
+
+//blockFormat is the version of the format of records in "block" bucket.
+//
+//	0: height(8 bytes), prev(32) and optional work(var)
+//	1: height(8), prev(32), work(32), timestamp(4) and bits(4)
+//	2: height(8), work(32) and the header(80)
+const blockFormat = 2
+
+//decodeOld decodes a block record of older formats.
+func decodeOld(hash, dat []byte) *Block {
+	b := &Block{
+		Hash:   append([]byte{}, hash...),
+		Height: binary.LittleEndian.Uint64(dat[:8]),
+		Work:   new(big.Int),
+	}
+	b.Prev = append([]byte{}, dat[8:40]...)
+	if len(dat) != 80 {
+		b.Work.SetBytes(dat[40:])
+		return b
+	}
+	b.Work.SetBytes(dat[40:72])
+	b.Timestamp = binary.LittleEndian.Uint32(dat[72:])
+	b.Bits = binary.LittleEndian.Uint32(dat[76:])
+	return b
+}
+
+//migrate converts block records of older formats to the current one.
+//Older formats lack the full header, which chain work, median-time-past and
+//retargeting depend on, so only checkpoints are converted and other blocks
+//are removed to be downloaded again from the checkpoints.
+func migrate(tx *bolt.Tx) error {
+	var format uint64
+	if _, err := db.Get(tx, "status", []byte("blockformat"), &format); err == nil &&
+		format >= blockFormat {
+		return nil
+	}
+	var blocks []*Block
+	if bucket := tx.Bucket([]byte("block")); bucket != nil {
+		err := bucket.ForEach(func(k, v []byte) error {
+			if len(v) != 8+32+80 {
+				blocks = append(blocks, decodeOld(k, v))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(blocks) > 0 {
+		log.Println("migrating", len(blocks), "blocks, headers will be downloaded again")
+	}
+	removed := false
+	for _, b := range blocks {
+		if c, ok := params.Net.CheckPoints[b.Height]; !ok || !bytes.Equal(c, b.Hash) {
+			if err := removeBlock(tx, b); err != nil {
+				return err
+			}
+			removed = true
+			continue
+		}
+		dat, err := b.pack()
+		if err != nil {
+			return err
+		}
+		if err := db.Put(tx, "block", b.Hash, dat); err != nil {
+			return err
+		}
+	}
+	if removed && db.HasKey(tx, "status", []byte("besttip")) {
+		if err := db.Del(tx, "status", []byte("besttip")); err != nil {
+			return err
+		}
+	}
+	return db.Put(tx, "status", []byte("blockformat"), uint64(blockFormat))
+}
+
+//removeBlock removes b and its index by height.
+func removeBlock(tx *bolt.Tx, b *Block) error {
+	if err := db.Del(tx, "block", b.Hash); err != nil {
+		return err
+	}
+	h, err := db.Get(tx, "blockheight", db.ToKey(b.Height), nil)
+	if err != nil || !bytes.Equal(h, b.Hash) {
+		return nil
+	}
+	return db.Del(tx, "blockheight", db.ToKey(b.Height))
+}
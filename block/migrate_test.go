@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/params"
+)
+
+func TestMigrate(t *testing.T) {
+	prev := bytes.Repeat([]byte{0xee}, 32)
+	old0 := make([]byte, 40)
+	binary.LittleEndian.PutUint64(old0, 123)
+	copy(old0[8:], prev)
+	old1 := make([]byte, 80)
+	copy(old1, old0)
+	old1[71] = 0x10
+	binary.LittleEndian.PutUint32(old1[72:], 1500000000)
+	binary.LittleEndian.PutUint32(old1[76:], 0x1b0404cb)
+	hashes := [][]byte{bytes.Repeat([]byte{0xe0}, 32), bytes.Repeat([]byte{0xe1}, 32)}
+
+	//the checkpoint in the old format.
+	cp := topCheckpoint()
+	oldcp := make([]byte, 40)
+	binary.LittleEndian.PutUint64(oldcp, cp)
+	copy(oldcp[8:], params.Net.Prevs[cp])
+
+	err := wdb.Update(func(tx *bolt.Tx) error {
+		if err := db.Put(tx, "block", hashes[0], old0); err != nil {
+			return err
+		}
+		if err := db.Put(tx, "block", hashes[1], old1); err != nil {
+			return err
+		}
+		if err := db.Put(tx, "blockheight", db.ToKey(123), hashes[1]); err != nil {
+			return err
+		}
+		if err := db.Put(tx, "block", params.Net.CheckPoints[cp], oldcp); err != nil {
+			return err
+		}
+		if err := db.Put(tx, "status", []byte("blockformat"), uint64(0)); err != nil {
+			return err
+		}
+		return migrate(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range hashes {
+		if HasBlock(h) {
+			t.Error(i, "header-less block remains")
+		}
+	}
+	if _, err = ByHeight(123); err == nil {
+		t.Error("index of the header-less block remains")
+	}
+	b, err := LoadBlock(params.Net.CheckPoints[cp])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Height != cp || !bytes.Equal(b.Prev, params.Net.Prevs[cp]) || b.Work.Sign() != 0 {
+		t.Error("invalid migrated checkpoint", b.Height, b.Work)
+	}
+	if !bytes.Equal(Tip().Hash, b.Hash) {
+		t.Error("chain must be synced again from the checkpoint")
+	}
+
+	//decodeOld still reads timestamps and bits of the format 1.
+	b = decodeOld(hashes[1], old1)
+	if b.Work.Int64() != 0x10 || b.Bits != 0x1b0404cb || b.Timestamp != 1500000000 {
+		t.Error("invalid decoded block", b.Work, b.Bits, b.Timestamp)
+	}
+}
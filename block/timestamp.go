@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package block
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/myself"
+)
+
+const (
+	//medianTimeSpan is the number of blocks to calculate median-time-past.
+	medianTimeSpan = 11
+	//maxFutureTime is the max time a block timestamp can be
+	//ahead of the network-adjusted time.
+	maxFutureTime = 2 * time.Hour
+)
+
+//medianTimePast returns the median of timestamps of b and its
+//previous blocks.
+func medianTimePast(tx *bolt.Tx, b *Block) (uint32, error) {
+	var err error
+	ts := make([]int, 0, medianTimeSpan)
+	for i := 0; i < medianTimeSpan; i++ {
+		if b.Timestamp == 0 {
+			return 0, errUnverifiable
+		}
+		ts = append(ts, int(b.Timestamp))
+		if b.Height == 0 || i == medianTimeSpan-1 {
+			break
+		}
+		if b, err = loadBlock(tx, b.Prev); err != nil {
+			return 0, errUnverifiable
+		}
+	}
+	sort.Ints(ts)
+	return uint32(ts[len(ts)/2]), nil
+}
+
+//checkTimestamp returns an error if the timestamp of header h
+//is not after the median-time-past of prev, or too far in the future.
+func checkTimestamp(tx *bolt.Tx, prev *Block, h *msg.HBlockHeader) error {
+	t := time.Unix(int64(h.Timestamp), 0)
+	if limit := myself.AdjustedTime().Add(maxFutureTime); t.After(limit) {
+		return fmt.Errorf("block timestamp %s is too far in the future", t)
+	}
+	mtp, err := medianTimePast(tx, prev)
+	if err == errUnverifiable {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if h.Timestamp <= mtp {
+		return fmt.Errorf("block timestamp %d is not after median-time-past %d", h.Timestamp, mtp)
+	}
+	return nil
+}
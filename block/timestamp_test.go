@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package block
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/msg"
+)
+
+func TestTimestamp(t *testing.T) {
+	last := addChain(t, 300100, 20, 0x1b0404cb, 90)
+	mtp := last.Timestamp - 5*90
+	tests := []struct {
+		timestamp uint32
+		ok        bool
+	}{
+		{mtp, false},
+		{mtp + 1, true},
+		{last.Timestamp - 1000, false},
+		{uint32(time.Now().Add(time.Hour).Unix()), true},
+		{uint32(time.Now().Add(3 * time.Hour).Unix()), false},
+	}
+	err := wdb.View(func(tx *bolt.Tx) error {
+		m, err := medianTimePast(tx, last)
+		if err != nil {
+			return err
+		}
+		if m != mtp {
+			t.Error("invalid median-time-past", m, mtp)
+		}
+		for i, test := range tests {
+			h := &msg.HBlockHeader{Timestamp: test.timestamp}
+			if err := checkTimestamp(tx, last, h); (err == nil) != test.ok {
+				t.Error(i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -68,6 +68,19 @@ func (b Bloom) Insert(data []byte) {
 	}
 }
 
+//Match returns true if data may be in the filter.
+func (b Bloom) Match(data []byte) bool {
+	var i uint32
+	for i = 0; i < HashFuncs; i++ {
+		h := sum32(i*0xfba4c795+Tweak, data)
+		h = h % (Bytelen << 3)
+		if b[h>>3]&(1<<(7&h)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
 //Codes below is from https://github.com/spaolacci/murmur3/blob/master/murmur32.go
 /*
 Copyright 2013, Sébastien Paolacci.
@@ -105,7 +118,7 @@ const (
 // following sequence (without the extra burden and the extra allocation):
 func sum32(h1 uint32, data []byte) uint32 {
 	nblocks := len(data) / 4
-	for i := 0; i < len(data); i += 4 {
+	for i := 0; i < nblocks*4; i += 4 {
 		k1 := binary.LittleEndian.Uint32(data[i : i+4])
 
 		k1 *= c1_32
@@ -0,0 +1,741 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/block"
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/peer"
+	"github.com/monarj/wallet/server"
+	"github.com/monarj/wallet/tx"
+)
+
+//parseAmount converts amount string in MONA (e.g. "1.5") to the base unit.
+func parseAmount(s string) (uint64, error) {
+	ss := strings.SplitN(s, ".", 2)
+	i, err := strconv.ParseUint(ss[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %s", s)
+	}
+	if i > (1<<63)/params.Unit {
+		return 0, fmt.Errorf("too large amount %s", s)
+	}
+	amount := i * params.Unit
+	if len(ss) == 2 {
+		frac := ss[1]
+		if len(frac) > 8 {
+			return 0, fmt.Errorf("too many decimals in amount %s", s)
+		}
+		frac += strings.Repeat("0", 8-len(frac))
+		f, err := strconv.ParseUint(frac, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount %s", s)
+		}
+		amount += f
+	}
+	return amount, nil
+}
+
+//formatAmount converts amount in the base unit to string in MONA.
+func formatAmount(a uint64) string {
+	return fmt.Sprintf("%d.%08d", a/params.Unit, a%params.Unit)
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+//readPassphrase shows prompt and reads a passphrase from a line of stdin,
+//so that passphrases are not left in the process list or the shell history.
+//Echo is turned off while reading if stdin is a terminal.
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		if echo(false) == nil {
+			defer func() {
+				if err := echo(true); err != nil {
+					log.Print(err)
+				}
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+//echo turns on or off echo of the terminal.
+func echo(on bool) error {
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+//newPassphrase reads a new passphrase twice and returns it if both match.
+func newPassphrase(prompt string) (string, error) {
+	pass, err := readPassphrase(prompt)
+	if err != nil {
+		return "", err
+	}
+	again, err := readPassphrase("enter it again: ")
+	if err != nil {
+		return "", err
+	}
+	if pass != again {
+		return "", errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
+
+func noArgs(args []string) error {
+	if len(args) != 0 {
+		return errors.New("too many arguments")
+	}
+	return nil
+}
+
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	cpuprofile := fs.String("cpuprofile", "", "write cpu profile to file")
+	memprofile := fs.String("memprofile", "", "write memory profile to file")
+	rpcaddr := fs.String("rpcaddr", "127.0.0.1:9402", "address for JSON-RPC connections")
+	rpcuser := fs.String("rpcuser", "", "username for JSON-RPC connections, the server is disabled if empty")
+	rpcpassword := fs.String("rpcpassword", "", "password for JSON-RPC connections")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			return err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if *memprofile != "" {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	if *rpcuser != "" {
+		l, _, err := server.StartRPC(&server.RPCConfig{
+			Addr:      *rpcaddr,
+			User:      *rpcuser,
+			Password:  *rpcpassword,
+			Broadcast: peer.Broadcast,
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := l.Close(); err != nil {
+				log.Print(err)
+			}
+		}()
+		log.Println("JSON-RPC server is listening on", *rpcaddr)
+	}
+	peer.Run()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-sig:
+			return nil
+		case <-t.C:
+			fmt.Printf("blocks:%d peers:%d synced:%v\n",
+				block.DownloadedBlockNumber(), peer.AliveNum(), peer.BlockSynced())
+		}
+	}
+}
+
+func runBalance(args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	b := tx.GetBalance()
+	fmt.Println("confirmed:  ", formatAmount(b.Confirmed))
+	fmt.Println("unconfirmed:", formatAmount(b.Unconfirmed))
+	fmt.Println("pending:    ", formatAmount(b.Locked))
+	fmt.Println("watch-only: ", formatAmount(b.WatchOnly))
+	return nil
+}
+
+func runNewAddress(args []string) error {
+	if len(args) > 1 {
+		return errors.New("too many arguments")
+	}
+	typ := key.AddressLegacy
+	if len(args) == 1 {
+		typ = args[0]
+	}
+	switch typ {
+	case key.AddressLegacy, key.AddressP2SHSegwit, key.AddressBech32:
+	default:
+		return errors.New("unknown address type " + typ)
+	}
+	pub, err := key.New()
+	if err != nil {
+		return err
+	}
+	adr, err := pub.AddressOf(typ)
+	if err != nil {
+		return err
+	}
+	fmt.Println(adr)
+	return nil
+}
+
+func runListAddresses(args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	for _, k := range key.Pubs() {
+		if !key.IsIssued(k) {
+			continue
+		}
+		adr, _ := k.Address()
+		if p, err := key.PathOf(k); err == nil {
+			fmt.Println(adr, p)
+			continue
+		}
+		fmt.Println(adr)
+	}
+	return nil
+}
+
+//parseSends converts pairs of address and amount to sends.
+func parseSends(args []string) ([]*tx.Send, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, errors.New("specify pairs of address and amount")
+	}
+	sends := make([]*tx.Send, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		amount, err := parseAmount(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+		sends = append(sends, &tx.Send{
+			Addr:   args[i],
+			Amount: amount,
+		})
+	}
+	return sends, nil
+}
+
+func runSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	nobroadcast := fs.Bool("nobroadcast", false, "only print the signed tx")
+	wait := fs.Duration("wait", 30*time.Second, "time to wait for peers to request the tx")
+	selection := fs.String("selection", tx.DefaultSelector.Name(),
+		"strategy of coin selection ("+strings.Join(tx.SelectorNames(), ", ")+")")
+	rbf := fs.Bool("rbf", false, "signal that the tx can be replaced by bumpfee")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	sel, err := tx.SelectorByName(*selection)
+	if err != nil {
+		return err
+	}
+	sends, err := parseSends(fs.Args())
+	if err != nil {
+		return err
+	}
+	r, err := tx.Create(&tx.Options{Selector: sel, Replaceable: *rbf}, sends...)
+	if err != nil {
+		return err
+	}
+	fmt.Println("txid:", behex.EncodeToString(r.Tx.Hash()))
+	fmt.Println("fee:", formatAmount(r.Fee), "coin selection:", r.Selector)
+	return commit(r, *nobroadcast, *wait)
+}
+
+//printRaw prints the serialized mtx in hex.
+func printRaw(mtx *msg.Tx) error {
+	var buf bytes.Buffer
+	if err := msg.Pack(&buf, *mtx); err != nil {
+		return err
+	}
+	fmt.Printf("raw: %x\n", buf.Bytes())
+	return nil
+}
+
+//commit records r in the wallet after broadcasting it, or after only
+//printing it if nobroadcast, so that nothing is recorded if broadcasting fails.
+func commit(r *tx.Result, nobroadcast bool, wait time.Duration) error {
+	if nobroadcast {
+		if err := printRaw(r.Tx); err != nil {
+			return err
+		}
+		return r.Commit()
+	}
+	if err := broadcast(r.Tx, wait); err != nil {
+		return err
+	}
+	return r.Commit()
+}
+
+//broadcast prints mtx, sends it to peers and waits for them to request it.
+func broadcast(mtx *msg.Tx, wait time.Duration) error {
+	if err := printRaw(mtx); err != nil {
+		return err
+	}
+	if err := peer.Dial(1, time.Minute); err != nil {
+		return err
+	}
+	if err := peer.Broadcast(mtx); err != nil {
+		return err
+	}
+	time.Sleep(wait)
+	fmt.Println("requested by", peer.Requested(mtx.Hash()), "peers")
+	return nil
+}
+
+func runCPFP(args []string) error {
+	fs := flag.NewFlagSet("cpfp", flag.ExitOnError)
+	nobroadcast := fs.Bool("nobroadcast", false, "only print the signed tx")
+	wait := fs.Duration("wait", 30*time.Second, "time to wait for peers to request the tx")
+	feerate := fs.String("feerate", "", "fee rate of the parent and child in MONA/kB (default the wallet fee rate)")
+	rbf := fs.Bool("rbf", false, "signal that the tx can be replaced by bumpfee")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.New("specify the parent and the index of its output")
+	}
+	parent, err := tx.ParentTx(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	index, err := strconv.ParseUint(fs.Arg(1), 10, 32)
+	if err != nil {
+		return err
+	}
+	var sends []*tx.Send
+	if fs.NArg() > 2 {
+		if sends, err = parseSends(fs.Args()[2:]); err != nil {
+			return err
+		}
+	}
+	rate := tx.FeeRate()
+	if *feerate != "" {
+		if rate, err = parseAmount(*feerate); err != nil {
+			return err
+		}
+	}
+	r, err := tx.CPFP(parent, uint32(index), rate, &tx.Options{Replaceable: *rbf}, sends...)
+	if err != nil {
+		return err
+	}
+	fmt.Println("txid:", behex.EncodeToString(r.Tx.Hash()))
+	fmt.Println("fee:", formatAmount(r.Fee))
+	return commit(r, *nobroadcast, *wait)
+}
+
+func runBumpFee(args []string) error {
+	fs := flag.NewFlagSet("bumpfee", flag.ExitOnError)
+	nobroadcast := fs.Bool("nobroadcast", false, "only print the signed tx")
+	wait := fs.Duration("wait", 30*time.Second, "time to wait for peers to request the tx")
+	feerate := fs.String("feerate", "", "new fee rate in MONA/kB (default the wallet fee rate)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("specify one txid")
+	}
+	txid, err := behex.DecodeString(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	rate := tx.FeeRate()
+	if *feerate != "" {
+		if rate, err = parseAmount(*feerate); err != nil {
+			return err
+		}
+	}
+	b, err := tx.BumpFee(txid, rate)
+	if err != nil {
+		return err
+	}
+	fmt.Println("txid:", behex.EncodeToString(b.Tx.Hash()))
+	fmt.Println("fee:", formatAmount(b.OrigFee), "->", formatAmount(b.Fee))
+	if *nobroadcast {
+		//the original tx stays pending because nothing is sent.
+		return printRaw(b.Tx)
+	}
+	if err = broadcast(b.Tx, *wait); err != nil {
+		return err
+	}
+	return b.Commit()
+}
+
+func runCreateUnsigned(args []string) error {
+	sends, err := parseSends(args)
+	if err != nil {
+		return err
+	}
+	u, err := tx.NewUnsigned(sends...)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := msg.Pack(&buf, *u.Tx); err != nil {
+		return err
+	}
+	fmt.Println("txid:", behex.EncodeToString(u.Tx.Hash()))
+	fmt.Printf("raw: %x\n", buf.Bytes())
+	for i, p := range u.Prevs {
+		fmt.Printf("input %d: amount %s script %x\n", i, formatAmount(p.Value), p.Script)
+	}
+	return nil
+}
+
+func runWatch(args []string) error {
+	if len(args) == 0 {
+		return errors.New("specify extended public keys, public keys in hex or addresses")
+	}
+	for _, a := range args {
+		var err error
+		if k, errr := key.NewKeyFromString(a); errr == nil && !k.IsPrivate() {
+			err = key.ImportXPub(a)
+		} else if b, errr := hex.DecodeString(a); errr == nil {
+			var pub *key.PublicKey
+			if pub, err = key.NewPublicKey(b); err == nil {
+				err = key.ImportPubKey(pub)
+			}
+		} else {
+			err = key.ImportAddress(a)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", a, err)
+		}
+	}
+	fmt.Println("imported as watch-only, run sync to find coins")
+	return peer.Rescan()
+}
+
+func runAddMultisig(args []string) error {
+	if len(args) < 2 {
+		return errors.New("specify the number of required signatures and keys")
+	}
+	m, err := strconv.Atoi(args[0])
+	if err != nil || m < 1 || m > len(args)-1 {
+		return errors.New("illegal number of required signatures " + args[0])
+	}
+	pubs := make([]*key.PublicKey, len(args)-1)
+	for i, a := range args[1:] {
+		if b, errr := hex.DecodeString(a); errr == nil {
+			pubs[i], err = key.NewPublicKey(b)
+		} else {
+			var hash []byte
+			if hash, err = key.DecodeAddress(a); err == nil {
+				pubs[i], err = key.FromPubHash(hash)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", a, err)
+		}
+	}
+	adr, redeem, err := tx.AddMultisig(byte(m), pubs)
+	if err != nil {
+		return err
+	}
+	fmt.Println(adr)
+	fmt.Println("redeem script:", hex.EncodeToString(redeem))
+	return peer.Rescan()
+}
+
+func runImportWIF(args []string) error {
+	if len(args) != 1 {
+		return errors.New("specify one WIF")
+	}
+	k, err := key.FromWIF(args[0])
+	if err != nil {
+		return err
+	}
+	if err = key.Add(k); err != nil {
+		return err
+	}
+	adr, _ := k.Address()
+	fmt.Println(adr)
+	return nil
+}
+
+func runCreate(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	nwords := fs.Int("words", 24, "number of words of the mnemonic (12, 15, 18, 21 or 24)")
+	lang := fs.String("lang", key.English.Name, "language of the mnemonic (english or japanese)")
+	usePass := fs.Bool("passphrase", false, "prompt for an optional passphrase which protects the seed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := noArgs(fs.Args()); err != nil {
+		return err
+	}
+	var pass string
+	if *usePass {
+		var err error
+		if pass, err = newPassphrase("passphrase of the seed: "); err != nil {
+			return err
+		}
+	}
+	var wl *key.Wordlist
+	for _, w := range key.Wordlists {
+		if w.Name == *lang {
+			wl = w
+		}
+	}
+	if wl == nil {
+		return errors.New("unknown language " + *lang)
+	}
+	m, err := key.CreateWallet(*nwords*32/3, wl, pass)
+	if err != nil {
+		return err
+	}
+	fmt.Println("write down the mnemonic below to restore the wallet:")
+	fmt.Println(m)
+	return nil
+}
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	usePass := fs.Bool("passphrase", false, "prompt for the passphrase used when the wallet was created")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("specify words of the mnemonic")
+	}
+	var pass string
+	if *usePass {
+		var err error
+		if pass, err = readPassphrase("passphrase of the seed: "); err != nil {
+			return err
+		}
+	}
+	if err := key.RestoreWallet(strings.Join(fs.Args(), " "), pass); err != nil {
+		return err
+	}
+	fmt.Println("restored the wallet, run sync to recover coins")
+	return peer.Rescan()
+}
+
+func runEncrypt(args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	pass, err := newPassphrase("new passphrase: ")
+	if err != nil {
+		return err
+	}
+	if err := key.Encrypt(pass); err != nil {
+		return err
+	}
+	fmt.Println("encrypted the wallet, use -unlock to unlock it")
+	return nil
+}
+
+func runChangePassphrase(args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	old, err := readPassphrase("current passphrase: ")
+	if err != nil {
+		return err
+	}
+	pass, err := newPassphrase("new passphrase: ")
+	if err != nil {
+		return err
+	}
+	return key.ChangePassphrase(old, pass)
+}
+
+func runImportSeed(args []string) error {
+	if len(args) != 1 {
+		return errors.New("specify one seed in hex")
+	}
+	seed, err := hex.DecodeString(args[0])
+	if err != nil {
+		return err
+	}
+	if err = key.SetSeed(seed); err != nil {
+		return err
+	}
+	fmt.Println("imported the seed, run sync to recover coins")
+	return peer.Rescan()
+}
+
+func runDumpSeed(args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	seed, err := key.Seed()
+	if err != nil {
+		return err
+	}
+	fmt.Println(hex.EncodeToString(seed))
+	return nil
+}
+
+func runAbandon(args []string) error {
+	if len(args) != 1 {
+		return errors.New("specify one txid")
+	}
+	txid, err := behex.DecodeString(args[0])
+	if err != nil {
+		return err
+	}
+	return tx.Abandon(txid)
+}
+
+func runDumpWIF(args []string) error {
+	if len(args) != 1 {
+		return errors.New("specify one address")
+	}
+	for _, k := range key.Pubs() {
+		if adr, _ := k.Address(); adr == args[0] {
+			priv, err := key.Find(k)
+			if err != nil {
+				return err
+			}
+			fmt.Println(priv.WIFAddress())
+			return nil
+		}
+	}
+	return errors.New("address not found in the wallet")
+}
+
+func runListCoins(args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	for _, c := range tx.SortedCoins() {
+		height := "unconfirmed"
+		if b, err := block.LoadBlock(c.Block); err == nil {
+			height = strconv.FormatUint(b.Height, 10)
+		}
+		if c.Locked() {
+			height += " pending"
+		}
+		fmt.Printf("%s:%d %s %s\n", behex.EncodeToString(c.TxHash), c.TxIndex,
+			formatAmount(c.Value), height)
+	}
+	return nil
+}
+
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	q := &tx.Query{}
+	fs.StringVar(&q.Address, "address", "", "show only txs with the counterparty address")
+	fs.Uint64Var(&q.MinHeight, "from", 0, "show only txs in blocks at or after the height")
+	fs.Uint64Var(&q.MaxHeight, "to", 0, "show only txs in blocks at or before the height (0 means no limit)")
+	fs.IntVar(&q.Offset, "offset", 0, "number of txs to skip")
+	fs.IntVar(&q.Limit, "limit", 0, "max number of txs (0 means no limit)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := noArgs(fs.Args()); err != nil {
+		return err
+	}
+	hs, err := tx.Histories(q)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(os.Stdout)
+	err = w.Write([]string{"txid", "direction", "amount", "fee", "height", "block", "time", "addresses"})
+	if err != nil {
+		return err
+	}
+	for _, h := range hs {
+		amount := formatAmount(uint64(h.Amount))
+		if h.Amount < 0 {
+			amount = "-" + formatAmount(uint64(-h.Amount))
+		}
+		err := w.Write([]string{
+			behex.EncodeToString(h.TxID),
+			h.Direction.String(),
+			amount,
+			formatAmount(h.Fee),
+			strconv.FormatUint(h.Height, 10),
+			behex.EncodeToString(h.Block),
+			time.Unix(h.Time, 0).UTC().Format(time.RFC3339),
+			strings.Join(h.Addresses, " "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func runPeers(args []string) error {
+	if err := noArgs(args); err != nil {
+		return err
+	}
+	peer.Resolve()
+	for _, p := range peer.Candidates() {
+		fmt.Println(p)
+	}
+	return nil
+}
@@ -34,7 +34,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"path"
+	"os"
+	"time"
 
 	"encoding/json"
 
@@ -45,25 +46,74 @@ import (
 bucket key value
 
 status "lastmerkle" height
+status "besttip" hash
+status "blockformat" version of format of block bucket
 lastblock height hash
-block hash (height,prev)
+block hash (height,work,header)
 blockheight height hash
-key pub priv
-coin hash json(Coin)
-spend <hash index>,hash
+key pub priv(encrypted if crypt has salt)
+hd "seed" seed(encrypted if crypt has salt)
+hd "chain/<account>/<change>" json(next,used,derived index of the chain)
+hd "xpub/<account>/<change>" extended public key of the chain
+hdpath pub json(Path)
+hd "watchchain/<account>/<change>" json(next,used,derived index of the watch-only chain)
+hd "watchxpub/<account>/<change>" extended public key of the watch-only chain
+watchxpub xpub account number
+watch pub address
+watchaddr pubhash address
+crypt "salt" salt of scrypt
+crypt "check" encrypted text to check passphrase
+coin <hash index> packed Coin(Pubkey is pubhash for watch-only addresses, redeem script for P2SH)
+spent <hash index> spender txid+flag(value is added to txhistory)+packed Coin
+txhistory txid json(History)
+pendingtx txid json(Pending)
+locked <hash index> txid of the pending tx spending the coin
 scripthash hash hash
+redeemscript hash redeem script of P2SH
 */
 
-//DB is bolt.DB for operating database.
-var DB *bolt.DB
+//FileName is the default file name of the database.
+const FileName = "monarj_wallet.db"
 
-func init() {
-	dbpath := path.Join(".", "monarj_wallet.db")
-	var err error
-	DB, err = bolt.Open(dbpath, 0644, nil)
+//DB is a handle of the wallet database.
+type DB struct {
+	*bolt.DB
+}
+
+//Options is options for opening the database.
+type Options struct {
+	//Timeout is the amount of time to wait to obtain a file lock.
+	//When set to zero it will wait indefinitely.
+	Timeout time.Duration
+	//ReadOnly opens the database in read-only mode.
+	ReadOnly bool
+}
+
+//Open opens the database at path, creating it if it doesn't exist.
+//opt can be nil to use default options.
+func Open(path string, opt *Options) (*DB, error) {
+	bopt := &bolt.Options{}
+	if opt != nil {
+		bopt.Timeout = opt.Timeout
+		bopt.ReadOnly = opt.ReadOnly
+	}
+	//the file has encrypted keys and watch-only data which are not encrypted.
+	d, err := bolt.Open(path, 0600, bopt)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if !bopt.ReadOnly {
+		//files created by older versions are world-readable.
+		if err := os.Chmod(path, 0600); err != nil {
+			log.Println(err)
+		}
 	}
+	return &DB{DB: d}, nil
+}
+
+//Close releases all resources of the database.
+func (d *DB) Close() error {
+	return d.DB.Close()
 }
 
 // Tob returns an 8-byte big endian representation of v.
@@ -177,7 +227,7 @@ func Count(tx *bolt.Tx, bucket string, prefix []byte) (int, error) {
 		return 0, errors.New("bucket not found " + bucket)
 	}
 	c := b.Cursor()
-	for k, _ := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
 		cnt++
 	}
 	return cnt, nil
@@ -191,7 +241,7 @@ func GetStrings(tx *bolt.Tx, bucket string, prefix []byte) ([]string, error) {
 		return nil, errors.New("bucket not found " + bucket)
 	}
 	c := b.Cursor()
-	for k, v := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, v = c.Next() {
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
 		var str string
 		if err := B2v(v, &str); err != nil {
 			return nil, err
@@ -309,9 +359,9 @@ func GetPrefixs(tx *bolt.Tx, bucket string) ([]string, error) {
 	return cnt, err
 }
 
-//Batch puts sets one key/value pair by Batch.
-func Batch(bucket string, key []byte, value interface{}) error {
-	return DB.Batch(func(tx *bolt.Tx) error {
+//BatchPut sets one key/value pair by Batch.
+func (d *DB) BatchPut(bucket string, key []byte, value interface{}) error {
+	return d.Batch(func(tx *bolt.Tx) error {
 		return Put(tx, bucket, key, value)
 	})
 }
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package key
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/monarj/wallet/base58check"
+	"github.com/monarj/wallet/bech32"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
+)
+
+//AddrType is the type of an address.
+type AddrType byte
+
+//Types of addresses.
+const (
+	AddrP2PKH AddrType = iota
+	AddrP2SH
+	AddrP2WPKH
+	AddrP2WSH
+	//AddrWitness is a witness program whose version is not 0.
+	AddrWitness
+)
+
+var addrTypeNames = []string{"P2PKH", "P2SH", "P2WPKH", "P2WSH", "witness"}
+
+func (t AddrType) String() string {
+	if int(t) < len(addrTypeNames) {
+		return addrTypeNames[t]
+	}
+	return "unknown"
+}
+
+//Address is a decoded address of base58check or bech32.
+type Address struct {
+	Type AddrType
+	//Version is the witness version if the address is segwit.
+	Version int
+	//Hash is the pubkey hash, the script hash or the witness program.
+	Hash []byte
+}
+
+//ParseAddress decodes adr for the current network.
+//Both of the current and the legacy P2SH prefixes are accepted.
+//It returns an error if the prefix or hrp is unknown.
+func ParseAddress(adr string) (*Address, error) {
+	hrp := params.Net.Bech32HRP
+	if strings.HasPrefix(strings.ToLower(adr), hrp+"1") {
+		ver, prog, err := bech32.DecodeAddress(hrp, adr)
+		if err != nil {
+			return nil, err
+		}
+		a := &Address{
+			Type:    AddrWitness,
+			Version: ver,
+			Hash:    prog,
+		}
+		switch {
+		case ver == 0 && len(prog) == 20:
+			a.Type = AddrP2WPKH
+		case ver == 0:
+			a.Type = AddrP2WSH
+		}
+		return a, nil
+	}
+	pb, err := base58check.Decode(adr)
+	if err != nil {
+		return nil, err
+	}
+	if len(pb) != 21 {
+		return nil, errors.New("illegal length of address " + adr)
+	}
+	a := &Address{Hash: pb[1:]}
+	switch pb[0] {
+	case params.Net.AddressHeader:
+		a.Type = AddrP2PKH
+	case params.Net.P2SHHeader, params.Net.P2SHHeaderAlt:
+		a.Type = AddrP2SH
+	default:
+		return nil, fmt.Errorf("unknown prefix %d of address for %s", pb[0], params.Net.ID)
+	}
+	return a, nil
+}
+
+//String encodes the address.
+func (a *Address) String() string {
+	switch a.Type {
+	case AddrP2PKH:
+		return base58check.Encode(params.Net.AddressHeader, a.Hash)
+	case AddrP2SH:
+		return base58check.Encode(params.Net.P2SHHeader, a.Hash)
+	}
+	adr, err := bech32.EncodeAddress(params.Net.Bech32HRP, a.Version, a.Hash)
+	if err != nil {
+		return ""
+	}
+	return adr
+}
+
+//Script returns the output script which pays to the address.
+func (a *Address) Script() []byte {
+	switch a.Type {
+	case AddrP2PKH:
+		return script.PayToPubKeyHash(a.Hash)
+	case AddrP2SH:
+		return script.PayToScriptHash(a.Hash)
+	}
+	return script.PayToWitness(a.Version, a.Hash)
+}
+
+//ScriptAddress returns the address of the output script pkscript,
+//or nil if the script doesn't have an address.
+func ScriptAddress(pkscript []byte) *Address {
+	t := script.Classify(pkscript)
+	switch t.Class {
+	case script.PubKeyHash:
+		return &Address{Type: AddrP2PKH, Hash: t.Data[0]}
+	case script.ScriptHash:
+		return &Address{Type: AddrP2SH, Hash: t.Data[0]}
+	}
+	ver, prog, ok := script.WitnessProgram(pkscript)
+	switch {
+	case t.Class == script.WitnessPubKeyHash:
+		return &Address{Type: AddrP2WPKH, Hash: prog}
+	case t.Class == script.WitnessScriptHash:
+		return &Address{Type: AddrP2WSH, Hash: prog}
+	case t.Class == script.WitnessUnknown && ok:
+		return &Address{Type: AddrWitness, Version: ver, Hash: prog}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package key
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/monarj/wallet/base58check"
+)
+
+func TestParseAddress(t *testing.T) {
+	hash, err := hex.DecodeString("d94987ba89c258372030bc9d610f895477578964")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog32 := bytes.Repeat([]byte{0xab}, 32)
+	tests := []struct {
+		addr   *Address
+		script string
+	}{
+		{&Address{Type: AddrP2PKH, Hash: hash}, "76a914d94987ba89c258372030bc9d610f89547757896488ac"},
+		{&Address{Type: AddrP2SH, Hash: hash}, "a914d94987ba89c258372030bc9d610f89547757896487"},
+		{&Address{Type: AddrP2WPKH, Hash: hash}, "0014d94987ba89c258372030bc9d610f895477578964"},
+		{&Address{Type: AddrP2WSH, Hash: prog32}, "0020" + strings.Repeat("ab", 32)},
+		{&Address{Type: AddrWitness, Version: 1, Hash: prog32}, "5120" + strings.Repeat("ab", 32)},
+	}
+	for _, tt := range tests {
+		adr := tt.addr.String()
+		if adr == "" {
+			t.Fatal("cannot encode", tt.addr.Type)
+		}
+		a, err := ParseAddress(adr)
+		if err != nil {
+			t.Fatal(adr, err)
+		}
+		if a.Type != tt.addr.Type || a.Version != tt.addr.Version || !bytes.Equal(a.Hash, tt.addr.Hash) {
+			t.Error("address unmatched", adr, a.Type)
+		}
+		if s := hex.EncodeToString(a.Script()); s != tt.script {
+			t.Error("script unmatched", adr, s)
+		}
+		if sa := ScriptAddress(a.Script()); sa == nil || sa.String() != adr {
+			t.Error("script address unmatched", adr)
+		}
+	}
+
+	a, err := ParseAddress("MTi4x2NtDpdyXSwEvwU3aZ1Uronz1JBNC3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Type != AddrP2PKH || !bytes.Equal(a.Hash, hash) {
+		t.Error("P2PKH address unmatched")
+	}
+	adr := WitnessAddress(hash)
+	if !strings.HasPrefix(adr, "mona1q") {
+		t.Error("illegal bech32 address", adr)
+	}
+	if _, err = ParseAddress(strings.ToUpper(adr)); err != nil {
+		t.Error("upper case bech32 address must be accepted", err)
+	}
+	for _, bad := range []string{
+		base58check.Encode(0x00, hash),
+		base58check.Encode(50, hash[:19]),
+		"tmona1qm9yc0w5fcfvrwgpshjwkzrufg3m40ztyng2wnj",
+		"",
+	} {
+		if _, err := ParseAddress(bad); err == nil {
+			t.Error("illegal address must be rejected", bad)
+		}
+	}
+}
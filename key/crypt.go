@@ -0,0 +1,334 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+package key
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/db"
+	"golang.org/x/crypto/scrypt"
+)
+
+//parameters of scrypt to derive the encryption key from a passphrase.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+var (
+	//ErrLocked is returned when private keys are needed while the wallet is locked.
+	ErrLocked = errors.New("the wallet is locked, unlock it with the passphrase")
+	//ErrPassphrase is returned when the passphrase is wrong.
+	ErrPassphrase = errors.New("the passphrase is incorrect")
+	//ErrNotEncrypted is returned when the wallet is not encrypted.
+	ErrNotEncrypted = errors.New("the wallet is not encrypted")
+
+	//checkText is encrypted to verify passphrases.
+	checkText = []byte("monarj wallet")
+)
+
+var (
+	//secret is the encryption key while the wallet is unlocked.
+	secret    []byte
+	lockTimer *time.Timer
+	cmutex    sync.RWMutex
+)
+
+//IsEncrypted returns true if private keys in the wallet are encrypted.
+func IsEncrypted() bool {
+	enc := false
+	err := wdb.View(func(tx *bolt.Tx) error {
+		enc = isEncrypted(tx)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return enc
+}
+
+func isEncrypted(tx *bolt.Tx) bool {
+	return db.HasKey(tx, "crypt", []byte("salt"))
+}
+
+//IsLocked returns true if the wallet is encrypted and not unlocked.
+func IsLocked() bool {
+	cmutex.RLock()
+	defer cmutex.RUnlock()
+	return secret == nil && IsEncrypted()
+}
+
+//Encrypt encrypts all private keys and the seed with passphrase.
+//The wallet is locked after that.
+func Encrypt(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("passphrase is empty")
+	}
+	return wdb.Update(func(tx *bolt.Tx) error {
+		if isEncrypted(tx) {
+			return errors.New("the wallet is already encrypted")
+		}
+		k, err := newSecret(tx, passphrase)
+		if err != nil {
+			return err
+		}
+		return rekey(tx, nil, k)
+	})
+}
+
+//ChangePassphrase encrypts private keys and the seed with newpass again.
+func ChangePassphrase(oldpass, newpass string) error {
+	if newpass == "" {
+		return errors.New("passphrase is empty")
+	}
+	var k []byte
+	err := wdb.Update(func(tx *bolt.Tx) error {
+		if !isEncrypted(tx) {
+			return ErrNotEncrypted
+		}
+		old, err := checkPassphrase(tx, oldpass)
+		if err != nil {
+			return err
+		}
+		defer zero(old)
+		if k, err = newSecret(tx, newpass); err != nil {
+			return err
+		}
+		return rekey(tx, old, k)
+	})
+	if err != nil {
+		return err
+	}
+	cmutex.Lock()
+	defer cmutex.Unlock()
+	if secret == nil {
+		zero(k)
+		return nil
+	}
+	zero(secret)
+	secret = k
+	return nil
+}
+
+//Unlock decrypts private keys with passphrase until timeout passes.
+//The wallet is unlocked until Lock is called if timeout is 0.
+func Unlock(passphrase string, timeout time.Duration) error {
+	var k []byte
+	err := wdb.View(func(tx *bolt.Tx) error {
+		if !isEncrypted(tx) {
+			return ErrNotEncrypted
+		}
+		var err error
+		k, err = checkPassphrase(tx, passphrase)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	cmutex.Lock()
+	defer cmutex.Unlock()
+	secret = k
+	if lockTimer != nil {
+		lockTimer.Stop()
+		lockTimer = nil
+	}
+	if timeout > 0 {
+		lockTimer = time.AfterFunc(timeout, Lock)
+	}
+	return nil
+}
+
+//Lock forgets the key to decrypt private keys.
+func Lock() {
+	cmutex.Lock()
+	defer cmutex.Unlock()
+	zero(secret)
+	secret = nil
+	if lockTimer != nil {
+		lockTimer.Stop()
+		lockTimer = nil
+	}
+}
+
+//newSecret makes a new salt and saves it with the check text
+//encrypted by the key from passphrase.
+func newSecret(tx *bolt.Tx, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	k, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	check, err := seal(k, checkText)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Put(tx, "crypt", []byte("salt"), salt); err != nil {
+		return nil, err
+	}
+	return k, db.Put(tx, "crypt", []byte("check"), check)
+}
+
+//checkPassphrase returns the key from passphrase if it is correct.
+func checkPassphrase(tx *bolt.Tx, passphrase string) ([]byte, error) {
+	salt, err := db.Get(tx, "crypt", []byte("salt"), nil)
+	if err != nil {
+		return nil, err
+	}
+	check, err := db.Get(tx, "crypt", []byte("check"), nil)
+	if err != nil {
+		return nil, err
+	}
+	k, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = unseal(k, check); err != nil {
+		return nil, ErrPassphrase
+	}
+	return k, nil
+}
+
+//rekey decrypts private keys and the seed by from and encrypts them by to.
+//nil means plain text.
+func rekey(tx *bolt.Tx, from, to []byte) error {
+	type kv struct {
+		bucket string
+		key    []byte
+		value  []byte
+	}
+	var kvs []*kv
+	if b := tx.Bucket([]byte("key")); b != nil {
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			kvs = append(kvs, &kv{"key", append([]byte{}, k...), append([]byte{}, v...)})
+		}
+	}
+	if b := tx.Bucket([]byte("hd")); b != nil {
+		if v := b.Get([]byte("seed")); v != nil {
+			kvs = append(kvs, &kv{"hd", []byte("seed"), append([]byte{}, v...)})
+		}
+	}
+	for _, e := range kvs {
+		v := e.value
+		var err error
+		if from != nil {
+			if v, err = unseal(from, v); err != nil {
+				return err
+			}
+		}
+		if to != nil {
+			plain := v
+			v, err = seal(to, plain)
+			if from != nil {
+				zero(plain)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err = db.Put(tx, e.bucket, e.key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//encrypt encrypts dat if the wallet is encrypted.
+func encrypt(tx *bolt.Tx, dat []byte) ([]byte, error) {
+	if !isEncrypted(tx) {
+		return dat, nil
+	}
+	cmutex.RLock()
+	defer cmutex.RUnlock()
+	if secret == nil {
+		return nil, ErrLocked
+	}
+	return seal(secret, dat)
+}
+
+//decrypt decrypts dat if the wallet is encrypted.
+func decrypt(tx *bolt.Tx, dat []byte) ([]byte, error) {
+	if !isEncrypted(tx) {
+		r := make([]byte, len(dat))
+		copy(r, dat)
+		return r, nil
+	}
+	cmutex.RLock()
+	defer cmutex.RUnlock()
+	if secret == nil {
+		return nil, ErrLocked
+	}
+	return unseal(secret, dat)
+}
+
+//seal encrypts dat by AES-256-GCM with k, and returns nonce+ciphertext.
+func seal(k, dat []byte) ([]byte, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, dat, nil), nil
+}
+
+//unseal decrypts nonce+ciphertext made by seal with k.
+func unseal(k, dat []byte) ([]byte, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(dat) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	n := gcm.NonceSize()
+	return gcm.Open(nil, dat[:n], dat[n:], nil)
+}
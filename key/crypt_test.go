@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+package key
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/db"
+)
+
+func TestCrypt(t *testing.T) {
+	closer := openDB(t)
+	defer closer()
+	defer Lock()
+
+	imported, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Add(imported); err != nil {
+		t.Fatal(err)
+	}
+	hd, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := Seed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsEncrypted() || IsLocked() {
+		t.Fatal("wallet is encrypted")
+	}
+	if err = Unlock("pass", 0); err != ErrNotEncrypted {
+		t.Error("unencrypted wallet is unlocked", err)
+	}
+
+	if err = Encrypt("pass"); err != nil {
+		t.Fatal(err)
+	}
+	if !IsLocked() {
+		t.Fatal("wallet is not locked")
+	}
+	err = wdb.View(func(tx *bolt.Tx) error {
+		dat, errr := db.Get(tx, "key", imported.PublicKey.Serialize(), nil)
+		if errr != nil {
+			return errr
+		}
+		if bytes.Contains(dat, imported.Serialize()) {
+			t.Error("private key is not encrypted")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pub := range []*PublicKey{imported.PublicKey, hd} {
+		if _, err = Find(pub); err != ErrLocked {
+			t.Error("private key is found while locked", err)
+		}
+	}
+	if _, err = Seed(); err != ErrLocked {
+		t.Error("seed is got while locked", err)
+	}
+	if next, errr := New(); errr != nil || bytes.Equal(next.Serialize(), hd.Serialize()) {
+		t.Error("new key is not derived while locked")
+	}
+	if err = Unlock("wrong", 0); err != ErrPassphrase {
+		t.Error("wallet is unlocked by wrong passphrase", err)
+	}
+
+	if err = Unlock("pass", 0); err != nil {
+		t.Fatal(err)
+	}
+	priv, err := Find(imported.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(priv.Serialize(), imported.Serialize()) {
+		t.Error("decrypted key is different")
+	}
+	if _, err = Find(hd); err != nil {
+		t.Error(err)
+	}
+	if s, errr := Seed(); errr != nil || !bytes.Equal(s, seed) {
+		t.Error("decrypted seed is different", errr)
+	}
+
+	if err = ChangePassphrase("wrong", "pass2"); err != ErrPassphrase {
+		t.Error("passphrase is changed by wrong one", err)
+	}
+	if err = ChangePassphrase("pass", "pass2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Find(imported.PublicKey); err != nil {
+		t.Error("wallet is locked after changing passphrase", err)
+	}
+	Lock()
+	if err = Unlock("pass", 0); err != ErrPassphrase {
+		t.Error("old passphrase is accepted", err)
+	}
+	if err = Unlock("pass2", 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if s, errr := Seed(); errr != nil || !bytes.Equal(s, seed) {
+		t.Error("decrypted seed is different", errr)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if !IsLocked() {
+		t.Error("wallet is not locked after timeout")
+	}
+}
+
+func TestNewLocked(t *testing.T) {
+	closer := openDB(t)
+	defer closer()
+	defer Lock()
+
+	if err := Encrypt("pass"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(); err != ErrLocked {
+		t.Error("seed is created while locked", err)
+	}
+	if err := Unlock("pass", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(); err != nil {
+		t.Error(err)
+	}
+}
@@ -382,9 +382,9 @@ func (k *ExtendedKey) String() string {
 	//   child num (4) || chain code (32) || key data (33) || checksum (4)
 	serializedBytes := make([]byte, 0, serializedKeyLen+4)
 	if k.isPrivate {
-		serializedBytes = append(serializedBytes, params.HDPrivateKeyID...)
+		serializedBytes = append(serializedBytes, params.Net.HDPrivateKeyID...)
 	} else {
-		serializedBytes = append(serializedBytes, params.HDPublicKeyID...)
+		serializedBytes = append(serializedBytes, params.Net.HDPublicKeyID...)
 	}
 	serializedBytes = append(serializedBytes, depthByte)
 	serializedBytes = append(serializedBytes, k.parentFP...)
@@ -0,0 +1,453 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+package key
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/params"
+)
+
+const (
+	//GapLimit is the number of unused keys derived ahead on each chain.
+	//Payments to them are found when the wallet is restored from the seed.
+	GapLimit = 20
+	//External is the chain of receiving addresses.
+	External uint32 = 0
+	//Internal is the chain of change addresses.
+	Internal uint32 = 1
+
+	//purpose is the purpose field of BIP44 paths.
+	purpose = 44
+)
+
+//ErrNoSeed is returned when the wallet doesn't have a seed.
+var ErrNoSeed = errors.New("the wallet has no seed")
+
+//Path is a BIP44 path m/44'/coin'/account'/change/index of a key.
+//If Watch is true, Account is the number of an imported extended public key
+//and the path is relative to it.
+type Path struct {
+	Account uint32
+	Change  uint32
+	Index   uint32
+	Watch   bool `json:",omitempty"`
+}
+
+//String returns the path in the form of m/44'/22'/0'/0/1,
+//or watch/0/0/1 if the key is derived from an imported extended public key.
+func (p *Path) String() string {
+	if p.Watch {
+		return fmt.Sprintf("watch/%d/%d/%d", p.Account, p.Change, p.Index)
+	}
+	return fmt.Sprintf("m/%d'/%d'/%d'/%d/%d",
+		purpose, params.Net.HDCoinType, p.Account, p.Change, p.Index)
+}
+
+//chain is the state of an external or internal chain of an account.
+type chain struct {
+	//Next is the index of the key which will be issued next.
+	Next uint32
+	//Used is the index of the last key which received coins plus one.
+	Used uint32
+	//Derived is the number of keys derived and stored.
+	Derived uint32
+}
+
+var newKeysFuncs []func(pubs []*PublicKey)
+
+//OnNewKeys registers f which is called with keys derived ahead
+//after the bloom filter was made.
+//f is called with nil when addresses or scripts are added to the filter.
+func OnNewKeys(f func(pubs []*PublicKey)) {
+	newKeysFuncs = append(newKeysFuncs, f)
+}
+
+func newKeys(pubs []*PublicKey) {
+	if len(pubs) == 0 {
+		return
+	}
+	for _, f := range newKeysFuncs {
+		f(pubs)
+	}
+}
+
+//filterChanged calls functions registered by OnNewKeys
+//when the bloom filter changed without new keys.
+func filterChanged() {
+	for _, f := range newKeysFuncs {
+		f(nil)
+	}
+}
+
+//HasSeed returns true if the wallet has a seed.
+func HasSeed() bool {
+	has := false
+	err := wdb.View(func(tx *bolt.Tx) error {
+		has = db.HasKey(tx, "hd", []byte("seed"))
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return has
+}
+
+//Seed returns the seed of the wallet.
+func Seed() ([]byte, error) {
+	var seed []byte
+	err := wdb.View(func(tx *bolt.Tx) error {
+		var err error
+		seed, err = getSeed(tx)
+		return err
+	})
+	return seed, err
+}
+
+//getSeed returns the decrypted seed.
+func getSeed(tx *bolt.Tx) ([]byte, error) {
+	dat, err := db.Get(tx, "hd", []byte("seed"), nil)
+	if err != nil {
+		return nil, ErrNoSeed
+	}
+	return decrypt(tx, dat)
+}
+
+//SetSeed registers seed as the seed of the wallet, and derives keys
+//of the external and internal chains of account 0 up to GapLimit.
+func SetSeed(seed []byte) error {
+	if _, err := NewMaster(seed); err != nil {
+		return err
+	}
+	var pubs []*PublicKey
+	err := wdb.Update(func(tx *bolt.Tx) error {
+		if db.HasKey(tx, "hd", []byte("seed")) {
+			return errors.New("the wallet already has a seed")
+		}
+		dat, err := encrypt(tx, seed)
+		if err != nil {
+			return err
+		}
+		if err = db.Put(tx, "hd", []byte("seed"), dat); err != nil {
+			return err
+		}
+		for _, change := range []uint32{External, Internal} {
+			p, err := lookahead(tx, &Path{Change: change})
+			if err != nil {
+				return err
+			}
+			pubs = append(pubs, p...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	newKeys(pubs)
+	return nil
+}
+
+//New returns the next receiving key.
+//It creates a random seed if the wallet doesn't have one,
+//which fails with ErrLocked if the wallet is encrypted and locked.
+func New() (*PublicKey, error) {
+	if !HasSeed() {
+		seed, err := GenerateSeed(RecommendedSeedLen)
+		if err != nil {
+			return nil, err
+		}
+		if err = SetSeed(seed); err != nil {
+			return nil, err
+		}
+		log.Println("created a new seed")
+	}
+	return issue(&Path{Change: External})
+}
+
+//NewChange returns the next change key.
+func NewChange() (*PublicKey, error) {
+	return issue(&Path{Change: Internal})
+}
+
+//NewWatchChange returns the next change key of the imported
+//extended public key of account.
+func NewWatchChange(account uint32) (*PublicKey, error) {
+	return issue(&Path{Account: account, Change: Internal, Watch: true})
+}
+
+//issue returns the next key in the chain of cp and derives keys ahead.
+//It works while the wallet is locked because only public keys are derived.
+func issue(cp *Path) (*PublicKey, error) {
+	var pub *PublicKey
+	var pubs []*PublicKey
+	err := wdb.Update(func(tx *bolt.Tx) error {
+		k, err := chainPub(tx, cp)
+		if err != nil {
+			return err
+		}
+		c, err := getChain(tx, cp)
+		if err != nil {
+			return err
+		}
+		for pub == nil {
+			pub, err = childPub(k, c.Next)
+			if err != nil && err != ErrInvalidChild {
+				return err
+			}
+			c.Next++
+		}
+		if err = putChain(tx, cp, c); err != nil {
+			return err
+		}
+		pubs, err = lookahead(tx, cp)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	newKeys(pubs)
+	return pub, nil
+}
+
+//MarkUsed records that pub received coins, and derives keys ahead
+//so that GapLimit unused keys follow it.
+//It does nothing if pub is not derived from the seed or an imported
+//extended public key.
+func MarkUsed(pub *PublicKey) error {
+	var pubs []*PublicKey
+	err := wdb.Update(func(tx *bolt.Tx) error {
+		var p Path
+		if _, err := db.Get(tx, "hdpath", pub.Serialize(), &p); err != nil {
+			return nil
+		}
+		c, err := getChain(tx, &p)
+		if err != nil {
+			return err
+		}
+		if p.Index < c.Used {
+			return nil
+		}
+		c.Used = p.Index + 1
+		if c.Next < c.Used {
+			c.Next = c.Used
+		}
+		if err = putChain(tx, &p, c); err != nil {
+			return err
+		}
+		pubs, err = lookahead(tx, &p)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	newKeys(pubs)
+	return nil
+}
+
+//PathOf returns the derivation path of pub.
+func PathOf(pub *PublicKey) (*Path, error) {
+	var p Path
+	err := wdb.View(func(tx *bolt.Tx) error {
+		_, err := db.Get(tx, "hdpath", pub.Serialize(), &p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+//IsIssued returns false if pub is a key derived ahead and not issued yet.
+func IsIssued(pub *PublicKey) bool {
+	issued := true
+	err := wdb.View(func(tx *bolt.Tx) error {
+		var p Path
+		if _, err := db.Get(tx, "hdpath", pub.Serialize(), &p); err != nil {
+			return nil
+		}
+		c, err := getChain(tx, &p)
+		if err != nil {
+			return err
+		}
+		issued = p.Index < c.Next
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return issued
+}
+
+//chainName returns the name of the chain of p in the hd bucket with prefix.
+func chainName(prefix string, p *Path) []byte {
+	if p.Watch {
+		prefix = "watch" + prefix
+	}
+	return []byte(fmt.Sprintf("%s/%d/%d", prefix, p.Account, p.Change))
+}
+
+func getChain(tx *bolt.Tx, p *Path) (*chain, error) {
+	var c chain
+	if !db.HasKey(tx, "hd", chainName("chain", p)) {
+		return &c, nil
+	}
+	_, err := db.Get(tx, "hd", chainName("chain", p), &c)
+	return &c, err
+}
+
+func putChain(tx *bolt.Tx, p *Path, c *chain) error {
+	return db.Put(tx, "hd", chainName("chain", p), c)
+}
+
+//chainKey derives the extended key of m/44'/coin'/account'/change from the seed.
+func chainKey(tx *bolt.Tx, account, change uint32) (*ExtendedKey, error) {
+	seed, err := getSeed(tx)
+	if err != nil {
+		return nil, err
+	}
+	k, err := NewMaster(seed)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range []uint32{
+		HardenedKeyStart + purpose,
+		HardenedKeyStart + params.Net.HDCoinType,
+		HardenedKeyStart + account,
+		change,
+	} {
+		if k, err = k.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+//child returns the private key at index of the chain key k.
+func child(k *ExtendedKey, index uint32) (*PrivateKey, error) {
+	c, err := k.Child(index)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := c.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewPrivateKey(priv.Serialize()), nil
+}
+
+//chainPub returns the extended public key of m/44'/coin'/account'/change,
+//which is saved so that keys can be derived while the wallet is locked.
+func chainPub(tx *bolt.Tx, p *Path) (*ExtendedKey, error) {
+	name := chainName("xpub", p)
+	var xpub string
+	if _, err := db.Get(tx, "hd", name, &xpub); err == nil {
+		return NewKeyFromString(xpub)
+	}
+	var k *ExtendedKey
+	var err error
+	if p.Watch {
+		k, err = watchChainKey(tx, p)
+	} else {
+		k, err = chainKey(tx, p.Account, p.Change)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if k, err = k.Neuter(); err != nil {
+		return nil, err
+	}
+	return k, db.Put(tx, "hd", name, k.String())
+}
+
+//childPub returns the public key at index of the chain key k.
+func childPub(k *ExtendedKey, index uint32) (*PublicKey, error) {
+	c, err := k.Child(index)
+	if err != nil {
+		return nil, err
+	}
+	return c.Address()
+}
+
+//derive derives the private key of path from the seed.
+func derive(tx *bolt.Tx, p *Path) (*PrivateKey, error) {
+	if p.Watch {
+		return nil, ErrWatchOnly
+	}
+	k, err := chainKey(tx, p.Account, p.Change)
+	if err != nil {
+		return nil, err
+	}
+	return child(k, p.Index)
+}
+
+//lookahead derives and stores keys in the chain so that GapLimit keys
+//follow the last issued or used key. It returns the new keys.
+//Keys which are already in the wallet are skipped and their paths are
+//never overwritten.
+func lookahead(tx *bolt.Tx, cp *Path) ([]*PublicKey, error) {
+	c, err := getChain(tx, cp)
+	if err != nil {
+		return nil, err
+	}
+	end := c.Next
+	if end < c.Used {
+		end = c.Used
+	}
+	end += GapLimit
+	if c.Derived >= end {
+		return nil, nil
+	}
+	k, err := chainPub(tx, cp)
+	if err != nil {
+		return nil, err
+	}
+	var pubs []*PublicKey
+	for ; c.Derived < end; c.Derived++ {
+		p := &Path{Account: cp.Account, Change: cp.Change, Index: c.Derived, Watch: cp.Watch}
+		pub, err := childPub(k, p.Index)
+		if err == ErrInvalidChild {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if db.HasKey(tx, "hdpath", pub.Serialize()) || db.HasKey(tx, "key", pub.Serialize()) {
+			log.Println("skipped the key", p, "which is already in the wallet")
+			continue
+		}
+		if err = db.Put(tx, "hdpath", pub.Serialize(), p); err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, pub)
+	}
+	return pubs, putChain(tx, cp, c)
+}
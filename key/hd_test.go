@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+package key
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/monarj/wallet/db"
+)
+
+func openDB(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.Open(filepath.Join(dir, db.FileName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Init(d)
+	return func() {
+		if err := d.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestHD(t *testing.T) {
+	seed, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var news []*PublicKey
+	OnNewKeys(func(pubs []*PublicKey) {
+		news = append(news, pubs...)
+	})
+	defer func() {
+		newKeysFuncs = nil
+	}()
+
+	closer := openDB(t)
+	if err = SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	if len(news) != 2*GapLimit || len(Pubs()) != 2*GapLimit {
+		t.Fatal("illegal number of keys derived ahead", len(news), len(Pubs()))
+	}
+	if err = SetSeed(seed); err == nil {
+		t.Error("seed is overwritten")
+	}
+	k0, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, errr := PathOf(k0); errr != nil || p.String() != "m/44'/22'/0'/0/0" {
+		t.Error("illegal path", p, errr)
+	}
+	k1, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewChange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, errr := PathOf(c); errr != nil || p.Change != Internal || p.Index != 0 {
+		t.Error("illegal path of change", p, errr)
+	}
+	if !IsIssued(k1) {
+		t.Error("k1 is not issued")
+	}
+	if len(Pubs()) != 2*GapLimit+3 {
+		t.Error("illegal number of keys", len(Pubs()))
+	}
+	last := news[GapLimit-1]
+	if IsIssued(last) {
+		t.Error("a key derived ahead is issued")
+	}
+
+	news = nil
+	if err = MarkUsed(last); err != nil {
+		t.Fatal(err)
+	}
+	if len(news) != GapLimit-2 {
+		t.Error("illegal number of keys derived ahead", len(news))
+	}
+	bf := BloomFilter()
+	for _, p := range news {
+		if !bf.Match(p.Serialize()) {
+			t.Error("bloom filter doesn't have new keys")
+		}
+	}
+	if !IsIssued(last) {
+		t.Error("used key is not issued")
+	}
+	keys := Pubs()
+	closer()
+
+	closer = openDB(t)
+	defer closer()
+	if err = SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	r0, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r0.Serialize(), k0.Serialize()) {
+		t.Error("restored key is different")
+	}
+	for _, p := range []*PublicKey{last, c} {
+		if err = MarkUsed(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(Pubs()) != len(keys) {
+		t.Error("restored keys are different", len(Pubs()), len(keys))
+	}
+	if _, err = Find(last); err != nil {
+		t.Error("restored wallet doesn't have the used key", err)
+	}
+}
@@ -74,8 +74,8 @@ func FromWIF(wif string) (*PrivateKey, error) {
 		return nil, err
 	}
 
-	if pb[0] != params.DumpedPrivateKeyHeader && pb[0] != params.DumpedPrivateKeyHeaderAlt {
-		return nil, errors.New("private key is not for " + params.ID)
+	if pb[0] != params.Net.DumpedPrivateKeyHeader && pb[0] != params.Net.DumpedPrivateKeyHeaderAlt {
+		return nil, errors.New("private key is not for " + params.Net.ID)
 	}
 	isCompressed := false
 	if len(pb) == btcec.PrivKeyBytesLen+2 && pb[btcec.PrivKeyBytesLen+1] == 0x01 {
@@ -148,7 +148,7 @@ func (priv *PrivateKey) WIFAddress() string {
 	if priv.PublicKey.isCompressed {
 		p = append(p, 0x1)
 	}
-	return base58check.Encode(params.DumpedPrivateKeyHeader, p)
+	return base58check.Encode(params.Net.DumpedPrivateKeyHeader, p)
 }
 
 //Serialize serializes public key depending on isCompressed.
@@ -171,7 +171,7 @@ func (pub *PublicKey) Address() (string, []byte) {
 	}
 	ripeHashedBytes := ripeHash.Sum(nil)
 
-	publicKeyEncoded := base58check.Encode(params.AddressHeader,
+	publicKeyEncoded := base58check.Encode(params.Net.AddressHeader,
 		ripeHashedBytes)
 	return publicKeyEncoded, ripeHashedBytes
 }
@@ -181,13 +181,80 @@ func (priv *PrivateKey) Address() (string, []byte) {
 	return priv.PublicKey.Address()
 }
 
-//DecodeAddress converts bitcoin address to hex form.
+//Types of addresses of a public key, same as bitcoind.
+const (
+	AddressLegacy     = "legacy"
+	AddressP2SHSegwit = "p2sh-segwit"
+	AddressBech32     = "bech32"
+)
+
+//AddressOf returns the address of the public key whose type is typ.
+func (pub *PublicKey) AddressOf(typ string) (string, error) {
+	switch typ {
+	case AddressLegacy, "":
+		adr, _ := pub.Address()
+		return adr, nil
+	case AddressP2SHSegwit:
+		return pub.P2SHWitnessAddress()
+	case AddressBech32:
+		return pub.WitnessAddress()
+	}
+	return "", errors.New("unknown address type " + typ)
+}
+
+//WitnessProgram returns the P2WPKH script of pubhash,
+//which is also the redeem script of P2SH-P2WPKH.
+func WitnessProgram(pubhash []byte) []byte {
+	return append([]byte{0x00, byte(len(pubhash))}, pubhash...)
+}
+
+//WitnessAddress returns the bech32 P2WPKH address of pubhash.
+func WitnessAddress(pubhash []byte) string {
+	a := &Address{
+		Type: AddrP2WPKH,
+		Hash: pubhash,
+	}
+	return a.String()
+}
+
+//P2SHWitnessAddress returns the P2SH-P2WPKH address of pubhash and its script hash.
+func P2SHWitnessAddress(pubhash []byte) (string, []byte) {
+	a := &Address{
+		Type: AddrP2SH,
+		Hash: Hash160(WitnessProgram(pubhash)),
+	}
+	return a.String(), a.Hash
+}
+
+//WitnessAddress returns the bech32 P2WPKH address of the public key.
+//Segwit requires a compressed public key.
+func (pub *PublicKey) WitnessAddress() (string, error) {
+	if !pub.isCompressed {
+		return "", errors.New("segwit requires a compressed public key")
+	}
+	_, hash := pub.Address()
+	return WitnessAddress(hash), nil
+}
+
+//P2SHWitnessAddress returns the P2SH-P2WPKH address of the public key.
+//Segwit requires a compressed public key.
+func (pub *PublicKey) P2SHWitnessAddress() (string, error) {
+	if !pub.isCompressed {
+		return "", errors.New("segwit requires a compressed public key")
+	}
+	_, hash := pub.Address()
+	adr, _ := P2SHWitnessAddress(hash)
+	return adr, nil
+}
+
+//DecodeAddress returns the hash or the witness program of the address.
+//It returns an error if the address is not for the current network.
 func DecodeAddress(addr string) ([]byte, error) {
-	pb, err := base58check.Decode(addr)
+	a, err := ParseAddress(addr)
 	if err != nil {
 		return nil, err
 	}
-	return pb[1:], nil
+	return a.Hash, nil
 }
 
 //Verify verifies signature is valid or not.
@@ -34,6 +34,7 @@ import (
 	"testing"
 
 	"github.com/monarj/wallet/btcec"
+	"github.com/monarj/wallet/params"
 )
 
 func TestKeys2(t *testing.T) {
@@ -120,3 +121,36 @@ func TestKeys3(t *testing.T) {
 	}
 	log.Println(err)
 }
+
+func TestKeysTestNet(t *testing.T) {
+	if err := params.Select(params.TestNet); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := params.Select(params.MainNet); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	key, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	adr, _ := key.Address()
+	if adr[0] != 'm' && adr[0] != 'n' {
+		t.Error("illegal testnet address", adr)
+	}
+	wif := key.WIFAddress()
+	key2, err := FromWIF(wif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adr2, _ := key2.Address(); adr != adr2 {
+		t.Error("key unmatched")
+	}
+	if err = params.Select(params.MainNet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FromWIF(wif); err == nil {
+		t.Error("testnet key is accepted in mainnet")
+	}
+}
@@ -30,43 +30,106 @@ package key
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"log"
 
 	"github.com/boltdb/bolt"
 	"github.com/monarj/wallet/bloom"
 	"github.com/monarj/wallet/db"
+	"golang.org/x/crypto/ripemd160"
 )
 
+var wdb *db.DB
+
+//Init sets the database used in this package.
+func Init(d *db.DB) {
+	wdb = d
+}
+
 //AddScriptHash adds scripthash.
 func AddScriptHash(hash []byte) error {
-	return db.Batch("scripthash", hash, hash)
+	return wdb.BatchPut("scripthash", hash, hash)
 }
 
-//RemoveScriptHash adds scripthash.
+//RemoveScriptHash removes scripthash and its redeem script.
 func RemoveScriptHash(hash []byte) error {
-	return db.DB.Batch(func(tx *bolt.Tx) error {
-		return db.Del(tx, "scripthash", hash)
+	return wdb.Batch(func(tx *bolt.Tx) error {
+		if err := db.Del(tx, "scripthash", hash); err != nil {
+			return err
+		}
+		if !db.HasKey(tx, "redeemscript", hash) {
+			return nil
+		}
+		return db.Del(tx, "redeemscript", hash)
+	})
+}
+
+//Hash160 returns ripemd160(sha256(b)).
+func Hash160(b []byte) []byte {
+	h := sha256.Sum256(b)
+	ripeHash := ripemd160.New()
+	if _, err := ripeHash.Write(h[:]); err != nil {
+		log.Fatal(err)
+	}
+	return ripeHash.Sum(nil)
+}
+
+//AddRedeemScript registers the redeem script of P2SH and adds its hash
+//to the bloom filter, so that coins paid to the script are tracked.
+//It returns the script hash.
+func AddRedeemScript(script []byte) ([]byte, error) {
+	hash := Hash160(script)
+	err := wdb.Update(func(tx *bolt.Tx) error {
+		if err := db.Put(tx, "scripthash", hash, hash); err != nil {
+			return err
+		}
+		return db.Put(tx, "redeemscript", hash, script)
+	})
+	if err != nil {
+		return nil, err
+	}
+	filterChanged()
+	return hash, nil
+}
+
+//RedeemScript returns the registered redeem script whose hash is hash.
+func RedeemScript(hash []byte) ([]byte, error) {
+	var script []byte
+	err := wdb.View(func(tx *bolt.Tx) error {
+		v, err := db.Get(tx, "redeemscript", hash, nil)
+		if err != nil {
+			return err
+		}
+		script = make([]byte, len(v))
+		copy(script, v)
+		return nil
 	})
+	return script, err
 }
 
 //BloomFilter returns bloomfilter which filtered keys and scripthash.
 func BloomFilter() bloom.Bloom {
 	bf := bloom.New()
-	klist := Get()
-	for _, k := range klist {
+	for _, k := range Pubs() {
 		_, adr := k.Address()
-		bf.Insert(k.PublicKey.Serialize())
+		bf.Insert(k.Serialize())
 		bf.Insert(adr)
-	}
-	err := db.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("scripthash"))
-		if b == nil {
-			return nil
+		if k.isCompressed {
+			_, hash := P2SHWitnessAddress(adr)
+			bf.Insert(hash)
 		}
-		c := b.Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			bf.Insert(k)
+	}
+	err := wdb.View(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{"scripthash", "watchaddr"} {
+			b := tx.Bucket([]byte(bucket))
+			if b == nil {
+				continue
+			}
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				bf.Insert(k)
+			}
 		}
 		return nil
 	})
@@ -76,77 +139,158 @@ func BloomFilter() bloom.Bloom {
 	return bf
 }
 
-//New creates , registers , and returns a randome key.
-func New() *PrivateKey {
-	k, err := Generate()
-	if err != nil {
-		log.Fatal(err)
-	}
-	Add(k)
-	return k
-}
-
 //Find returns privatekey from pub.
-//It returns nil if not found.
-func Find(pub *PublicKey) *PrivateKey {
+//It returns ErrLocked if the wallet is locked, or ErrWatchOnly if
+//the wallet doesn't have the private key of pub.
+func Find(pub *PublicKey) (*PrivateKey, error) {
 	var priv *PrivateKey
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
+		if db.HasKey(tx, "watch", pub.Serialize()) {
+			return ErrWatchOnly
+		}
 		dat, err := db.Get(tx, "key", pub.Serialize(), nil)
-		if err != nil {
-			return err
+		if err == nil {
+			if dat, err = decrypt(tx, dat); err != nil {
+				return err
+			}
+			priv = NewPrivateKey(dat)
+			priv.PublicKey.isCompressed = pub.isCompressed
+			return nil
+		}
+		var p Path
+		if _, err = db.Get(tx, "hdpath", pub.Serialize(), &p); err != nil {
+			return errors.New("private key not found")
 		}
-		priv = NewPrivateKey(dat)
+		priv, err = derive(tx, &p)
+		return err
+	})
+	return priv, err
+}
+
+//Has returns true if pub is in the wallet.
+func Has(pub *PublicKey) bool {
+	has := false
+	err := wdb.View(func(tx *bolt.Tx) error {
+		has = db.HasKey(tx, "key", pub.Serialize()) ||
+			db.HasKey(tx, "hdpath", pub.Serialize()) ||
+			db.HasKey(tx, "watch", pub.Serialize())
 		return nil
 	})
 	if err != nil {
-		return nil
+		log.Fatal(err)
+	}
+	return has
+}
+
+//forEachPub calls f with all public keys in the wallet until f returns false.
+func forEachPub(tx *bolt.Tx, f func(pub []byte) bool) {
+	for _, bucket := range []string{"key", "watch"} {
+		if b := tx.Bucket([]byte(bucket)); b != nil {
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if !f(k) {
+					return
+				}
+			}
+		}
+	}
+	if b := tx.Bucket([]byte("hdpath")); b != nil {
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if db.HasKey(tx, "key", k) {
+				continue
+			}
+			if !f(k) {
+				return
+			}
+		}
 	}
-	return priv
 }
 
 //FromPubHash returns pubkey if list has pubhash pubkey.
 func FromPubHash(pubhash []byte) (*PublicKey, error) {
 	var pub *PublicKey
-	errr := db.DB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte("key")).Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			pubk, err := NewPublicKey(k)
-			if err != nil {
-				return err
+	var err error
+	errr := wdb.View(func(tx *bolt.Tx) error {
+		forEachPub(tx, func(k []byte) bool {
+			var pubk *PublicKey
+			if pubk, err = NewPublicKey(k); err != nil {
+				return false
 			}
 			_, hash := pubk.Address()
 			if bytes.Equal(pubhash, hash) {
 				pub = pubk
-				return nil
+				return false
 			}
-		}
-		return errors.New("keyhash not found")
+			return true
+		})
+		return err
 	})
+	if errr == nil && pub == nil {
+		errr = errors.New("keyhash not found")
+	}
 	return pub, errr
 }
 
-//Add adds key to key list.
-func Add(k *PrivateKey) {
-	err := db.Batch("key", k.PublicKey.Serialize(), k.Serialize())
-	if err != nil {
-		log.Fatal(err)
+//FromP2SHWitness returns the compressed pubkey whose P2SH-P2WPKH script hash is hash.
+func FromP2SHWitness(hash []byte) (*PublicKey, error) {
+	var pub *PublicKey
+	var err error
+	errr := wdb.View(func(tx *bolt.Tx) error {
+		forEachPub(tx, func(k []byte) bool {
+			var pubk *PublicKey
+			if pubk, err = NewPublicKey(k); err != nil {
+				return false
+			}
+			if !pubk.isCompressed {
+				return true
+			}
+			_, pubhash := pubk.Address()
+			if _, h := P2SHWitnessAddress(pubhash); bytes.Equal(hash, h) {
+				pub = pubk
+				return false
+			}
+			return true
+		})
+		return err
+	})
+	if errr == nil && pub == nil {
+		errr = errors.New("script hash not found")
 	}
+	return pub, errr
 }
 
-//Get gets key list.
-func Get() []*PrivateKey {
-	var l []*PrivateKey
-	err := db.DB.View(func(tx *bolt.Tx) error {
-		bu := tx.Bucket([]byte("key"))
-		if bu == nil {
-			return nil
+//Add adds key to key list.
+//The wallet must be unlocked if it is encrypted.
+func Add(k *PrivateKey) error {
+	return wdb.Update(func(tx *bolt.Tx) error {
+		dat, err := encrypt(tx, k.Serialize())
+		if err != nil {
+			return err
 		}
-		c := bu.Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			priv := NewPrivateKey(k)
-			l = append(l, priv)
+		if db.HasKey(tx, "watch", k.PublicKey.Serialize()) {
+			if err = db.Del(tx, "watch", k.PublicKey.Serialize()); err != nil {
+				return err
+			}
 		}
-		return nil
+		return db.Put(tx, "key", k.PublicKey.Serialize(), dat)
+	})
+}
+
+//Pubs returns public keys in the wallet.
+func Pubs() []*PublicKey {
+	var l []*PublicKey
+	err := wdb.View(func(tx *bolt.Tx) error {
+		var err error
+		forEachPub(tx, func(k []byte) bool {
+			var pub *PublicKey
+			if pub, err = NewPublicKey(k); err != nil {
+				return false
+			}
+			l = append(l, pub)
+			return true
+		})
+		return err
 	})
 	if err != nil {
 		log.Fatal(err)
@@ -154,9 +298,27 @@ func Get() []*PrivateKey {
 	return l
 }
 
+//Get gets key list except watch-only keys.
+//It returns ErrLocked if the wallet is locked.
+func Get() ([]*PrivateKey, error) {
+	pubs := Pubs()
+	l := make([]*PrivateKey, 0, len(pubs))
+	for _, pub := range pubs {
+		priv, err := Find(pub)
+		if err == ErrWatchOnly {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, priv)
+	}
+	return l, nil
+}
+
 //Remove removes the key from key list.
 func Remove(k *PrivateKey) error {
-	return db.DB.Batch(func(tx *bolt.Tx) error {
+	return wdb.Batch(func(tx *bolt.Tx) error {
 		return db.Del(tx, "key", k.PublicKey.Serialize())
 	})
 }
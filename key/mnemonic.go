@@ -25,37 +25,186 @@
  * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
  * POSSIBILITY OF SUCH DAMAGE.
  */
-
 package key
 
 import (
-	"crypto/sha512"
-
 	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
 )
 
+//ErrMnemonicChecksum is returned when the checksum of a mnemonic is wrong.
+var ErrMnemonicChecksum = errors.New("checksum of the mnemonic is invalid")
+
+//Wordlist is a list of 2048 words which encodes entropy to a mnemonic.
+type Wordlist struct {
+	//Name is the name of the language.
+	Name      string
+	words     []string
+	index     map[string]int
+	separator string
+}
+
+var (
+	//English is the English wordlist.
+	English = newWordlist("english", words, " ")
+	//Japanese is the Japanese wordlist. Words are separated by
+	//ideographic spaces.
+	Japanese = newWordlist("japanese", wordsJa, "　")
+
+	//Wordlists is the list of supported wordlists.
+	Wordlists = []*Wordlist{English, Japanese}
+)
+
+func newWordlist(name string, ws []string, sep string) *Wordlist {
+	w := &Wordlist{
+		Name:      name,
+		words:     ws,
+		index:     make(map[string]int, len(ws)),
+		separator: sep,
+	}
+	for i, wd := range ws {
+		w.index[norm.NFKD.String(wd)] = i
+	}
+	return w
+}
+
+//MnemonicToSeed returns the seed of the mnemonic with password.
 func MnemonicToSeed(mnemonic, password string) []byte {
-	salt := []byte("mnemonic")
-	salt = append(salt, []byte(password)...)
-	return pbkdf2.Key([]byte(mnemonic), salt, 2048, 64, sha512.New)
+	salt := []byte("mnemonic" + norm.NFKD.String(password))
+	return pbkdf2.Key([]byte(norm.NFKD.String(mnemonic)), salt, 2048, 64, sha512.New)
+}
+
+//checkEntropy returns an error if entropy with bits is not allowed.
+func checkEntropy(bits int) error {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return fmt.Errorf("bits of entropy must be a multiple of 32 from 128 to 256, not %d", bits)
+	}
+	return nil
+}
+
+//NewMnemonic returns a random English mnemonic with bits of entropy.
+func NewMnemonic(bits int) (string, error) {
+	return English.NewMnemonic(bits)
 }
 
-func lpad(str, padstr string, length int) string {
-	for len(str) < length {
-		str = padstr + str
+//NewMnemonic returns a random mnemonic with bits of entropy.
+func (w *Wordlist) NewMnemonic(bits int) (string, error) {
+	if err := checkEntropy(bits); err != nil {
+		return "", err
 	}
-	return str
+	entropy, err := GenerateSeed(uint8(bits / 8))
+	if err != nil {
+		return "", err
+	}
+	return w.EntropyToMnemonic(entropy)
 }
 
-func bytesToBinary(bytes []byte) string{
-    
+//EntropyToMnemonic encodes entropy to an English mnemonic.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	return English.EntropyToMnemonic(entropy)
 }
 
-func checksumBits(entropy []byte) []byte {
+//EntropyToMnemonic encodes entropy to a mnemonic.
+//Each word represents 11 bits of entropy followed by the checksum,
+//which is the first len(entropy)/4 bits of sha256 of entropy.
+func (w *Wordlist) EntropyToMnemonic(entropy []byte) (string, error) {
+	if err := checkEntropy(len(entropy) * 8); err != nil {
+		return "", err
+	}
 	hash := sha256.Sum256(entropy)
-	ent := len(entropy) * 8
-	cs := ent / 32
+	data := make([]byte, len(entropy)+1)
+	copy(data, entropy)
+	data[len(entropy)] = hash[0]
+	ws := make([]string, (len(entropy)*8+len(entropy)/4)/11)
+	for i := range ws {
+		idx := 0
+		for j := 0; j < 11; j++ {
+			bit := i*11 + j
+			idx = idx<<1 | int(data[bit/8]>>uint(7-bit%8)&1)
+		}
+		ws[i] = w.words[idx]
+	}
+	return strings.Join(ws, w.separator), nil
+}
+
+//MnemonicToEntropy decodes the mnemonic in any supported language
+//to entropy, and verifies its checksum.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	w, err := detect(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	return w.MnemonicToEntropy(mnemonic)
+}
+
+//detect returns the wordlist which has the first word of the mnemonic.
+func detect(mnemonic string) (*Wordlist, error) {
+	ws := strings.Fields(norm.NFKD.String(mnemonic))
+	if len(ws) == 0 {
+		return nil, errors.New("mnemonic is empty")
+	}
+	for _, w := range Wordlists {
+		if _, exist := w.index[ws[0]]; exist {
+			return w, nil
+		}
+	}
+	return nil, errors.New("unknown language of the mnemonic")
+}
+
+//MnemonicToEntropy decodes the mnemonic to entropy and verifies its checksum.
+func (w *Wordlist) MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	ws := strings.Fields(norm.NFKD.String(mnemonic))
+	if len(ws) < 12 || len(ws) > 24 || len(ws)%3 != 0 {
+		return nil, fmt.Errorf("number of words must be 12, 15, 18, 21 or 24, not %d", len(ws))
+	}
+	bits := len(ws) * 11
+	cs := bits / 33
+	ent := bits - cs
+	data := make([]byte, (bits+7)/8)
+	for i, wd := range ws {
+		idx, exist := w.index[wd]
+		if !exist {
+			return nil, fmt.Errorf("%s is not in the %s wordlist", wd, w.Name)
+		}
+		for j := 0; j < 11; j++ {
+			if idx>>uint(10-j)&1 == 1 {
+				bit := i*11 + j
+				data[bit/8] |= 1 << uint(7-bit%8)
+			}
+		}
+	}
+	entropy := data[:ent/8]
+	hash := sha256.Sum256(entropy)
+	if data[ent/8]>>uint(8-cs) != hash[0]>>uint(8-cs) {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
 
+//CreateWallet makes a random mnemonic with bits of entropy in w,
+//and sets its seed with password to the wallet.
+func CreateWallet(bits int, w *Wordlist, password string) (string, error) {
+	m, err := w.NewMnemonic(bits)
+	if err != nil {
+		return "", err
+	}
+	if err = SetSeed(MnemonicToSeed(m, password)); err != nil {
+		return "", err
+	}
+	return m, nil
+}
+
+//RestoreWallet validates the mnemonic and sets its seed with password
+//to the wallet.
+func RestoreWallet(mnemonic, password string) error {
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		return err
+	}
+	return SetSeed(MnemonicToSeed(mnemonic, password))
 }
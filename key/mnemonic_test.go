@@ -31,7 +31,10 @@ package key
 import (
 	"bytes"
 	"encoding/hex"
+	"strings"
 	"testing"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 func TestMne(t *testing.T) {
@@ -46,3 +49,93 @@ func TestMne(t *testing.T) {
 		t.Error("seed unmatch", hex.EncodeToString(seed))
 	}
 }
+
+func TestMnemonicVectors(t *testing.T) {
+	vectors := []struct {
+		entropy  string
+		mnemonic string
+		seed     string
+	}{
+		{
+			"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+		{
+			"ffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+			"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+		},
+		{
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+			"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+		},
+	}
+	for _, v := range vectors {
+		entropy, err := hex.DecodeString(v.entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := EntropyToMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != v.mnemonic {
+			t.Error("mnemonic unmatch", m)
+		}
+		e, err := MnemonicToEntropy(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(e, entropy) {
+			t.Error("entropy unmatch", hex.EncodeToString(e))
+		}
+		if seed := MnemonicToSeed(m, "TREZOR"); hex.EncodeToString(seed) != v.seed {
+			t.Error("seed unmatch", hex.EncodeToString(seed))
+		}
+	}
+
+	if _, err := MnemonicToEntropy("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"); err != ErrMnemonicChecksum {
+		t.Error("invalid checksum is accepted", err)
+	}
+	if _, err := MnemonicToEntropy("abandon abandon abandon"); err == nil {
+		t.Error("short mnemonic is accepted")
+	}
+	if _, err := NewMnemonic(100); err == nil {
+		t.Error("illegal bits are accepted")
+	}
+}
+
+func TestMnemonicJapanese(t *testing.T) {
+	m, err := Japanese.EntropyToMnemonic(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Repeat("あいこくしん　", 11) + "あおぞら"
+	if norm.NFKD.String(m) != norm.NFKD.String(expected) || !strings.Contains(m, "　") {
+		t.Error("mnemonic unmatch", m)
+	}
+	for _, bits := range []int{128, 160, 192, 224, 256} {
+		m, err = Japanese.NewMnemonic(bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := MnemonicToEntropy(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(e)*8 != bits {
+			t.Error("illegal length of entropy", len(e))
+		}
+		//half-width spaces are also accepted.
+		if _, err = Japanese.MnemonicToEntropy(strings.Replace(m, "　", " ", -1)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -28,6 +28,7 @@
 
 package key
 
+//words is the English wordlist of BIP39.
 var words = []string{
 	"abandon",
 	"ability",
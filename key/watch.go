@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+package key
+
+import (
+	"errors"
+	"log"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/params"
+)
+
+//ErrWatchOnly is returned when the private key of a watch-only key is needed.
+var ErrWatchOnly = errors.New("the key is watch-only")
+
+//ImportXPub imports an extended public key of an account (m/44'/coin'/account')
+//as watch-only, and derives keys of its external and internal chains ahead.
+//It returns an error if keys of xpub are already in the wallet,
+//e.g. xpub is of an account of the wallet itself.
+func ImportXPub(xpub string) error {
+	k, err := NewKeyFromString(xpub)
+	if err != nil {
+		return err
+	}
+	if k.IsPrivate() {
+		return errors.New("not an extended public key")
+	}
+	ext, err := k.Child(External)
+	if err != nil {
+		return err
+	}
+	first, err := childPub(ext, 0)
+	if err != nil {
+		return err
+	}
+	var pubs []*PublicKey
+	err = wdb.Update(func(tx *bolt.Tx) error {
+		if db.HasKey(tx, "watchxpub", []byte(xpub)) {
+			return errors.New("the extended public key is already imported")
+		}
+		if db.HasKey(tx, "hdpath", first.Serialize()) || db.HasKey(tx, "key", first.Serialize()) {
+			return errors.New("keys of the extended public key are already in the wallet")
+		}
+		n := 0
+		if tx.Bucket([]byte("watchxpub")) != nil {
+			var err error
+			if n, err = db.Count(tx, "watchxpub", nil); err != nil {
+				return err
+			}
+		}
+		if err := db.Put(tx, "watchxpub", []byte(xpub), uint64(n)); err != nil {
+			return err
+		}
+		for _, change := range []uint32{External, Internal} {
+			p, err := lookahead(tx, &Path{Account: uint32(n), Change: change, Watch: true})
+			if err != nil {
+				return err
+			}
+			pubs = append(pubs, p...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	newKeys(pubs)
+	return nil
+}
+
+//watchChainKey returns the extended key of the chain of p
+//from the imported extended public key.
+func watchChainKey(tx *bolt.Tx, p *Path) (*ExtendedKey, error) {
+	b := tx.Bucket([]byte("watchxpub"))
+	if b == nil {
+		return nil, errors.New("no extended public keys are imported")
+	}
+	var xpub string
+	err := b.ForEach(func(k, v []byte) error {
+		var n uint64
+		if err := db.B2v(v, &n); err != nil {
+			return err
+		}
+		if n == uint64(p.Account) {
+			xpub = string(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if xpub == "" {
+		return nil, errors.New("extended public key not found")
+	}
+	k, err := NewKeyFromString(xpub)
+	if err != nil {
+		return nil, err
+	}
+	return k.Child(p.Change)
+}
+
+//ImportPubKey imports pub as watch-only.
+func ImportPubKey(pub *PublicKey) error {
+	adr, _ := pub.Address()
+	err := wdb.Update(func(tx *bolt.Tx) error {
+		if db.HasKey(tx, "key", pub.Serialize()) || db.HasKey(tx, "hdpath", pub.Serialize()) {
+			return errors.New("the key is already in the wallet")
+		}
+		return db.Put(tx, "watch", pub.Serialize(), adr)
+	})
+	if err != nil {
+		return err
+	}
+	newKeys([]*PublicKey{pub})
+	return nil
+}
+
+//ImportAddress imports a P2PKH or P2WPKH address as watch-only.
+//Coins to it are tracked without the public key.
+func ImportAddress(adr string) error {
+	a, err := ParseAddress(adr)
+	if err != nil {
+		return err
+	}
+	if a.Type != AddrP2PKH && a.Type != AddrP2WPKH {
+		return errors.New("not a P2PKH or P2WPKH address for " + params.Net.ID)
+	}
+	if err = wdb.BatchPut("watchaddr", a.Hash, adr); err != nil {
+		return err
+	}
+	filterChanged()
+	return nil
+}
+
+//IsWatchedHash returns true if the pubkey hash is imported as a watch-only address.
+func IsWatchedHash(hash []byte) bool {
+	has := false
+	err := wdb.View(func(tx *bolt.Tx) error {
+		has = db.HasKey(tx, "watchaddr", hash)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return has
+}
+
+//IsWatchOnly returns true if pub is in the wallet without its private key.
+func IsWatchOnly(pub *PublicKey) bool {
+	watch := false
+	err := wdb.View(func(tx *bolt.Tx) error {
+		watch = isWatchOnly(tx, pub.Serialize())
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return watch
+}
+
+func isWatchOnly(tx *bolt.Tx, pub []byte) bool {
+	if db.HasKey(tx, "watch", pub) {
+		return true
+	}
+	var p Path
+	if _, err := db.Get(tx, "hdpath", pub, &p); err != nil {
+		return false
+	}
+	return p.Watch
+}
+
+//WatchedAddresses returns addresses imported as watch-only without public keys.
+func WatchedAddresses() []string {
+	var adrs []string
+	err := wdb.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("watchaddr")) == nil {
+			return nil
+		}
+		var err error
+		adrs, err = db.GetStrings(tx, "watchaddr", nil)
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return adrs
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package key
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/monarj/wallet/params"
+)
+
+func TestWatch(t *testing.T) {
+	seed, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewMaster(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint32{purpose, params.Net.HDCoinType, 0} {
+		if k, err = k.Child(HardenedKeyStart + i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if k, err = k.Neuter(); err != nil {
+		t.Fatal(err)
+	}
+	ext, err := k.Child(External)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := childPub(ext, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closer := openDB(t)
+	defer closer()
+	if err = ImportXPub(k.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err = ImportXPub(k.String()); err == nil {
+		t.Error("imported the same xpub twice")
+	}
+	if len(Pubs()) != 2*GapLimit {
+		t.Fatal("illegal number of keys derived ahead", len(Pubs()))
+	}
+	if !Has(first) || !IsWatchOnly(first) {
+		t.Fatal("first external key is not watched")
+	}
+	if _, err = Find(first); err != ErrWatchOnly {
+		t.Error("private key of watch-only key is found", err)
+	}
+	privs, err := Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(privs) != 0 {
+		t.Error("watch-only keys returned as private keys")
+	}
+	if pub, errr := New(); errr != nil || bytes.Equal(pub.Serialize(), first.Serialize()) {
+		t.Error("watch-only key issued as a wallet address")
+	}
+
+	adr := "MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G"
+	hash, err := DecodeAddress(adr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if BloomFilter().Match(hash) {
+		t.Fatal("address matched before import")
+	}
+	if err = ImportAddress(adr); err != nil {
+		t.Fatal(err)
+	}
+	if !BloomFilter().Match(hash) || !IsWatchedHash(hash) {
+		t.Error("watched address is not tracked")
+	}
+	if adrs := WatchedAddresses(); len(adrs) != 1 || adrs[0] != adr {
+		t.Error("illegal watched addresses", adrs)
+	}
+	if err = ImportAddress("PHqfDJTWqhhxyjMHWSfn9Vn4xYSKcTR1g5"); err == nil {
+		t.Error("imported a non-P2PKH address")
+	}
+}
+
+func TestImportOwnXPub(t *testing.T) {
+	seed, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewMaster(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint32{purpose, params.Net.HDCoinType, 0} {
+		if k, err = k.Child(HardenedKeyStart + i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if k, err = k.Neuter(); err != nil {
+		t.Fatal(err)
+	}
+
+	closer := openDB(t)
+	defer closer()
+	if err = SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	own, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ImportXPub(k.String()); err == nil {
+		t.Error("imported the xpub of the wallet itself")
+	}
+	if IsWatchOnly(own) {
+		t.Error("own key is overwritten as watch-only")
+	}
+	p, err := PathOf(own)
+	if err != nil || p.Watch || p.String() != "m/44'/22'/0'/0/0" {
+		t.Error("path of own key is overwritten", p, err)
+	}
+}
@@ -29,45 +29,240 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
-	"runtime/pprof"
+	"path/filepath"
+	"sort"
 	"time"
 
-	"runtime/debug"
-
 	"github.com/monarj/wallet/block"
 	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/params"
 	"github.com/monarj/wallet/peer"
+	"github.com/monarj/wallet/tx"
 )
 
-func main() {
-	cpuprofile := "mycpu.prof"
-	f, err := os.Create(cpuprofile)
-	if err != nil {
-		log.Fatal(err)
+//command is a subcommand of the wallet.
+type command struct {
+	usage string
+	help  string
+	run   func(args []string) error
+}
+
+var commands = map[string]*command{
+	"sync": {
+		usage: "sync [-cpuprofile file] [-memprofile file] [-rpcaddr addr -rpcuser user -rpcpassword pass]",
+		help:  "connect to peers and download headers and transactions, optionally serving JSON-RPC",
+		run:   runSync,
+	},
+	"balance": {
+		usage: "balance",
+		help:  "show the balance of the wallet",
+		run:   runBalance,
+	},
+	"newaddress": {
+		usage: "newaddress [legacy|p2sh-segwit|bech32]",
+		help:  "derive the next receiving key and show its address of the type",
+		run:   runNewAddress,
+	},
+	"listaddresses": {
+		usage: "listaddresses",
+		help:  "show all addresses in the wallet",
+		run:   runListAddresses,
+	},
+	"send": {
+		usage: "send [-nobroadcast] [-wait duration] [-selection strategy] [-rbf] <address> <amount> [<address> <amount>...]",
+		help:  "create, sign and broadcast a transaction which sends amount MONA to address",
+		run:   runSend,
+	},
+	"bumpfee": {
+		usage: "bumpfee [-nobroadcast] [-wait duration] [-feerate rate] <txid>",
+		help:  "replace a pending transaction sent with -rbf by one paying more fee",
+		run:   runBumpFee,
+	},
+	"cpfp": {
+		usage: "cpfp [-nobroadcast] [-wait duration] [-feerate rate] [-rbf] <txid|rawtx> <index> [<address> <amount>...]",
+		help:  "spend the output at index of an unconfirmed transaction to accelerate it by child-pays-for-parent",
+		run:   runCPFP,
+	},
+	"abandon": {
+		usage: "abandon <txid>",
+		help:  "release coins spent by a pending transaction which will not be confirmed",
+		run:   runAbandon,
+	},
+	"createunsigned": {
+		usage: "createunsigned <address> <amount> [<address> <amount>...]",
+		help:  "create an unsigned transaction from watch-only coins to be signed offline, locking the coins until abandoned",
+		run:   runCreateUnsigned,
+	},
+	"watch": {
+		usage: "watch <xpub|pubkey|address>...",
+		help:  "import extended public keys, public keys or addresses as watch-only",
+		run:   runWatch,
+	},
+	"addmultisig": {
+		usage: "addmultisig <m> <pubkey|address>...",
+		help:  "track coins to the P2SH address of m of n multisig and show the address",
+		run:   runAddMultisig,
+	},
+	"importwif": {
+		usage: "importwif <wif>",
+		help:  "import a private key in WIF format",
+		run:   runImportWIF,
+	},
+	"dumpwif": {
+		usage: "dumpwif <address>",
+		help:  "show the private key of address in WIF format",
+		run:   runDumpWIF,
+	},
+	"create": {
+		usage: "create [-words n] [-lang english|japanese] [-passphrase]",
+		help:  "create an HD wallet and show its BIP39 mnemonic",
+		run:   runCreate,
+	},
+	"restore": {
+		usage: "restore [-passphrase] <word>...",
+		help:  "restore the HD wallet from a BIP39 mnemonic and rescan the chain",
+		run:   runRestore,
+	},
+	"encrypt": {
+		usage: "encrypt",
+		help:  "encrypt private keys and the seed with a passphrase read from stdin",
+		run:   runEncrypt,
+	},
+	"changepassphrase": {
+		usage: "changepassphrase",
+		help:  "change the passphrase of the encrypted wallet, reading both from stdin",
+		run:   runChangePassphrase,
+	},
+	"importseed": {
+		usage: "importseed <seed>",
+		help:  "restore the HD wallet from a seed in hex and rescan the chain",
+		run:   runImportSeed,
+	},
+	"dumpseed": {
+		usage: "dumpseed",
+		help:  "show the seed of the HD wallet in hex",
+		run:   runDumpSeed,
+	},
+	"listcoins": {
+		usage: "listcoins",
+		help:  "show unspent coins in the wallet",
+		run:   runListCoins,
+	},
+	"history": {
+		usage: "history [-address addr] [-from height] [-to height] [-offset n] [-limit n]",
+		help:  "export the transaction history in CSV",
+		run:   runHistory,
+	},
+	"peers": {
+		usage: "peers",
+		help:  "resolve peers from dns seeds and show them",
+		run:   runPeers,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options] <command> [arguments]\n\ncommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for k := range commands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(os.Stderr, "  %-50s %s\n", commands[n].usage, commands[n].help)
+	}
+	fmt.Fprintln(os.Stderr, "\noptions:")
+	flag.PrintDefaults()
+}
+
+//openDB opens the database in datadir and passes it to all packages.
+func openDB(datadir string) (*db.DB, error) {
+	if err := os.MkdirAll(datadir, 0700); err != nil {
+		return nil, err
 	}
-	pprof.StartCPUProfile(f)
-	defer pprof.StopCPUProfile()
-	f2, err := os.Create("mem.prof")
+	d, err := db.Open(filepath.Join(datadir, db.FileName), &db.Options{
+		Timeout: 10 * time.Second,
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	key.Init(d)
+	if err = block.Init(d); err != nil {
+		if errr := d.Close(); errr != nil {
+			log.Print(errr)
+		}
+		return nil, err
 	}
-	defer pprof.WriteHeapProfile(f2)
+	tx.Init(d)
+	peer.Init(d)
+	return d, nil
+}
 
-	log.SetFlags(log.Ldate | log.Lshortfile | log.Ltime)
-	log.Print("writing lastmerkle")
-	err = db.Batch("status", []byte("lastmerkle"), uint64(0))
+func run(cmd *command, datadir string, unlock bool, args []string) error {
+	d, err := openDB(datadir)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			log.Print(err)
+		}
+	}()
+	if unlock {
+		pass, errr := readPassphrase("passphrase: ")
+		if errr != nil {
+			return errr
+		}
+		if err = key.Unlock(pass, 0); err != nil {
+			return err
+		}
+		defer key.Lock()
 	}
-	peer.Run()
-	time.Sleep(30 * time.Minute)
+	return cmd.run(args)
+}
 
-	h := block.Lastblocks()
-	for _, hh := range h {
-		log.Print(hh.Height)
+func main() {
+	log.SetFlags(log.Ldate | log.Lshortfile | log.Ltime)
+	datadir := flag.String("datadir", ".", "directory to store the database")
+	network := flag.String("net", params.MainNet,
+		fmt.Sprintf("network to use (%s, %s or %s)", params.MainNet, params.TestNet, params.RegTest))
+	unlock := flag.Bool("unlock", false, "read the passphrase from stdin and unlock the encrypted wallet while the command runs")
+	feerate := flag.String("feerate", "", "fee rate of transactions in MONA/kB (default "+fmt.Sprint(float64(params.Fee)/params.Unit)+")")
+	flag.Usage = usage
+	flag.Parse()
+	if err := params.Select(*network); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *feerate != "" {
+		rate, err := parseAmount(*feerate)
+		if err == nil {
+			err = tx.SetFeeRate(rate)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if params.Net.ID != params.MainNet {
+		*datadir = filepath.Join(*datadir, params.Net.ID)
+	}
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, exist := commands[flag.Arg(0)]
+	if !exist {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if err := run(cmd, *datadir, *unlock, flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	debug.PrintStack()
-	log.Print(block.DownloadedBlockNumber(), peer.AliveNum())
 }
@@ -43,10 +43,24 @@ type VarInt uint64
 const (
 	prevTag = "prev"
 	varTag  = "var"
+	skipTag = "-"
 )
 
+//packer is implemented by structs which serialize themselves.
+type packer interface {
+	pack(buf io.Writer) error
+}
+
+//unpacker is implemented by structs which deserialize themselves.
+type unpacker interface {
+	unpack(buf io.Reader) error
+}
+
 //Pack converts struct to []byte.
 func Pack(buf io.Writer, t interface{}) error {
+	if p, ok := t.(packer); ok {
+		return p.pack(buf)
+	}
 	v := reflect.ValueOf(t)
 	ty := v.Type()
 	if ty.Kind() != reflect.Struct {
@@ -54,6 +68,9 @@ func Pack(buf io.Writer, t interface{}) error {
 	}
 	var val uint64
 	for i := 0; i < ty.NumField(); i++ {
+		if ty.Field(i).Tag.Get("len") == skipTag {
+			continue
+		}
 		f := v.Field(i)
 		intf := f.Interface()
 		var result []byte
@@ -127,6 +144,9 @@ func Pack(buf io.Writer, t interface{}) error {
 
 //Unpack converts []byte to struct.
 func Unpack(buf io.Reader, t interface{}) error {
+	if u, ok := t.(unpacker); ok {
+		return u.unpack(buf)
+	}
 	v := reflect.ValueOf(t).Elem()
 	ty := v.Type()
 	if ty.Kind() != reflect.Struct {
@@ -134,6 +154,9 @@ func Unpack(buf io.Reader, t interface{}) error {
 	}
 	var val uint64
 	for i := 0; i < ty.NumField(); i++ {
+		if ty.Field(i).Tag.Get("len") == skipTag {
+			continue
+		}
 		vi := v.Field(i)
 		if !(vi.IsValid() && vi.CanSet()) {
 			return fmt.Errorf("not valid or cannot set at field no %d", i)
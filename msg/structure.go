@@ -37,7 +37,6 @@ import (
 	"log"
 	"math/big"
 	"net"
-	"time"
 
 	"github.com/monarj/wallet/behex"
 	"github.com/monarj/wallet/params"
@@ -50,6 +49,13 @@ const (
 	MsgBlock
 	MsgFilterdBlock
 	MsgCmpctBlock
+
+	//MsgWitnessFlag is set to object types to request objects with witness.
+	MsgWitnessFlag uint32 = 1 << 30
+	//MsgWitnessTX is the type of tx with witness.
+	MsgWitnessTX = MsgTX | MsgWitnessFlag
+	//MsgWitnessBlock is the type of block with witness.
+	MsgWitnessBlock = MsgBlock | MsgWitnessFlag
 )
 
 //Message is the header of message.
@@ -123,13 +129,10 @@ func (b *HBlockHeader) IsOK(height uint64) error {
 		return err
 	}
 	bs := buf.Bytes()
-	pow := params.PoWFunc(height, bs)
+	pow := params.Net.PoWFunc(height, bs)
 
-	if b.Bits > params.ProofOfWorkLimit {
-		return fmt.Errorf("PoW limits is too easy %x > %x", b.Bits, params.ProofOfWorkLimit)
-	}
-	if b.Timestamp > uint32(time.Now().Unix()) {
-		return errors.New("future packet")
+	if b.Bits > params.Net.ProofOfWorkLimit {
+		return fmt.Errorf("PoW limits is too easy %x > %x", b.Bits, params.Net.ProofOfWorkLimit)
 	}
 	if behex.Compare(b.target(), pow) < 0 {
 		return errors.New("hash doesn't match target")
@@ -137,6 +140,16 @@ func (b *HBlockHeader) IsOK(height uint64) error {
 	return nil
 }
 
+//Services of nodes in version messages.
+const (
+	NodeNetwork uint64 = 1 << 0
+	NodeBloom   uint64 = 1 << 2
+	//NodeWitness means the node can serve blocks and txs with witness.
+	//Witness data is requested by inv types with MsgWitnessFlag,
+	//not by services we advertise.
+	NodeWitness uint64 = 1 << 3
+)
+
 //Version is a version info nodes send first.
 type Version struct {
 	Version     uint32
@@ -196,11 +209,13 @@ type Headers struct {
 }
 
 //TxIn is the info of input transaction.
+//Witness is serialized after all outputs by Tx (BIP144).
 type TxIn struct {
-	Hash   []byte `len:"32"`
-	Index  uint32
-	Script []byte `len:"prev"`
-	Seq    uint32
+	Hash    []byte `len:"32"`
+	Index   uint32
+	Script  []byte `len:"prev"`
+	Seq     uint32
+	Witness [][]byte `len:"-"`
 }
 
 //TxOut is the info of output transaction.
@@ -217,9 +232,9 @@ type Tx struct {
 	Locktime uint32
 }
 
-//Hash returns hash of the tx.
+//Hash returns hash of the tx without witness, i.e. txid.
 func (b *Tx) Hash() []byte {
-	return hash(*b)
+	return hash(rawTx(*b))
 }
 
 //Addr provides information on known nodes of the network
@@ -111,7 +111,7 @@ func TestStruct(t *testing.T) {
 		if err = Pack(&buf2, h.HBlockHeader); err != nil {
 			t.Fatal(err)
 		}
-		outs := behex.EncodeToString(params.PoWFunc(0, buf2.Bytes()))
+		outs := behex.EncodeToString(params.Net.PoWFunc(0, buf2.Bytes()))
 		if outs != expected[i] {
 			t.Error("scrypt not match", outs)
 		}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package msg
+
+import (
+	"errors"
+	"io"
+)
+
+//rawTx is Tx serialized without witness.
+type rawTx Tx
+
+//witnessItem is an item of a witness stack.
+type witnessItem struct {
+	Data []byte `len:"prev"`
+}
+
+//txWitness is the witness stack of an input.
+type txWitness struct {
+	Items []witnessItem `len:"prev"`
+}
+
+//witnessTx is Tx serialized with witness (BIP144).
+type witnessTx struct {
+	Version  uint32
+	Marker   byte
+	Flag     byte
+	TxIn     []TxIn  `len:"prev"`
+	TxOut    []TxOut `len:"prev"`
+	Witness  []txWitness
+	Locktime uint32
+}
+
+//HasWitness returns true if any input of the tx has witness.
+func (b *Tx) HasWitness() bool {
+	for _, in := range b.TxIn {
+		if len(in.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//WitnessHash returns hash of the tx with witness, i.e. wtxid.
+//It is same as Hash if the tx has no witness.
+func (b *Tx) WitnessHash() []byte {
+	return hash(*b)
+}
+
+//Stripped returns a copy of the tx without witness,
+//which is sent to peers that don't understand segwit.
+func (b *Tx) Stripped() *Tx {
+	t := *b
+	t.TxIn = make([]TxIn, len(b.TxIn))
+	copy(t.TxIn, b.TxIn)
+	for i := range t.TxIn {
+		t.TxIn[i].Witness = nil
+	}
+	return &t
+}
+
+//pack serializes the tx with witness if it has,
+//or in the legacy format if not.
+func (b Tx) pack(buf io.Writer) error {
+	if !b.HasWitness() {
+		return Pack(buf, rawTx(b))
+	}
+	w := witnessTx{
+		Version:  b.Version,
+		Flag:     0x01,
+		TxIn:     b.TxIn,
+		TxOut:    b.TxOut,
+		Witness:  make([]txWitness, len(b.TxIn)),
+		Locktime: b.Locktime,
+	}
+	for i, in := range b.TxIn {
+		w.Witness[i].Items = make([]witnessItem, len(in.Witness))
+		for j, item := range in.Witness {
+			w.Witness[i].Items[j].Data = item
+		}
+	}
+	return Pack(buf, w)
+}
+
+//unpack deserializes the tx in both of the legacy and witness format.
+func (b *Tx) unpack(buf io.Reader) error {
+	var head struct {
+		Version uint32
+		NTxIn   VarInt
+	}
+	if err := Unpack(buf, &head); err != nil {
+		return err
+	}
+	b.Version = head.Version
+	hasWitness := false
+	if head.NTxIn == 0 {
+		var flag struct {
+			Flag  byte
+			NTxIn VarInt
+		}
+		if err := Unpack(buf, &flag); err != nil {
+			return err
+		}
+		if flag.Flag != 0x01 {
+			return errors.New("unknown flag of tx")
+		}
+		hasWitness = true
+		head.NTxIn = flag.NTxIn
+	}
+	b.TxIn = make([]TxIn, head.NTxIn)
+	for i := range b.TxIn {
+		if err := Unpack(buf, &b.TxIn[i]); err != nil {
+			return err
+		}
+	}
+	var outs struct {
+		TxOut []TxOut `len:"prev"`
+	}
+	if err := Unpack(buf, &outs); err != nil {
+		return err
+	}
+	b.TxOut = outs.TxOut
+	if hasWitness {
+		for i := range b.TxIn {
+			var w txWitness
+			if err := Unpack(buf, &w); err != nil {
+				return err
+			}
+			b.TxIn[i].Witness = make([][]byte, len(w.Items))
+			for j, item := range w.Items {
+				b.TxIn[i].Witness[j] = item.Data
+			}
+		}
+		if !b.HasWitness() {
+			return errors.New("superfluous witness")
+		}
+	}
+	var tail struct {
+		Locktime uint32
+	}
+	if err := Unpack(buf, &tail); err != nil {
+		return err
+	}
+	b.Locktime = tail.Locktime
+	return nil
+}
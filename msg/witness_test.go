@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package msg
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/monarj/wallet/behex"
+)
+
+func TestWitnessTx(t *testing.T) {
+	//from BIP143, native P2WPKH
+	inp := "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+	txid := "e8151a2af31c368a35053ddd4bdb285a8595c769a3ad83e0fa02314a602d4609"
+	wtxid := "c36c38370907df2324d9ce9d149d191192f338b37665a82e78e76a12c909b762"
+
+	b, err := hex.DecodeString(inp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tx Tx
+	if err = Unpack(bytes.NewBuffer(b), &tx); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxIn) != 2 || len(tx.TxOut) != 2 || tx.Locktime != 0x11 {
+		t.Fatal("illegal tx")
+	}
+	if len(tx.TxIn[0].Witness) != 0 || len(tx.TxIn[1].Witness) != 2 {
+		t.Fatal("illegal witness")
+	}
+	if !tx.HasWitness() {
+		t.Error("tx must have witness")
+	}
+	if behex.EncodeToString(tx.Hash()) != txid {
+		t.Error("txid unmatches", behex.EncodeToString(tx.Hash()))
+	}
+	if behex.EncodeToString(tx.WitnessHash()) != wtxid {
+		t.Error("wtxid unmatches", behex.EncodeToString(tx.WitnessHash()))
+	}
+
+	var buf bytes.Buffer
+	if err = Pack(&buf, tx); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Error("packed tx unmatches")
+	}
+
+	s := tx.Stripped()
+	if s.HasWitness() || tx.Stripped().TxIn[1].Witness != nil || !tx.HasWitness() {
+		t.Error("illegal stripped tx")
+	}
+	if !bytes.Equal(s.WitnessHash(), tx.Hash()) {
+		t.Error("wtxid of stripped tx must be txid")
+	}
+	var buf2 bytes.Buffer
+	if err = Pack(&buf2, *s); err != nil {
+		t.Fatal(err)
+	}
+	var tx2 Tx
+	if err = Unpack(&buf2, &tx2); err != nil {
+		t.Fatal(err)
+	}
+	if tx2.HasWitness() || !bytes.Equal(tx2.Hash(), tx.Hash()) {
+		t.Error("illegal unpacked tx without witness")
+	}
+}
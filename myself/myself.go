@@ -32,7 +32,9 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/monarj/wallet/params"
 )
@@ -41,11 +43,22 @@ var (
 	//myself represents my ip and port.
 	myself *net.TCPAddr
 	mutex  sync.RWMutex
+
+	//offsets are time offsets of peers in seconds.
+	offsets    = make(map[string]int64)
+	timeOffset int64
+)
+
+const (
+	//maxTimeSamples is the max number of peers whose time offsets are used.
+	maxTimeSamples = 200
+	//maxTimeAdjustment is the max offset in seconds to adjust time by peers.
+	maxTimeAdjustment = 70 * 60
 )
 
 func init() {
 	var err error
-	myself, err = net.ResolveTCPAddr("tcp", fmt.Sprintf("127.0.0.1:%d", params.Port))
+	myself, err = net.ResolveTCPAddr("tcp", fmt.Sprintf("127.0.0.1:%d", params.Net.Port))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -73,3 +86,37 @@ func Get() *net.TCPAddr {
 	m = *myself
 	return &m
 }
+
+//AddTimeSample adds the time of peer reported in a version packet
+//to calculate the network-adjusted time.
+//The offset is the median of offsets of peers like bitcoind.
+func AddTimeSample(peer string, peerTime int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, exist := offsets[peer]; exist || len(offsets) >= maxTimeSamples {
+		return
+	}
+	offsets[peer] = peerTime - time.Now().Unix()
+	if len(offsets) < 5 || len(offsets)%2 != 1 {
+		return
+	}
+	sorted := make([]int64, 0, len(offsets))
+	for _, o := range offsets {
+		sorted = append(sorted, o)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	if median > maxTimeAdjustment || median < -maxTimeAdjustment {
+		log.Println("time offset of peers", median, "is too large, please check your clock")
+		timeOffset = 0
+		return
+	}
+	timeOffset = median
+}
+
+//AdjustedTime returns the current time adjusted by the times of peers.
+func AdjustedTime() time.Time {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return time.Now().Add(time.Duration(timeOffset) * time.Second)
+}
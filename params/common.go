@@ -28,6 +28,13 @@
 
 package params
 
+import (
+	"errors"
+	"log"
+
+	"github.com/monarj/wallet/behex"
+)
+
 const (
 	//Version is the version of this program.
 	Version = "0.0.0"
@@ -35,9 +42,127 @@ const (
 	MainNet = "main"
 	//TestNet represents testnet.
 	TestNet = "test"
+	//RegTest represents regtest.
+	RegTest = "regtest"
 
 	//UserAgent is the user agent.
 	UserAgent = "/monarj:" + Version + "/"
 	//Unit is base unit.
 	Unit = 100000000
+
+	//ProtocolVersion is the version which this program supports.
+	ProtocolVersion uint32 = 70003
+
+	//Nconfirmed is the block height block is regarded as confirmed.
+	Nconfirmed uint64 = 5
+	//Fee is the default fee rate per kB of transactions.
+	Fee = uint64(0.001 * Unit) //  1m MONA/kB
+	//MinRelayFee is the minimum fee rate per kB which nodes relay.
+	MinRelayFee = uint64(0.0001 * Unit)
+	//DustRelayFee is the fee rate per kB to judge whether a txout is dust,
+	//i.e. its value is less than the fee to spend it.
+	DustRelayFee = 3 * MinRelayFee
+
+	//SpendableCoinbaseDepth is the block depth constrains when coinbase is used.
+	SpendableCoinbaseDepth = 100
+
+	//TargetSpacing is the target interval of blocks in seconds.
+	TargetSpacing = 90
+	//TargetTimespan is the timespan of the first retarget algorithm in seconds.
+	TargetTimespan = 95040 // 1.1 days
 )
+
+//Network is parameters which differ between networks.
+type Network struct {
+	//ID is id to identify the network.
+	ID string
+	//DumpedPrivateKeyHeader is the first byte of a base58 encoded dumped private key.
+	DumpedPrivateKeyHeader byte
+	//DumpedPrivateKeyHeaderAlt  is another first byte of a base58 encoded dumped private key.
+	DumpedPrivateKeyHeaderAlt byte
+	//AddressHeader is the First byte of a base58 encoded address. This the one used for "normal" addresses.
+	AddressHeader byte
+	//P2SHHeader is the first byte of a base58 encoded P2SH address.  P2SH addresses are defined as part of BIP0013.
+	P2SHHeader byte
+	//P2SHHeaderAlt is the legacy first byte of a base58 encoded P2SH address.
+	//It is accepted when decoding but never used for encoding.
+	P2SHHeaderAlt byte
+	//Bech32HRP is the human readable part of segwit addresses (BIP173).
+	Bech32HRP string
+	//HDPrivateKeyID is the version bytes of serialized extended private keys.
+	HDPrivateKeyID []byte
+	//HDPublicKeyID is the version bytes of serialized extended public keys.
+	HDPublicKeyID []byte
+	//HDCoinType is the coin type of BIP44 derivation paths.
+	HDCoinType uint32
+	//Port is the default port of listen.
+	Port int
+	//ProofOfWorkLimit is the upper limits of target in nBits format.
+	ProofOfWorkLimit uint32
+	//PacketMagic is  the header bytes that identify the start of a packet on this network
+	PacketMagic []byte
+	//DNSSeeds is the list of dns for node seeds.
+	DNSSeeds []string
+	//GenesisHash is the hash of genesis blocks.
+	GenesisHash []byte
+	//CheckPoints are points hash should be checked.
+	CheckPoints map[uint64][]byte
+	//Prevs is previous block hash of CheckPoints.
+	Prevs map[uint64][]byte
+	//PoWFunc is a func to calculate PoW.
+	PoWFunc func(height uint64, data []byte) []byte
+	//KGWHeight is the height where retarget algorithm switches to KimotoGravityWell.
+	KGWHeight uint64
+	//DigiShieldHeight is the height where retarget algorithm switches to DigiShield.
+	DigiShieldHeight uint64
+	//DGWHeight is the height where retarget algorithm switches to DarkGravityWave.
+	DGWHeight uint64
+	//AllowMinDifficulty allows blocks with ProofOfWorkLimit if they come
+	//2*TargetSpacing after previous blocks.
+	AllowMinDifficulty bool
+	//NoRetargeting disables retargeting.
+	NoRetargeting bool
+}
+
+var (
+	//Net is the network this program works on.
+	//It must be selected by Select at startup if not mainnet.
+	Net = MainNetParams
+
+	networks = map[string]*Network{
+		MainNet: MainNetParams,
+		TestNet: TestNet4Params,
+		RegTest: RegTestParams,
+	}
+)
+
+//Select selects the network whose ID is id.
+func Select(id string) error {
+	n, ok := networks[id]
+	if !ok {
+		return errors.New("unknown network " + id)
+	}
+	Net = n
+	return nil
+}
+
+//setCheckPoints sets hex-encoded checkpoints and their previous hashes to n.
+func (n *Network) setCheckPoints(cpoints, prevs map[uint64]string) {
+	n.CheckPoints = make(map[uint64][]byte)
+	n.Prevs = make(map[uint64][]byte)
+	for k, v := range cpoints {
+		h, err := behex.DecodeString(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n.CheckPoints[k] = h
+	}
+	for k, v := range prevs {
+		h, err := behex.DecodeString(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n.Prevs[k] = h
+	}
+	n.GenesisHash = n.CheckPoints[0]
+}
@@ -33,57 +33,30 @@ import (
 
 	"golang.org/x/crypto/scrypt"
 
-	"github.com/monarj/wallet/behex"
 	"github.com/monarj/wallet/lyra2re2"
 )
 
-const (
-	//ProtocolVersion is the version which this program supports.
-	ProtocolVersion uint32 = 70003
-
-	//Nconfirmed is the block height block is regarded as confirmed.
-	Nconfirmed uint64 = 5
-	//Fee for a transaction
-	Fee = uint64(0.001 * Unit) //  1m MONA/kB
-
-	//DumpedPrivateKeyHeader is the first byte of a base58 encoded dumped private key.
-	DumpedPrivateKeyHeader byte = 178 //This is always addressHeader + 128
-	//DumpedPrivateKeyHeaderAlt  is another first byte of a base58 encoded dumped private key.
-	DumpedPrivateKeyHeaderAlt byte = 176 // monacoin-qt 0.10.x (not modified from litecoin ...)
-	//AddressHeader is the First byte of a base58 encoded address. This the one used for "normal" addresses.
-	AddressHeader byte = 50
-	//P2SHHeader is the first byte of a base58 encoded P2SH address.  P2SH addresses are defined as part of BIP0013.
-	P2SHHeader = 5
-	//ID is id to identify testnet or mainnet.
-	ID = MainNet
-	//Port is the default port of listen.
-	Port = 9401
-	//SpendableCoinbaseDepth is the block depth constrains when coinbase is used.
-	SpendableCoinbaseDepth = 100
-	//ProofOfWorkLimit is the upper limits of target in nBits format.
-	ProofOfWorkLimit = 0x1e0fffff
-)
-
-var (
-	//PacketMagic is  the header bytes that identify the start of a packet on this network
-	PacketMagic = []byte{0xfb, 0xc0, 0xb6, 0xdb}
-	//GenesisHash is the hash of genesis blocks.
-	GenesisHash []byte
-	//CheckPoints are points hash should be checked.
-	CheckPoints = make(map[uint64][]byte)
-	//Prevs is previous block hash of CheckPoints.
-	Prevs = make(map[uint64][]byte)
-	//DNSSeeds is the list of dns for node seeds.
-	DNSSeeds = []string{
+//MainNetParams is the parameters for monacoin mainnet.
+var MainNetParams = &Network{
+	ID:                        MainNet,
+	DumpedPrivateKeyHeader:    178, //This is always addressHeader + 128
+	DumpedPrivateKeyHeaderAlt: 176, // monacoin-qt 0.10.x (not modified from litecoin ...)
+	AddressHeader:             50,
+	P2SHHeader:                55, //starts with P
+	P2SHHeaderAlt:             5,  //starts with 3, same as bitcoin
+	Bech32HRP:                 "mona",
+	HDPrivateKeyID:            []byte{0x04, 0x88, 0xad, 0xe4}, // starts with xprv
+	HDPublicKeyID:             []byte{0x04, 0x88, 0xb2, 0x1e}, // starts with xpub
+	HDCoinType:                22,
+	Port:                      9401,
+	ProofOfWorkLimit:          0x1e0fffff,
+	PacketMagic:               []byte{0xfb, 0xc0, 0xb6, 0xdb},
+	DNSSeeds: []string{
 		"dnsseed.monacoin.org",
 		"dnsseed-multimona-test.tk",
 		"seed.givememona.tk",
-	}
-	HDPrivateKeyID = []byte{0x04, 0x88, 0xad, 0xe4} // starts with xprv
-	HDPublicKeyID  = []byte{0x04, 0x88, 0xb2, 0x1e} // starts with xpub
-
-	//PoWFunc is a func to calculate PoW.
-	PoWFunc = func(height uint64, data []byte) []byte {
+	},
+	PoWFunc: func(height uint64, data []byte) []byte {
 		if height >= 450000 {
 			converted, err := lyra2re2.Sum(data)
 			if err != nil {
@@ -96,17 +69,13 @@ var (
 			log.Fatal(err)
 		}
 		return converted
-	}
-)
+	},
+	KGWHeight:        80000,
+	DigiShieldHeight: 140000,
+	DGWHeight:        450000,
+}
 
 func init() {
-	var err error
-
-	hashGenesis :=
-		"ff9f1c0116d19de7c9963845e129f9ed1bfc0b376eb54fd7afa42e0d418c8bb6"
-	if GenesisHash, err = behex.DecodeString(hashGenesis); err != nil {
-		log.Fatal(err)
-	}
 	cpoints := map[uint64]string{
 		0:      "ff9f1c0116d19de7c9963845e129f9ed1bfc0b376eb54fd7afa42e0d418c8bb6",
 		1500:   "9f42d51d18d0a8914a00664c433a0ca4be3eed02f9374d790bffbd3d3053d41d",
@@ -122,13 +91,6 @@ func init() {
 		444000: "3ed05516cdce4db93b135189592c7e2b37d768f99a1819a1d2ea3a8e5b8439a8",
 		655000: "4c556ef37bc75e95820200d2ae25472d7e2c05a981667beef5b2f6a64b5ce546",
 	}
-	for k, v := range cpoints {
-		h, err := behex.DecodeString(v)
-		if err != nil {
-			log.Fatal(err)
-		}
-		CheckPoints[k] = h
-	}
 	prevs := map[uint64]string{
 		0:      "0000000000000000000000000000000000000000000000000000000000000000",
 		1500:   "9bfb0a32684c8e68839e08d59f2fbecc69586368540a2e1439e765d56072ff89",
@@ -144,11 +106,5 @@ func init() {
 		444000: "d165120dbb2a3ada178a7c40961e3ddef94646127335b996d589d3573f870bdd",
 		655000: "fb72709f01a5a23fd998c71a1a2266dea3390e9ca59e18bacd80fe4626bdb7be",
 	}
-	for k, v := range prevs {
-		h, err := behex.DecodeString(v)
-		if err != nil {
-			log.Fatal(err)
-		}
-		Prevs[k] = h
-	}
+	MainNetParams.setCheckPoints(cpoints, prevs)
 }
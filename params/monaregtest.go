@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package params
+
+//RegTestParams is the parameters for monacoin regression test network.
+//There are no DNS seeds, so peers must be added manually.
+var RegTestParams = &Network{
+	ID:                        RegTest,
+	DumpedPrivateKeyHeader:    239,
+	DumpedPrivateKeyHeaderAlt: 239,
+	AddressHeader:             111,
+	P2SHHeader:                117, //starts with p
+	P2SHHeaderAlt:             196, //starts with 2, same as bitcoin
+	Bech32HRP:                 "rmona",
+	HDPrivateKeyID:            []byte{0x04, 0x35, 0x83, 0x94}, // starts with tprv
+	HDPublicKeyID:             []byte{0x04, 0x35, 0x87, 0xcf}, // starts with tpub
+	HDCoinType:                1,
+	Port:                      20444,
+	ProofOfWorkLimit:          0x207fffff,
+	PacketMagic:               []byte{0xfa, 0xbf, 0xb5, 0xda},
+	PoWFunc:                   lyra2re2PoW,
+	AllowMinDifficulty:        true,
+	NoRetargeting:             true,
+}
+
+func init() {
+	RegTestParams.setCheckPoints(map[uint64]string{
+		0: "7543a69d7c2fcdb29a5ebec2fc064c074a35253b6f3072c8a749473aa590a29c",
+	}, map[uint64]string{
+		0: "0000000000000000000000000000000000000000000000000000000000000000",
+	})
+}
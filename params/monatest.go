@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package params
+
+import (
+	"log"
+	"math"
+
+	"github.com/monarj/wallet/lyra2re2"
+)
+
+//lyra2re2PoW calculates PoW by lyra2re2 regardless of height.
+func lyra2re2PoW(height uint64, data []byte) []byte {
+	converted, err := lyra2re2.Sum(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return converted
+}
+
+//TestNet4Params is the parameters for monacoin testnet4.
+var TestNet4Params = &Network{
+	ID:                        TestNet,
+	DumpedPrivateKeyHeader:    239,
+	DumpedPrivateKeyHeaderAlt: 239,
+	AddressHeader:             111,
+	P2SHHeader:                117, //starts with p
+	P2SHHeaderAlt:             196, //starts with 2, same as bitcoin
+	Bech32HRP:                 "tmona",
+	HDPrivateKeyID:            []byte{0x04, 0x35, 0x83, 0x94}, // starts with tprv
+	HDPublicKeyID:             []byte{0x04, 0x35, 0x87, 0xcf}, // starts with tpub
+	HDCoinType:                1,
+	Port:                      19403,
+	ProofOfWorkLimit:          0x1e0fffff,
+	PacketMagic:               []byte{0xfd, 0xd2, 0xc8, 0xf1},
+	DNSSeeds: []string{
+		"testnet-dnsseed.monacoin.org",
+	},
+	PoWFunc: lyra2re2PoW,
+	//switch heights of retarget algorithms of testnet4 are not known,
+	//so nBits are not checked at all like KimotoGravityWell blocks on mainnet.
+	KGWHeight:          0,
+	DigiShieldHeight:   math.MaxUint64,
+	DGWHeight:          math.MaxUint64,
+	AllowMinDifficulty: true,
+}
+
+func init() {
+	TestNet4Params.setCheckPoints(map[uint64]string{
+		0: "a2b106ceba3be0c6d097b2a6a6aacf9d638ba8258ae478158f449c321061e0b2",
+	}, map[uint64]string{
+		0: "0000000000000000000000000000000000000000000000000000000000000000",
+	})
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package peer
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/msg"
+)
+
+//pendingTx is a transaction broadcasted by us and not confirmed yet.
+type pendingTx struct {
+	tx        *msg.Tx
+	requested map[string]struct{}
+}
+
+var (
+	pending = make(map[string]*pendingTx)
+	pmutex  sync.RWMutex
+)
+
+//Broadcast announces tx to all alive peers by inv, and
+//serves the tx to peers which request it by getdata.
+func Broadcast(t *msg.Tx) error {
+	hash := t.Hash()
+	id := behex.EncodeToString(hash)
+	pmutex.Lock()
+	if _, exist := pending[id]; !exist {
+		pending[id] = &pendingTx{
+			tx:        t,
+			requested: make(map[string]struct{}),
+		}
+	}
+	pmutex.Unlock()
+
+	mutex.RLock()
+	ps := make([]*Peer, 0, len(alive))
+	for _, n := range alive {
+		ps = append(ps, n)
+	}
+	mutex.RUnlock()
+	sent := 0
+	for _, n := range ps {
+		if n.announce(hash) {
+			sent++
+		}
+	}
+	if sent == 0 {
+		return errors.New("no peers to broadcast the tx")
+	}
+	log.Println("announced tx", id, "to", sent, "peers")
+	return nil
+}
+
+//Requested returns the number of peers which requested the tx
+//with txid hash after it was broadcasted.
+func Requested(hash []byte) int {
+	pmutex.RLock()
+	defer pmutex.RUnlock()
+	p, exist := pending[behex.EncodeToString(hash)]
+	if !exist {
+		return 0
+	}
+	return len(p.requested)
+}
+
+//Pending returns transactions broadcasted by us which are not confirmed yet.
+func Pending() []*msg.Tx {
+	pmutex.RLock()
+	defer pmutex.RUnlock()
+	r := make([]*msg.Tx, 0, len(pending))
+	for _, p := range pending {
+		r = append(r, p.tx)
+	}
+	return r
+}
+
+//confirmed stops serving the tx with txid hash because it is in a block.
+func confirmed(hash []byte) {
+	pmutex.Lock()
+	defer pmutex.Unlock()
+	delete(pending, behex.EncodeToString(hash))
+}
+
+//announce queues an inv of a tx to the peer.
+//It returns false if the queue of the peer is full.
+func (n *Peer) announce(hashes ...[]byte) bool {
+	return n.queue("inv", makeInv(msg.MsgTX, hashes))
+}
+
+//queue queues a message to the peer without waiting.
+//It returns false if the queue of the peer is full.
+func (n *Peer) queue(c string, data interface{}) bool {
+	if n.bch == nil {
+		return false
+	}
+	cmd := &writeCmd{
+		cmd:  c,
+		data: data,
+		err:  make(chan error, 1),
+	}
+	select {
+	case n.bch <- cmd:
+		return true
+	default:
+		log.Println("write queue of", n.String(), "is full")
+		return false
+	}
+}
+
+//announcePending announces all pending txs to the peer.
+func (n *Peer) announcePending() {
+	pmutex.RLock()
+	hashes := make([][]byte, 0, len(pending))
+	for _, p := range pending {
+		hashes = append(hashes, p.tx.Hash())
+	}
+	pmutex.RUnlock()
+	if len(hashes) > 0 {
+		n.announce(hashes...)
+	}
+}
+
+//readGetdata sends our pending txs which are requested by the peer.
+func (n *Peer) readGetdata(payload io.Reader, pch <-chan *packet) error {
+	p := msg.Getdata{}
+	if err := msg.Unpack(payload, &p); err != nil {
+		return err
+	}
+	var notfound []msg.InvVec
+	for _, inv := range p.Inventory {
+		if inv.Type != msg.MsgTX && inv.Type != msg.MsgWitnessTX {
+			notfound = append(notfound, inv)
+			continue
+		}
+		id := behex.EncodeToString(inv.Hash)
+		pmutex.Lock()
+		pt, exist := pending[id]
+		if exist {
+			pt.requested[n.String()] = struct{}{}
+		}
+		pmutex.Unlock()
+		if !exist {
+			notfound = append(notfound, inv)
+			continue
+		}
+		t := pt.tx
+		if inv.Type == msg.MsgTX {
+			//the peer doesn't understand witness.
+			t = t.Stripped()
+		}
+		if err := n.writeMessage("tx", *t); err != nil {
+			return err
+		}
+		log.Println("sended tx", id, "to", n.String())
+	}
+	if len(notfound) == 0 {
+		return nil
+	}
+	return n.writeMessage("notfound", msg.NotFound{Inventory: notfound})
+}
@@ -29,6 +29,7 @@
 package peer
 
 import (
+	"fmt"
 	"log"
 	"net"
 	"sync"
@@ -40,7 +41,9 @@ import (
 	"github.com/monarj/wallet/behex"
 	"github.com/monarj/wallet/block"
 	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/key"
 	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/myself"
 	"github.com/monarj/wallet/params"
 )
 
@@ -48,6 +51,7 @@ var (
 	alive = make(map[string]*Peer)
 	peers = make(map[string]*net.TCPAddr)
 	mutex sync.RWMutex
+	wdb   *db.DB
 )
 
 const (
@@ -75,7 +79,7 @@ func Del(n net.Addr) {
 //Resolve resolvs node addresses from the dns seed.
 func Resolve() {
 	var wg sync.WaitGroup
-	for _, dns := range params.DNSSeeds {
+	for _, dns := range params.Net.DNSSeeds {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -92,7 +96,7 @@ func Resolve() {
 				}
 				n := &net.TCPAddr{
 					IP:   ip,
-					Port: params.Port,
+					Port: params.Net.Port,
 				}
 				Add(n)
 			}
@@ -120,7 +124,10 @@ func Connect() {
 					log.Println(err)
 					continue
 				}
-				n := &Peer{conn: conn.(*net.TCPConn)}
+				n := &Peer{
+					conn: conn.(*net.TCPConn),
+					bch:  make(chan *writeCmd, maxNodes),
+				}
 				mutex.Lock()
 				_, exist := alive[s]
 				if exist {
@@ -156,23 +163,93 @@ func peersNum() int {
 	return len(peers)
 }
 
+//Candidates returns addresses of candidate peers.
+func Candidates() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	r := make([]string, 0, len(peers))
+	for k := range peers {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
 var (
 	hashes = make(chan []byte, maxNodes*10)
 	synced = false
 )
 
-//Run starts to connect nodes.
-func Run() {
+func init() {
+	block.OnDisconnect(rewindMerkle)
+	key.OnNewKeys(reloadFilter)
+}
+
+//rewindMerkle makes merkle blocks in the new chain be downloaded
+//when block b is disconnected from the main chain.
+func rewindMerkle(tx *bolt.Tx, b *block.Block) error {
+	var lastheight uint64
+	if _, err := db.Get(tx, "status", []byte("lastmerkle"), &lastheight); err != nil {
+		return nil
+	}
+	if lastheight < b.Height {
+		return nil
+	}
+	return db.Put(tx, "status", []byte("lastmerkle"), b.Height-1)
+}
+
+//Init sets the database used in this package.
+func Init(d *db.DB) {
+	wdb = d
+}
+
+//initLastMerkle sets the height of the last merkle block to zero
+//if it has not been set yet.
+func initLastMerkle() error {
+	return wdb.Update(func(tx *bolt.Tx) error {
+		if db.HasKey(tx, "status", []byte("lastmerkle")) {
+			return nil
+		}
+		log.Print("writing lastmerkle")
+		return db.Put(tx, "status", []byte("lastmerkle"), uint64(0))
+	})
+}
+
+//Rescan makes merkle blocks be downloaded again from the first block
+//to find transactions of keys added to the wallet.
+func Rescan() error {
+	return wdb.BatchPut("status", []byte("lastmerkle"), uint64(0))
+}
+
+//Dial resolves peers and starts to connect them, and waits until
+//num peers are alive. It returns an error if timeout(>0) passes.
+func Dial(num int, timeout time.Duration) error {
+	myself.SetPort(params.Net.Port)
 	log.Print("resolving dns")
 	Resolve()
 	log.Print("connecting")
 	for i := 0; i < maxNodes; i++ {
 		Connect()
 	}
-	for AliveNum() < maxNodes {
+	start := time.Now()
+	for AliveNum() < num {
+		if timeout > 0 && time.Since(start) > timeout {
+			return fmt.Errorf("only %d peers are alive", AliveNum())
+		}
 		log.Print("waiting for alive peers, now ", AliveNum())
 		time.Sleep(5 * time.Second)
 	}
+	return nil
+}
+
+//Run starts to connect nodes.
+func Run() {
+	if err := initLastMerkle(); err != nil {
+		log.Fatal(err)
+	}
+	if err := Dial(maxNodes, 0); err != nil {
+		log.Fatal(err)
+	}
 	log.Print("start to get header")
 	goGetHeader()
 	log.Print("start to get txs")
@@ -270,7 +347,7 @@ func sendMerkles() {
 	defer mutex.Unlock()
 	sort.Sort(finished)
 	var lastheight uint64
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
 		_, err := db.Get(tx, "status", []byte("lastmerkle"), &lastheight)
 		return err
 	})
@@ -288,7 +365,7 @@ func sendMerkles() {
 		}
 		lastheight++
 	}
-	err = db.Batch("status", []byte("lastmerkle"), lastheight)
+	err = wdb.BatchPut("status", []byte("lastmerkle"), lastheight)
 	if err != nil {
 		log.Fatal(err)
 	}
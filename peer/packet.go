@@ -61,8 +61,8 @@ func (n *Peer) readMessage() (string, *bytes.Buffer, error) {
 	}
 	h := sha256.Sum256(message.Payload)
 	h = sha256.Sum256(h[:])
-	if !bytes.Equal(message.Magic, params.PacketMagic) {
-		return "", nil, fmt.Errorf("magic unmatch %x %x", message.Magic, params.PacketMagic)
+	if !bytes.Equal(message.Magic, params.Net.PacketMagic) {
+		return "", nil, fmt.Errorf("magic unmatch %x %x", message.Magic, params.Net.PacketMagic)
 	}
 	if !bytes.Equal(message.CheckSum, h[:4]) {
 		return "", nil, fmt.Errorf("checksum unmatch %x %x", message.CheckSum, h[:4])
@@ -102,7 +102,7 @@ func (n *Peer) writeMessage(cmd string, payload interface{}) error {
 	h := sha256.Sum256(dat)
 	h = sha256.Sum256(h[:])
 	message := msg.Message{
-		Magic:    params.PacketMagic,
+		Magic:    params.Net.PacketMagic,
 		Command:  []byte(cmd),
 		Length:   uint32(len(dat)),
 		CheckSum: h[:4],
@@ -141,6 +141,7 @@ func (n *Peer) readVersion() (*msg.Version, error) {
 		return nil, errors.New("Version is old")
 	}
 	n.LastBlock = version.StartHeight
+	myself.AddTimeSample(n.String(), int64(version.Timestamp))
 	myself.SetIP(version.AddrRecv.IPv6)
 	return &version, nil
 }
@@ -158,7 +159,7 @@ func (n *Peer) writeVersion() error {
 	}
 	ver := msg.Version{
 		Version:     params.ProtocolVersion,
-		Service:     0, // NODE_NETWORK
+		Service:     0, //SPV nodes serve no blocks.
 		Timestamp:   uint64(time.Now().Unix()),
 		AddrRecv:    *r,
 		AddrFrom:    *f,
@@ -200,20 +201,35 @@ func (n *Peer) readPong(payload io.Reader, pch <-chan *packet) error {
 	return nil
 }
 
-func (n *Peer) writeFilterload() error {
+func filterLoad() msg.FilterLoad {
 	bf := key.BloomFilter()
-	po := msg.FilterLoad{
+	return msg.FilterLoad{
 		Filter: []byte(bf),
 
 		NhashFuncs: bloom.HashFuncs,
 		NTweak:     bloom.Tweak,
 		Nflags:     1,
 	}
-	err := n.writeMessage("filterload", po)
+}
+
+func (n *Peer) writeFilterload() error {
+	err := n.writeMessage("filterload", filterLoad())
 	log.Println("sended filterload")
 	return err
 }
 
+//reloadFilter sends the bloom filter again to all alive peers
+//because keys were derived ahead.
+func reloadFilter(pubs []*key.PublicKey) {
+	po := filterLoad()
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, n := range alive {
+		n.queue("filterload", po)
+	}
+	log.Println("reloading filter with", len(pubs), "new keys")
+}
+
 func (n *Peer) writeFilteradd(data [][]byte) error {
 	bf := bloom.New()
 	for _, k := range data {
@@ -239,11 +255,11 @@ func (n *Peer) readInv(payload io.Reader, pch <-chan *packet) error {
 	for _, inv := range p.Inventory {
 		log.Printf("readinv %d %s", inv.Type, behex.EncodeToString(inv.Hash))
 		switch inv.Type {
-		case msg.MsgTX:
+		case msg.MsgTX, msg.MsgWitnessTX:
 			//ignore because we cannot check the validity
 		case msg.MsgBlock:
 			hashes = append(hashes, inv.Hash)
-		case msg.MsgFilterdBlock:
+		case msg.MsgFilterdBlock, msg.MsgWitnessBlock:
 		//can do nothing because of SPV.
 		default:
 			return fmt.Errorf("unknown inv type %d", inv.Type)
@@ -307,8 +323,11 @@ func (n *Peer) readTx(payload io.Reader, txs []msg.Hash, hash []byte) error {
 	if !ok {
 		return errors.New("no hash in txs")
 	}
-	err := tx.Add(&p, hash)
-	return err
+	if err := tx.Add(&p, hash); err != nil {
+		return err
+	}
+	confirmed(p.Hash())
+	return nil
 }
 
 func (n *Peer) readMerkle(payload io.Reader, pch <-chan *packet) error {
@@ -319,7 +338,7 @@ func (n *Peer) readMerkle(payload io.Reader, pch <-chan *packet) error {
 	}
 	hblock := p.Hash()
 	log.Println(behex.EncodeToString(hblock))
-	if !bytes.Equal(hblock, params.GenesisHash) && !block.HasBlock(hblock) {
+	if !bytes.Equal(hblock, params.Net.GenesisHash) && !block.HasBlock(hblock) {
 		if _, err = block.AddMerkle(&p); err != nil {
 			txhashes <- [][]byte{hblock}
 			return err
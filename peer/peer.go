@@ -64,6 +64,7 @@ type Peer struct {
 	lastPing  uint64
 	LastBlock uint32
 	Closed    bool
+	bch       chan *writeCmd
 }
 
 //Close closes conn.
@@ -96,6 +97,7 @@ func (n *Peer) Loop() error {
 		"headers":     n.readHeaders,
 		"merkleblock": n.readMerkle,
 		"addr":        n.readAddr,
+		"getdata":     n.readGetdata,
 	}
 	pch := n.goReadMessage()
 	t := time.NewTimer(3 * time.Minute)
@@ -131,6 +133,13 @@ func (n *Peer) Loop() error {
 				log.Println(err)
 			}
 			log.Print("sended ", w.cmd)
+		case w := <-n.bch:
+			err := n.writeMessage(w.cmd, w.data)
+			w.err <- err
+			if err != nil {
+				return n.errClose(err)
+			}
+			log.Print("sended ", w.cmd, " to ", n.String())
 		case <-t.C:
 			if n.timeout++; n.timeout > timeout {
 				return errors.New("timeout")
@@ -183,6 +192,7 @@ func (n *Peer) Handshake() error {
 		log.Println(err)
 		return err
 	}
+	n.announcePending()
 	if peersNum() > maxNodes*10 {
 		return nil
 	}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package script
+
+//Class is a type of standard scripts.
+type Class int
+
+//Classes of scripts.
+const (
+	NonStandard Class = iota
+	PubKey
+	PubKeyHash
+	ScriptHash
+	Multisig
+	NullData
+	WitnessPubKeyHash
+	WitnessScriptHash
+	WitnessUnknown
+)
+
+var classNames = []string{
+	"nonstandard",
+	"pubkey",
+	"pubkeyhash",
+	"scripthash",
+	"multisig",
+	"nulldata",
+	"witness_v0_keyhash",
+	"witness_v0_scripthash",
+	"witness_unknown",
+}
+
+//String returns the name of the class like bitcoind.
+func (c Class) String() string {
+	if c < 0 || int(c) >= len(classNames) {
+		return classNames[NonStandard]
+	}
+	return classNames[c]
+}
+
+//Template is a classified script.
+type Template struct {
+	Class Class
+	//Data is public keys for PubKey and Multisig, the hash for PubKeyHash,
+	//ScriptHash and witness scripts, and pushed data for NullData.
+	Data [][]byte
+	//M is the number of required signatures of Multisig.
+	M int
+}
+
+//Classify returns the template of the output script.
+func Classify(script []byte) *Template {
+	if IsP2SH(script) {
+		return &Template{Class: ScriptHash, Data: [][]byte{script[2:22]}}
+	}
+	if v, prog, ok := WitnessProgram(script); ok {
+		switch {
+		case v == 0 && len(prog) == 20:
+			return &Template{Class: WitnessPubKeyHash, Data: [][]byte{prog}}
+		case v == 0 && len(prog) == 32:
+			return &Template{Class: WitnessScriptHash, Data: [][]byte{prog}}
+		case v != 0:
+			return &Template{Class: WitnessUnknown, Data: [][]byte{prog}}
+		}
+		return &Template{Class: NonStandard}
+	}
+	tokens, err := Parse(script)
+	if err != nil || len(tokens) == 0 {
+		return &Template{Class: NonStandard}
+	}
+	switch {
+	case len(tokens) == 2 && isPubKey(tokens[0].Data) && tokens[1].Op == OpCHECKSIG:
+		return &Template{Class: PubKey, Data: [][]byte{tokens[0].Data}}
+	case len(tokens) == 5 && tokens[0].Op == OpDUP && tokens[1].Op == OpHASH160 &&
+		len(tokens[2].Data) == 20 && tokens[3].Op == OpEQUALVERIFY && tokens[4].Op == OpCHECKSIG:
+		return &Template{Class: PubKeyHash, Data: [][]byte{tokens[2].Data}}
+	case tokens[0].Op == OpRETURN:
+		t := &Template{Class: NullData}
+		for _, tk := range tokens[1:] {
+			if !tk.IsPush() {
+				return &Template{Class: NonStandard}
+			}
+			t.Data = append(t.Data, tk.Data)
+		}
+		return t
+	}
+	if t := classifyMultisig(tokens); t != nil {
+		return t
+	}
+	return &Template{Class: NonStandard}
+}
+
+func classifyMultisig(tokens []*Token) *Template {
+	if len(tokens) < 4 || tokens[len(tokens)-1].Op != OpCHECKMULTISIG {
+		return nil
+	}
+	m := SmallInt(tokens[0].Op)
+	n := SmallInt(tokens[len(tokens)-2].Op)
+	if m < 1 || n < m || n != len(tokens)-3 {
+		return nil
+	}
+	t := &Template{Class: Multisig, M: m}
+	for _, tk := range tokens[1 : len(tokens)-2] {
+		if !isPubKey(tk.Data) {
+			return nil
+		}
+		t.Data = append(t.Data, tk.Data)
+	}
+	return t
+}
+
+func isPubKey(b []byte) bool {
+	switch {
+	case len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03):
+		return true
+	case len(b) == 65 && b[0] == 0x04:
+		return true
+	}
+	return false
+}
+
+//IsP2SH returns true if script is OP_HASH160 <20 bytes> OP_EQUAL.
+func IsP2SH(script []byte) bool {
+	return len(script) == 23 && script[0] == OpHASH160 && script[1] == 0x14 &&
+		script[22] == OpEQUAL
+}
+
+//WitnessProgram returns the version and the program if script is
+//a witness program.
+func WitnessProgram(script []byte) (int, []byte, bool) {
+	if len(script) < 4 || len(script) > 42 {
+		return 0, nil, false
+	}
+	v := SmallInt(script[0])
+	if v < 0 || int(script[1])+2 != len(script) {
+		return 0, nil, false
+	}
+	return v, script[2:], true
+}
+
+//PayToWitness returns the witness program script of version ver.
+func PayToWitness(ver int, prog []byte) []byte {
+	scr := make([]byte, 0, len(prog)+2)
+	if ver == 0 {
+		scr = append(scr, Op0)
+	} else {
+		scr = append(scr, Op1+byte(ver-1))
+	}
+	return append(scr, PushData(prog)...)
+}
+
+//PayToPubKeyHash returns the P2PKH script of the pubkey hash.
+func PayToPubKeyHash(hash []byte) []byte {
+	scr := make([]byte, 0, len(hash)+5)
+	scr = append(scr, OpDUP, OpHASH160)
+	scr = append(scr, PushData(hash)...)
+	return append(scr, OpEQUALVERIFY, OpCHECKSIG)
+}
+
+//PayToScriptHash returns the P2SH script of the script hash.
+func PayToScriptHash(hash []byte) []byte {
+	scr := make([]byte, 0, len(hash)+3)
+	scr = append(scr, OpHASH160)
+	scr = append(scr, PushData(hash)...)
+	return append(scr, OpEQUAL)
+}
+
+//MultisigScript returns the script of m of len(pubs) multisig.
+func MultisigScript(m int, pubs [][]byte) []byte {
+	scr := PushInt(int64(m))
+	for _, p := range pubs {
+		scr = append(scr, PushData(p)...)
+	}
+	scr = append(scr, PushInt(int64(len(pubs)))...)
+	return append(scr, OpCHECKMULTISIG)
+}
@@ -0,0 +1,775 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package script
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/monarj/wallet/btcec"
+	"github.com/monarj/wallet/msg"
+	"golang.org/x/crypto/ripemd160"
+)
+
+//Flags are rules to verify scripts.
+//Signatures must always be strict DER (BIP66).
+type Flags uint32
+
+//Verification flags.
+const (
+	//VerifyP2SH evaluates redeem scripts of P2SH (BIP16).
+	VerifyP2SH Flags = 1 << iota
+	//VerifyCheckLockTime enables OP_CHECKLOCKTIMEVERIFY (BIP65).
+	VerifyCheckLockTime
+	//VerifyCheckSequence enables OP_CHECKSEQUENCEVERIFY (BIP112).
+	VerifyCheckSequence
+	//VerifyWitness evaluates witness programs (BIP141, BIP143).
+	VerifyWitness
+)
+
+//StandardFlags are flags active on the network.
+const StandardFlags = VerifyP2SH | VerifyCheckLockTime | VerifyCheckSequence | VerifyWitness
+
+//ErrEvalFalse is returned when a script finishes with false on the stack.
+var ErrEvalFalse = errors.New("script evaluated to false")
+
+const (
+	lockTimeThreshold    = 500000000
+	sequenceDisableFlag  = uint32(1 << 31)
+	sequenceTypeFlag     = uint32(1 << 22)
+	sequenceLockTimeMask = uint32(0x0000ffff)
+)
+
+//engine executes scripts for the input idx of tx.
+type engine struct {
+	tx      *msg.Tx
+	idx     int
+	amount  uint64
+	flags   Flags
+	witness bool
+	stack   stack
+	alt     stack
+	ops     int
+}
+
+//VerifyInput verifies that the input idx of mtx can spend prev.
+//The value of prev is used for signatures of witness programs.
+func VerifyInput(mtx *msg.Tx, idx int, prev *msg.TxOut, flags Flags) error {
+	if idx < 0 || idx >= len(mtx.TxIn) {
+		return errors.New("input index out of range")
+	}
+	in := mtx.TxIn[idx]
+	p2sh := flags&VerifyP2SH != 0 && IsP2SH(prev.Script)
+	if p2sh && !IsPushOnly(in.Script) {
+		return errors.New("scriptsig of P2SH is not push only")
+	}
+	e := &engine{
+		tx:     mtx,
+		idx:    idx,
+		amount: prev.Value,
+		flags:  flags,
+	}
+	if err := e.execute(in.Script); err != nil {
+		return err
+	}
+	saved := make(stack, len(e.stack))
+	copy(saved, e.stack)
+	if err := e.execute(prev.Script); err != nil {
+		return err
+	}
+	if !e.success() {
+		return ErrEvalFalse
+	}
+	hasWitness := false
+	if ver, prog, ok := WitnessProgram(prev.Script); ok && flags&VerifyWitness != 0 {
+		hasWitness = true
+		if len(in.Script) != 0 {
+			return errors.New("scriptsig of witness program is not empty")
+		}
+		if err := e.verifyWitness(ver, prog, in.Witness); err != nil {
+			return err
+		}
+	}
+	if p2sh {
+		e.stack = saved
+		redeem, err := e.stack.pop()
+		if err != nil {
+			return err
+		}
+		if err := e.execute(redeem); err != nil {
+			return err
+		}
+		if !e.success() {
+			return ErrEvalFalse
+		}
+		if ver, prog, ok := WitnessProgram(redeem); ok && flags&VerifyWitness != 0 {
+			hasWitness = true
+			if !bytes.Equal(in.Script, PushData(redeem)) {
+				return errors.New("scriptsig of P2SH witness program is not a push of the program")
+			}
+			if err := e.verifyWitness(ver, prog, in.Witness); err != nil {
+				return err
+			}
+		}
+	}
+	if flags&VerifyWitness != 0 && !hasWitness && len(in.Witness) != 0 {
+		return errors.New("unexpected witness")
+	}
+	return nil
+}
+
+//verifyWitness verifies witness for the witness program prog whose version is ver.
+func (e *engine) verifyWitness(ver int, prog []byte, witness [][]byte) error {
+	var pkscript []byte
+	var items [][]byte
+	switch {
+	case ver != 0:
+		//reserved for soft forks.
+		return nil
+	case len(prog) == 20:
+		if len(witness) != 2 {
+			return errors.New("illegal witness of P2WPKH")
+		}
+		pkscript = PayToPubKeyHash(prog)
+		items = witness
+	case len(prog) == 32:
+		if len(witness) == 0 {
+			return errors.New("empty witness of P2WSH")
+		}
+		pkscript = witness[len(witness)-1]
+		if h := sha256.Sum256(pkscript); !bytes.Equal(h[:], prog) {
+			return errors.New("witness script unmatches")
+		}
+		items = witness[:len(witness)-1]
+	default:
+		return errors.New("illegal length of witness program")
+	}
+	e.stack = make(stack, len(items))
+	for i, item := range items {
+		if len(item) > MaxPushSize {
+			return errors.New("too long witness item")
+		}
+		e.stack[i] = item
+	}
+	e.witness = true
+	if err := e.execute(pkscript); err != nil {
+		return err
+	}
+	if len(e.stack) != 1 {
+		return errors.New("stack is not clean after witness script")
+	}
+	if !e.success() {
+		return ErrEvalFalse
+	}
+	return nil
+}
+
+func (e *engine) success() bool {
+	b, err := e.stack.peek(0)
+	return err == nil && toBool(b)
+}
+
+//execute runs script with the current stack.
+func (e *engine) execute(script []byte) error {
+	if len(script) > MaxScriptSize {
+		return errors.New("too long script")
+	}
+	tokens, err := Parse(script)
+	if err != nil {
+		return err
+	}
+	e.alt = nil
+	e.ops = 0
+	var cond []bool
+	sep := 0
+	offset := 0
+	for _, t := range tokens {
+		offset += len(t.raw)
+		exec := true
+		for _, c := range cond {
+			exec = exec && c
+		}
+		if len(t.Data) > MaxPushSize {
+			return errors.New("too long push")
+		}
+		if t.Op > Op16 {
+			if e.ops++; e.ops > MaxOps {
+				return errors.New("too many operations")
+			}
+		}
+		if isDisabled(t.Op) {
+			return fmt.Errorf("disabled opcode %s", OpName(t.Op))
+		}
+		switch {
+		case t.Op == OpIF || t.Op == OpNOTIF:
+			v := false
+			if exec {
+				if v, err = e.stack.popBool(); err != nil {
+					return err
+				}
+				if t.Op == OpNOTIF {
+					v = !v
+				}
+			}
+			cond = append(cond, v)
+		case t.Op == OpELSE:
+			if len(cond) == 0 {
+				return errors.New("OP_ELSE without OP_IF")
+			}
+			cond[len(cond)-1] = !cond[len(cond)-1]
+		case t.Op == OpENDIF:
+			if len(cond) == 0 {
+				return errors.New("OP_ENDIF without OP_IF")
+			}
+			cond = cond[:len(cond)-1]
+		case !exec:
+		case t.Op <= OpPUSHDATA4:
+			e.stack.push(t.Data)
+		case t.Op >= Op1 && t.Op <= Op16:
+			e.stack.push(fromNum(num(SmallInt(t.Op))))
+		case t.Op == OpCODESEPARATOR:
+			sep = offset
+		default:
+			if err := e.step(t.Op, script[sep:]); err != nil {
+				return fmt.Errorf("%s: %s", OpName(t.Op), err)
+			}
+		}
+		if len(e.stack)+len(e.alt) > MaxStackSize {
+			return errors.New("stack overflow")
+		}
+	}
+	if len(cond) != 0 {
+		return errors.New("unbalanced conditional")
+	}
+	return nil
+}
+
+//step executes op, which is not a push or flow control.
+//subscript is the script after the last OP_CODESEPARATOR.
+func (e *engine) step(op byte, subscript []byte) error {
+	s := &e.stack
+	switch op {
+	case Op1NEGATE:
+		s.push(fromNum(-1))
+	case OpNOP, OpNOP1, OpNOP4, OpNOP4 + 1, OpNOP4 + 2, OpNOP4 + 3, OpNOP4 + 4, OpNOP4 + 5, OpNOP10:
+	case OpCHECKLOCKTIMEVERIFY:
+		if e.flags&VerifyCheckLockTime == 0 {
+			return nil
+		}
+		return e.checkLockTime()
+	case OpCHECKSEQUENCEVERIFY:
+		if e.flags&VerifyCheckSequence == 0 {
+			return nil
+		}
+		return e.checkSequence()
+	case OpVERIFY:
+		return e.verify()
+	case OpRETURN:
+		return errors.New("OP_RETURN is executed")
+
+	case OpTOALTSTACK:
+		b, err := s.pop()
+		if err != nil {
+			return err
+		}
+		e.alt.push(b)
+	case OpFROMALTSTACK:
+		b, err := e.alt.pop()
+		if err != nil {
+			return err
+		}
+		s.push(b)
+	case Op2DROP:
+		for i := 0; i < 2; i++ {
+			if _, err := s.pop(); err != nil {
+				return err
+			}
+		}
+	case Op2DUP:
+		return s.dup(2)
+	case Op3DUP:
+		return s.dup(3)
+	case Op2OVER:
+		for i := 0; i < 2; i++ {
+			b, err := s.peek(3)
+			if err != nil {
+				return err
+			}
+			s.push(b)
+		}
+	case Op2ROT:
+		for i := 0; i < 2; i++ {
+			b, err := s.remove(5)
+			if err != nil {
+				return err
+			}
+			s.push(b)
+		}
+	case Op2SWAP:
+		for i := 0; i < 2; i++ {
+			b, err := s.remove(3)
+			if err != nil {
+				return err
+			}
+			s.push(b)
+		}
+	case OpIFDUP:
+		b, err := s.peek(0)
+		if err != nil {
+			return err
+		}
+		if toBool(b) {
+			s.push(b)
+		}
+	case OpDEPTH:
+		s.push(fromNum(num(len(*s))))
+	case OpDROP:
+		_, err := s.pop()
+		return err
+	case OpDUP:
+		return s.dup(1)
+	case OpNIP:
+		_, err := s.remove(1)
+		return err
+	case OpOVER:
+		b, err := s.peek(1)
+		if err != nil {
+			return err
+		}
+		s.push(b)
+	case OpPICK, OpROLL:
+		n, err := s.popNum(maxNumSize)
+		if err != nil {
+			return err
+		}
+		if n < 0 || int(n) >= len(*s) {
+			return errStackEmpty
+		}
+		var b []byte
+		if op == OpPICK {
+			b, err = s.peek(int(n))
+		} else {
+			b, err = s.remove(int(n))
+		}
+		if err != nil {
+			return err
+		}
+		s.push(b)
+	case OpROT:
+		b, err := s.remove(2)
+		if err != nil {
+			return err
+		}
+		s.push(b)
+	case OpSWAP:
+		b, err := s.remove(1)
+		if err != nil {
+			return err
+		}
+		s.push(b)
+	case OpTUCK:
+		if len(*s) < 2 {
+			return errStackEmpty
+		}
+		top := (*s)[len(*s)-1]
+		*s = append((*s)[:len(*s)-2], top, (*s)[len(*s)-2], top)
+	case OpSIZE:
+		b, err := s.peek(0)
+		if err != nil {
+			return err
+		}
+		s.push(fromNum(num(len(b))))
+
+	case OpEQUAL, OpEQUALVERIFY:
+		a, err := s.pop()
+		if err != nil {
+			return err
+		}
+		b, err := s.pop()
+		if err != nil {
+			return err
+		}
+		s.push(fromBool(bytes.Equal(a, b)))
+		if op == OpEQUALVERIFY {
+			return e.verify()
+		}
+
+	case Op1ADD, Op1SUB, OpNEGATE, OpABS, OpNOT, Op0NOTEQUAL:
+		return e.unary(op)
+	case OpADD, OpSUB, OpBOOLAND, OpBOOLOR, OpNUMEQUAL, OpNUMEQUALVERIFY, OpNUMNOTEQUAL,
+		OpLESSTHAN, OpGREATERTHAN, OpLESSTHANOREQUAL, OpGREATERTHANOREQUAL, OpMIN, OpMAX:
+		return e.binary(op)
+	case OpWITHIN:
+		max, err := s.popNum(maxNumSize)
+		if err != nil {
+			return err
+		}
+		min, err := s.popNum(maxNumSize)
+		if err != nil {
+			return err
+		}
+		x, err := s.popNum(maxNumSize)
+		if err != nil {
+			return err
+		}
+		s.push(fromBool(min <= x && x < max))
+
+	case OpRIPEMD160, OpSHA1, OpSHA256, OpHASH160, OpHASH256:
+		b, err := s.pop()
+		if err != nil {
+			return err
+		}
+		s.push(hashOp(op, b))
+	case OpCHECKSIG, OpCHECKSIGVERIFY:
+		pub, err := s.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := s.pop()
+		if err != nil {
+			return err
+		}
+		if !e.witness {
+			if subscript, err = removeData(subscript, sig); err != nil {
+				return err
+			}
+		}
+		ok, err := e.checkSig(sig, pub, subscript)
+		if err != nil {
+			return err
+		}
+		s.push(fromBool(ok))
+		if op == OpCHECKSIGVERIFY {
+			return e.verify()
+		}
+	case OpCHECKMULTISIG, OpCHECKMULTISIGVERIFY:
+		if err := e.checkMultisig(subscript); err != nil {
+			return err
+		}
+		if op == OpCHECKMULTISIGVERIFY {
+			return e.verify()
+		}
+	default:
+		return errors.New("bad opcode")
+	}
+	return nil
+}
+
+func (e *engine) verify() error {
+	v, err := e.stack.popBool()
+	if err != nil {
+		return err
+	}
+	if !v {
+		return errors.New("verify failed")
+	}
+	return nil
+}
+
+func (e *engine) unary(op byte) error {
+	n, err := e.stack.popNum(maxNumSize)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case Op1ADD:
+		n++
+	case Op1SUB:
+		n--
+	case OpNEGATE:
+		n = -n
+	case OpABS:
+		if n < 0 {
+			n = -n
+		}
+	case OpNOT:
+		e.stack.push(fromBool(n == 0))
+		return nil
+	case Op0NOTEQUAL:
+		e.stack.push(fromBool(n != 0))
+		return nil
+	}
+	e.stack.push(fromNum(n))
+	return nil
+}
+
+func (e *engine) binary(op byte) error {
+	b, err := e.stack.popNum(maxNumSize)
+	if err != nil {
+		return err
+	}
+	a, err := e.stack.popNum(maxNumSize)
+	if err != nil {
+		return err
+	}
+	var r []byte
+	switch op {
+	case OpADD:
+		r = fromNum(a + b)
+	case OpSUB:
+		r = fromNum(a - b)
+	case OpBOOLAND:
+		r = fromBool(a != 0 && b != 0)
+	case OpBOOLOR:
+		r = fromBool(a != 0 || b != 0)
+	case OpNUMEQUAL, OpNUMEQUALVERIFY:
+		r = fromBool(a == b)
+	case OpNUMNOTEQUAL:
+		r = fromBool(a != b)
+	case OpLESSTHAN:
+		r = fromBool(a < b)
+	case OpGREATERTHAN:
+		r = fromBool(a > b)
+	case OpLESSTHANOREQUAL:
+		r = fromBool(a <= b)
+	case OpGREATERTHANOREQUAL:
+		r = fromBool(a >= b)
+	case OpMIN:
+		if b < a {
+			a = b
+		}
+		r = fromNum(a)
+	case OpMAX:
+		if b > a {
+			a = b
+		}
+		r = fromNum(a)
+	}
+	e.stack.push(r)
+	if op == OpNUMEQUALVERIFY {
+		return e.verify()
+	}
+	return nil
+}
+
+func hashOp(op byte, b []byte) []byte {
+	switch op {
+	case OpRIPEMD160:
+		h := ripemd160.New()
+		h.Write(b)
+		return h.Sum(nil)
+	case OpSHA1:
+		h := sha1.Sum(b)
+		return h[:]
+	case OpSHA256:
+		h := sha256.Sum256(b)
+		return h[:]
+	case OpHASH160:
+		return Hash160(b)
+	}
+	return hash256(b)
+}
+
+//Hash160 returns ripemd160(sha256(b)).
+func Hash160(b []byte) []byte {
+	h := sha256.Sum256(b)
+	r := ripemd160.New()
+	r.Write(h[:])
+	return r.Sum(nil)
+}
+
+//checkSig returns true if sig with the hash type is valid for pub.
+//It returns an error if sig is not empty and not strict DER (BIP66).
+func (e *engine) checkSig(sig, pub, subscript []byte) (bool, error) {
+	if len(sig) == 0 {
+		return false, nil
+	}
+	if !isStrictDER(sig) {
+		return false, errors.New("signature is not strict DER")
+	}
+	hashType := uint32(sig[len(sig)-1])
+	var h []byte
+	var err error
+	if e.witness {
+		h, err = WitnessSignatureHash(e.tx, e.idx, subscript, e.amount, hashType)
+	} else {
+		h, err = SignatureHash(e.tx, e.idx, subscript, hashType)
+	}
+	if err != nil {
+		return false, nil
+	}
+	return verifySig(sig[:len(sig)-1], pub, h), nil
+}
+
+//isStrictDER returns true if sig with the hash type is encoded in strict DER.
+func isStrictDER(sig []byte) bool {
+	if len(sig) < 9 || len(sig) > 73 || sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return false
+	}
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	switch {
+	case lenR+lenS+7 != len(sig):
+		return false
+	case sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0:
+		return false
+	case lenR > 1 && sig[4] == 0 && sig[5]&0x80 == 0:
+		return false
+	case sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0:
+		return false
+	case lenS > 1 && sig[lenR+6] == 0 && sig[lenR+7]&0x80 == 0:
+		return false
+	}
+	return true
+}
+
+func verifySig(sig, pub, hash []byte) bool {
+	curve := btcec.S256()
+	p, err := btcec.ParsePubKey(pub, curve)
+	if err != nil {
+		return false
+	}
+	s, err := btcec.ParseDERSignature(sig, curve)
+	if err != nil {
+		return false
+	}
+	return s.Verify(hash, p)
+}
+
+func (e *engine) checkMultisig(subscript []byte) error {
+	s := &e.stack
+	n, err := s.popNum(maxNumSize)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > MaxPubsPerSig {
+		return errors.New("illegal number of public keys")
+	}
+	if e.ops += int(n); e.ops > MaxOps {
+		return errors.New("too many operations")
+	}
+	pubs := make([][]byte, n)
+	for i := int(n) - 1; i >= 0; i-- {
+		if pubs[i], err = s.pop(); err != nil {
+			return err
+		}
+	}
+	m, err := s.popNum(maxNumSize)
+	if err != nil {
+		return err
+	}
+	if m < 0 || m > n {
+		return errors.New("illegal number of signatures")
+	}
+	sigs := make([][]byte, m)
+	for i := int(m) - 1; i >= 0; i-- {
+		if sigs[i], err = s.pop(); err != nil {
+			return err
+		}
+	}
+	//bitcoind pops one more item by its bug.
+	if _, err = s.pop(); err != nil {
+		return err
+	}
+	for i := 0; i < len(sigs) && !e.witness; i++ {
+		if subscript, err = removeData(subscript, sigs[i]); err != nil {
+			return err
+		}
+	}
+	//signatures are checked from the last one as bitcoind does.
+	ok := true
+	for isig, ikey := len(sigs)-1, len(pubs)-1; ok && isig >= 0; ikey-- {
+		v, err := e.checkSig(sigs[isig], pubs[ikey], subscript)
+		if err != nil {
+			return err
+		}
+		if v {
+			isig--
+		}
+		if isig > ikey-1 {
+			ok = false
+		}
+	}
+	s.push(fromBool(ok))
+	return nil
+}
+
+func (e *engine) checkLockTime() error {
+	b, err := e.stack.peek(0)
+	if err != nil {
+		return err
+	}
+	n, err := toNum(b, maxLockNumSize)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return errors.New("negative locktime")
+	}
+	lt := num(e.tx.Locktime)
+	if (n < lockTimeThreshold) != (lt < lockTimeThreshold) {
+		return errors.New("type of locktime unmatches")
+	}
+	if n > lt {
+		return errors.New("locktime is not satisfied")
+	}
+	if e.tx.TxIn[e.idx].Seq == 0xffffffff {
+		return errors.New("input is final")
+	}
+	return nil
+}
+
+func (e *engine) checkSequence() error {
+	b, err := e.stack.peek(0)
+	if err != nil {
+		return err
+	}
+	n, err := toNum(b, maxLockNumSize)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return errors.New("negative sequence")
+	}
+	if uint32(n)&sequenceDisableFlag != 0 {
+		return nil
+	}
+	if e.tx.Version < 2 {
+		return errors.New("tx version is less than 2")
+	}
+	seq := e.tx.TxIn[e.idx].Seq
+	if seq&sequenceDisableFlag != 0 {
+		return errors.New("sequence of input is disabled")
+	}
+	mask := sequenceTypeFlag | sequenceLockTimeMask
+	v, txv := uint32(n)&mask, seq&mask
+	if (v < sequenceTypeFlag) != (txv < sequenceTypeFlag) {
+		return errors.New("type of sequence unmatches")
+	}
+	if v > txv {
+		return errors.New("sequence is not satisfied")
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package script
+
+import (
+	"errors"
+	"strconv"
+)
+
+//num is a number in scripts, which is serialized in little endian
+//with the sign bit in the most significant byte.
+type num int64
+
+//toNum decodes b as a number which must not be longer than max bytes.
+func toNum(b []byte, max int) (num, error) {
+	if len(b) > max {
+		return 0, errors.New("too long number")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var n int64
+	for i, v := range b {
+		n |= int64(v) << uint(8*i)
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		n &= ^(int64(0x80) << uint(8*(len(b)-1)))
+		n = -n
+	}
+	return num(n), nil
+}
+
+//fromNum encodes n in the minimal form.
+func fromNum(n num) []byte {
+	if n == 0 {
+		return nil
+	}
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append(b, byte(n&0xff))
+	}
+	switch {
+	case b[len(b)-1]&0x80 != 0 && neg:
+		b = append(b, 0x80)
+	case b[len(b)-1]&0x80 != 0:
+		b = append(b, 0)
+	case neg:
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+func (n num) String() string {
+	return strconv.FormatInt(int64(n), 10)
+}
+
+//toBool returns false if b is zero or negative zero.
+func toBool(b []byte) bool {
+	for i, v := range b {
+		if v != 0 {
+			return i != len(b)-1 || v != 0x80
+		}
+	}
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
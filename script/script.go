@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+//Limits of scripts.
+const (
+	MaxScriptSize  = 10000
+	MaxPushSize    = 520
+	MaxOps         = 201
+	MaxStackSize   = 1000
+	MaxPubsPerSig  = 20
+	maxNumSize     = 4
+	maxLockNumSize = 5
+)
+
+//Token is an opcode and data pushed by it.
+type Token struct {
+	Op   byte
+	Data []byte
+	//raw is the bytes of the token in the script.
+	raw []byte
+}
+
+//Parse splits script into tokens.
+func Parse(script []byte) ([]*Token, error) {
+	var tokens []*Token
+	for i := 0; i < len(script); {
+		start := i
+		op := script[i]
+		i++
+		n := 0
+		switch {
+		case op <= OpDATA75:
+			n = int(op)
+		case op == OpPUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errors.New("script is too short for OP_PUSHDATA1")
+			}
+			n = int(script[i])
+			i++
+		case op == OpPUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errors.New("script is too short for OP_PUSHDATA2")
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case op == OpPUSHDATA4:
+			if i+4 > len(script) {
+				return nil, errors.New("script is too short for OP_PUSHDATA4")
+			}
+			l := binary.LittleEndian.Uint32(script[i:])
+			if l > MaxScriptSize {
+				return nil, errors.New("too long push")
+			}
+			n = int(l)
+			i += 4
+		}
+		if i+n > len(script) {
+			return nil, errors.New("script is too short for pushed data")
+		}
+		t := &Token{
+			Op:  op,
+			raw: script[start : i+n],
+		}
+		if op <= OpPUSHDATA4 {
+			t.Data = script[i : i+n]
+		}
+		tokens = append(tokens, t)
+		i += n
+	}
+	return tokens, nil
+}
+
+//IsPush returns true if the token pushes data or a number.
+func (t *Token) IsPush() bool {
+	return t.Op <= Op16 && t.Op != OpRESERVED
+}
+
+//String returns the token in the disassembled form.
+func (t *Token) String() string {
+	switch {
+	case t.Op == Op0 || t.Op > OpPUSHDATA4:
+		return OpName(t.Op)
+	case len(t.Data) <= maxNumSize:
+		n, err := toNum(t.Data, maxNumSize)
+		if err == nil {
+			return n.String()
+		}
+	}
+	return hex.EncodeToString(t.Data)
+}
+
+//Disasm returns the script in human readable form like bitcoind's asm.
+func Disasm(script []byte) (string, error) {
+	tokens, err := Parse(script)
+	if err != nil {
+		return "", err
+	}
+	strs := make([]string, len(tokens))
+	for i, t := range tokens {
+		strs[i] = t.String()
+	}
+	return strings.Join(strs, " "), nil
+}
+
+//IsPushOnly returns true if script consists of pushes only.
+func IsPushOnly(script []byte) bool {
+	tokens, err := Parse(script)
+	if err != nil {
+		return false
+	}
+	for _, t := range tokens {
+		if !t.IsPush() {
+			return false
+		}
+	}
+	return true
+}
+
+//PushedData returns data pushed by the push-only script.
+//Numbers pushed by OP_1NEGATE and OP_1 to OP_16 are returned in their script form.
+func PushedData(script []byte) ([][]byte, error) {
+	tokens, err := Parse(script)
+	if err != nil {
+		return nil, err
+	}
+	data := make([][]byte, len(tokens))
+	for i, t := range tokens {
+		switch {
+		case !t.IsPush():
+			return nil, errors.New("not a push only script")
+		case t.Op >= Op1NEGATE:
+			data[i] = fromNum(num(int(t.Op) - int(Op1) + 1))
+		default:
+			data[i] = t.Data
+		}
+	}
+	return data, nil
+}
+
+//PushData returns the script which pushes b with the push opcode
+//for its length.
+func PushData(b []byte) []byte {
+	var scr []byte
+	switch {
+	case len(b) <= int(OpDATA75):
+		scr = append(scr, byte(len(b)))
+	case len(b) <= 0xff:
+		scr = append(scr, OpPUSHDATA1, byte(len(b)))
+	case len(b) <= 0xffff:
+		scr = append(scr, OpPUSHDATA2, byte(len(b)), byte(len(b)>>8))
+	default:
+		scr = append(scr, OpPUSHDATA4)
+		l := make([]byte, 4)
+		binary.LittleEndian.PutUint32(l, uint32(len(b)))
+		scr = append(scr, l...)
+	}
+	return append(scr, b...)
+}
+
+//PushInt returns the script which pushes n.
+func PushInt(n int64) []byte {
+	switch {
+	case n == 0:
+		return []byte{Op0}
+	case n == -1:
+		return []byte{Op1NEGATE}
+	case n >= 1 && n <= 16:
+		return []byte{Op1 + byte(n) - 1}
+	}
+	return PushData(fromNum(num(n)))
+}
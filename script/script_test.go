@@ -0,0 +1,274 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/monarj/wallet/btcec"
+	"github.com/monarj/wallet/msg"
+)
+
+func TestDisasm(t *testing.T) {
+	pkscript, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Disasm(pkscript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != "OP_DUP OP_HASH160 d94987ba89c258372030bc9d610f895477578964 OP_EQUALVERIFY OP_CHECKSIG" {
+		t.Error("illegal disasm", d)
+	}
+	if _, err = Disasm(pkscript[:10]); err == nil {
+		t.Error("truncated script must not be parsed")
+	}
+	if d, _ = Disasm([]byte{Op0, Op16, 0x01, 0x81, 0x02, 0xe8, 0x03}); d != "0 16 -1 1000" {
+		t.Error("illegal disasm", d)
+	}
+}
+
+func TestNum(t *testing.T) {
+	for _, n := range []num{0, 1, -1, 127, 128, -128, 255, 256, 1<<31 - 1, -(1<<31 - 1)} {
+		m, err := toNum(fromNum(n), maxNumSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != n {
+			t.Error("num unmatches", n, m)
+		}
+	}
+	if _, err := toNum([]byte{1, 2, 3, 4, 5}, maxNumSize); err == nil {
+		t.Error("too long number must be rejected")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := priv.PubKey().SerializeCompressed()
+	hash := Hash160(pub)
+	multi := MultisigScript(1, [][]byte{pub, pub})
+	scripts := []struct {
+		script []byte
+		class  Class
+	}{
+		{append(PushData(pub), OpCHECKSIG), PubKey},
+		{PayToPubKeyHash(hash), PubKeyHash},
+		{PayToScriptHash(hash), ScriptHash},
+		{multi, Multisig},
+		{append([]byte{OpRETURN}, PushData([]byte("mona"))...), NullData},
+		{append([]byte{Op0}, PushData(hash)...), WitnessPubKeyHash},
+		{append([]byte{Op0}, PushData(make([]byte, 32))...), WitnessScriptHash},
+		{append([]byte{Op1}, PushData(make([]byte, 32))...), WitnessUnknown},
+		{[]byte{OpDUP, OpDROP}, NonStandard},
+	}
+	for i, s := range scripts {
+		if c := Classify(s.script).Class; c != s.class {
+			t.Error(i, "illegal class", c, s.class)
+		}
+	}
+	tmpl := Classify(multi)
+	if tmpl.M != 1 || len(tmpl.Data) != 2 || !bytes.Equal(tmpl.Data[0], pub) {
+		t.Error("illegal multisig template")
+	}
+}
+
+func TestSignatureHash(t *testing.T) {
+	//from sighash.json of bitcoin core
+	tests := []struct {
+		tx       string
+		script   string
+		idx      int
+		hashType int32
+		result   string
+	}{
+		{
+			"b240517501334021240427adb0b413433641555424f6d24647211e3e6bfbb22a8045cbda2f000000000071bac8630112717802000000000000000000",
+			"6a5165abac52656551", 0, 1790414254,
+			"2c8be597620d95abd88f9c1cf4967c1ae3ca2309f3afec8928058c9598660e9e",
+		},
+		{
+			"c33028b301d5093e1e8397270d75a0b009b2a6509a01861061ab022ca122a6ba935b8513320200000000ffffffff013bcf5a0500000000015200000000",
+			"", 0, -513413204,
+			"6b1459536f51482f5dbf42d7e561896557461e1e3b6bf67871e2b51faae2832c",
+		},
+	}
+	for _, tc := range tests {
+		rawtx, err := hex.DecodeString(tc.tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mtx msg.Tx
+		if err = msg.Unpack(bytes.NewBuffer(rawtx), &mtx); err != nil {
+			t.Fatal(err)
+		}
+		subscript, err := hex.DecodeString(tc.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := SignatureHash(&mtx, tc.idx, subscript, uint32(tc.hashType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		//result is printed in reversed order
+		for i := 0; i < len(h)/2; i++ {
+			h[i], h[len(h)-1-i] = h[len(h)-1-i], h[i]
+		}
+		if hex.EncodeToString(h) != tc.result {
+			t.Error("sighash unmatches", hex.EncodeToString(h))
+		}
+	}
+}
+
+func TestWitnessSignatureHash(t *testing.T) {
+	//native P2WPKH from BIP143
+	rawtx, err := hex.DecodeString("0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mtx msg.Tx
+	if err = msg.Unpack(bytes.NewBuffer(rawtx), &mtx); err != nil {
+		t.Fatal(err)
+	}
+	code, err := hex.DecodeString("76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := WitnessSignatureHash(&mtx, 1, code, 600000000, SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(h) != "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670" {
+		t.Error("witness sighash unmatches", hex.EncodeToString(h))
+	}
+}
+
+func TestVerifyWitness(t *testing.T) {
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := priv.PubKey().SerializeCompressed()
+	program := append([]byte{Op0}, PushData(Hash160(pub))...)
+	for _, p2sh := range []bool{false, true} {
+		prev := &msg.TxOut{
+			Value:  100000,
+			Script: program,
+		}
+		mtx := &msg.Tx{
+			Version: 1,
+			TxIn:    []msg.TxIn{{Hash: make([]byte, 32), Seq: 0xffffffff}},
+			TxOut:   []msg.TxOut{{Value: 90000, Script: PayToPubKeyHash(Hash160(pub))}},
+		}
+		if p2sh {
+			prev.Script = PayToScriptHash(Hash160(program))
+			mtx.TxIn[0].Script = PushData(program)
+		}
+		h, err := WitnessSignatureHash(mtx, 0, PayToPubKeyHash(Hash160(pub)), prev.Value, SigHashAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := priv.Sign(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mtx.TxIn[0].Witness = [][]byte{append(sig.Serialize(), byte(SigHashAll)), pub}
+		if err = VerifyInput(mtx, 0, prev, StandardFlags); err != nil {
+			t.Error(p2sh, err)
+		}
+		prev.Value = 100001
+		if err = VerifyInput(mtx, 0, prev, StandardFlags); err == nil {
+			t.Error(p2sh, "wrong amount must not be verified")
+		}
+		prev.Value = 100000
+		mtx.TxIn[0].Witness = mtx.TxIn[0].Witness[:1]
+		if err = VerifyInput(mtx, 0, prev, StandardFlags); err == nil {
+			t.Error(p2sh, "illegal witness must not be verified")
+		}
+	}
+}
+
+func TestVerifyInput(t *testing.T) {
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := priv.PubKey().SerializeCompressed()
+	prev := &msg.TxOut{
+		Value:  100000,
+		Script: PayToPubKeyHash(Hash160(pub)),
+	}
+	mtx := &msg.Tx{
+		Version:  1,
+		TxIn:     []msg.TxIn{{Hash: make([]byte, 32), Seq: 0xffffffff}},
+		TxOut:    []msg.TxOut{{Value: 90000, Script: prev.Script}},
+		Locktime: 0,
+	}
+	h, err := SignatureHash(mtx, 0, prev.Script, SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := priv.Sign(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigb := append(sig.Serialize(), byte(SigHashAll))
+	mtx.TxIn[0].Script = append(PushData(sigb), PushData(pub)...)
+	if err = VerifyInput(mtx, 0, prev, StandardFlags); err != nil {
+		t.Error(err)
+	}
+
+	mtx.TxOut[0].Value = 95000
+	if err = VerifyInput(mtx, 0, prev, StandardFlags); err == nil {
+		t.Error("modified tx must not be verified")
+	}
+	mtx.TxOut[0].Value = 90000
+
+	mtx.TxIn[0].Script = append(PushData(sigb), PushData(priv.PubKey().SerializeUncompressed())...)
+	if err = VerifyInput(mtx, 0, prev, StandardFlags); err == nil {
+		t.Error("wrong pubkey must not be verified")
+	}
+
+	//OP_1 OP_1 OP_ADD OP_2 OP_EQUAL
+	prev.Script = []byte{Op1, Op1, OpADD, Op1 + 1, OpEQUAL}
+	mtx.TxIn[0].Script = nil
+	if err = VerifyInput(mtx, 0, prev, StandardFlags); err != nil {
+		t.Error(err)
+	}
+	prev.Script = []byte{Op1, Op1 + 1, OpEQUAL}
+	if err = VerifyInput(mtx, 0, prev, StandardFlags); err != ErrEvalFalse {
+		t.Error("false script must not be verified", err)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/monarj/wallet/msg"
+)
+
+//Signature hash types.
+const (
+	SigHashAll          = uint32(0x01)
+	SigHashNone         = uint32(0x02)
+	SigHashSingle       = uint32(0x03)
+	SigHashAnyoneCanPay = uint32(0x80)
+	sigHashMask         = uint32(0x1f)
+)
+
+//SignatureHash returns the legacy hash of mtx to be signed for
+//the input idx, whose script code is subscript.
+func SignatureHash(mtx *msg.Tx, idx int, subscript []byte, hashType uint32) ([]byte, error) {
+	if idx < 0 || idx >= len(mtx.TxIn) {
+		return nil, errors.New("input index out of range")
+	}
+	if hashType&sigHashMask == SigHashSingle && idx >= len(mtx.TxOut) {
+		//same as bitcoind, which returns 1 for this case.
+		one := make([]byte, 32)
+		one[0] = 1
+		return one, nil
+	}
+	code, err := removeOp(subscript, OpCODESEPARATOR)
+	if err != nil {
+		return nil, err
+	}
+	t := msg.Tx{
+		Version:  mtx.Version,
+		TxIn:     make([]msg.TxIn, len(mtx.TxIn)),
+		Locktime: mtx.Locktime,
+	}
+	copy(t.TxIn, mtx.TxIn)
+	for i := range t.TxIn {
+		t.TxIn[i].Script = nil
+		t.TxIn[i].Witness = nil
+	}
+	t.TxIn[idx].Script = code
+
+	switch hashType & sigHashMask {
+	case SigHashNone:
+		for i := range t.TxIn {
+			if i != idx {
+				t.TxIn[i].Seq = 0
+			}
+		}
+	case SigHashSingle:
+		t.TxOut = make([]msg.TxOut, idx+1)
+		for i := 0; i < idx; i++ {
+			t.TxOut[i].Value = ^uint64(0)
+		}
+		t.TxOut[idx] = mtx.TxOut[idx]
+		for i := range t.TxIn {
+			if i != idx {
+				t.TxIn[i].Seq = 0
+			}
+		}
+	default:
+		t.TxOut = mtx.TxOut
+	}
+	if hashType&SigHashAnyoneCanPay != 0 {
+		t.TxIn = t.TxIn[idx : idx+1]
+	}
+
+	var buf bytes.Buffer
+	if err := msg.Pack(&buf, t); err != nil {
+		return nil, err
+	}
+	ht := make([]byte, 4)
+	binary.LittleEndian.PutUint32(ht, hashType)
+	buf.Write(ht)
+	return hash256(buf.Bytes()), nil
+}
+
+//witnessPreimage is the data to be hashed for signatures in witness programs (BIP143).
+type witnessPreimage struct {
+	Version      uint32
+	HashPrevouts []byte `len:"32"`
+	HashSequence []byte `len:"32"`
+	Hash         []byte `len:"32"`
+	Index        uint32
+	ScriptCode   []byte `len:"prev"`
+	Amount       uint64
+	Seq          uint32
+	HashOutputs  []byte `len:"32"`
+	Locktime     uint32
+	HashType     uint32
+}
+
+//WitnessSignatureHash returns the hash of mtx to be signed for the input idx
+//of a witness program (BIP143), whose script code is subscript and
+//whose previous output has amount.
+func WitnessSignatureHash(mtx *msg.Tx, idx int, subscript []byte, amount uint64, hashType uint32) ([]byte, error) {
+	if idx < 0 || idx >= len(mtx.TxIn) {
+		return nil, errors.New("input index out of range")
+	}
+	in := mtx.TxIn[idx]
+	p := witnessPreimage{
+		Version:      mtx.Version,
+		HashPrevouts: make([]byte, 32),
+		HashSequence: make([]byte, 32),
+		Hash:         in.Hash,
+		Index:        in.Index,
+		ScriptCode:   subscript,
+		Amount:       amount,
+		Seq:          in.Seq,
+		HashOutputs:  make([]byte, 32),
+		Locktime:     mtx.Locktime,
+		HashType:     hashType,
+	}
+	anyone := hashType&SigHashAnyoneCanPay != 0
+	base := hashType & sigHashMask
+	if !anyone {
+		var prevouts, seqs bytes.Buffer
+		for _, in := range mtx.TxIn {
+			prevouts.Write(in.Hash)
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, in.Index)
+			prevouts.Write(b)
+			binary.LittleEndian.PutUint32(b, in.Seq)
+			seqs.Write(b)
+		}
+		p.HashPrevouts = hash256(prevouts.Bytes())
+		if base != SigHashSingle && base != SigHashNone {
+			p.HashSequence = hash256(seqs.Bytes())
+		}
+	}
+	var outs []msg.TxOut
+	switch {
+	case base != SigHashSingle && base != SigHashNone:
+		outs = mtx.TxOut
+	case base == SigHashSingle && idx < len(mtx.TxOut):
+		outs = mtx.TxOut[idx : idx+1]
+	}
+	if outs != nil {
+		var buf bytes.Buffer
+		for _, out := range outs {
+			if err := msg.Pack(&buf, out); err != nil {
+				return nil, err
+			}
+		}
+		p.HashOutputs = hash256(buf.Bytes())
+	}
+	var buf bytes.Buffer
+	if err := msg.Pack(&buf, p); err != nil {
+		return nil, err
+	}
+	return hash256(buf.Bytes()), nil
+}
+
+//removeOp returns script without op.
+func removeOp(script []byte, op byte) ([]byte, error) {
+	tokens, err := Parse(script)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]byte, 0, len(script))
+	for _, t := range tokens {
+		if t.Op != op {
+			r = append(r, t.raw...)
+		}
+	}
+	return r, nil
+}
+
+//removeData returns script without pushes of data, same as FindAndDelete in bitcoind.
+func removeData(script []byte, data []byte) ([]byte, error) {
+	tokens, err := Parse(script)
+	if err != nil {
+		return nil, err
+	}
+	push := PushData(data)
+	r := make([]byte, 0, len(script))
+	for _, t := range tokens {
+		if !bytes.Equal(t.raw, push) {
+			r = append(r, t.raw...)
+		}
+	}
+	return r, nil
+}
+
+func hash256(b []byte) []byte {
+	h := sha256.Sum256(b)
+	h = sha256.Sum256(h[:])
+	return h[:]
+}
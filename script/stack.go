@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package script
+
+import "errors"
+
+var errStackEmpty = errors.New("operation on too small stack")
+
+//stack is a stack of the script engine. The last item is the top.
+type stack [][]byte
+
+func (s *stack) push(b []byte) {
+	*s = append(*s, b)
+}
+
+//peek returns the n-th item from the top, which is 0.
+func (s stack) peek(n int) ([]byte, error) {
+	if n < 0 || n >= len(s) {
+		return nil, errStackEmpty
+	}
+	return s[len(s)-1-n], nil
+}
+
+func (s *stack) pop() ([]byte, error) {
+	b, err := s.peek(0)
+	if err != nil {
+		return nil, err
+	}
+	*s = (*s)[:len(*s)-1]
+	return b, nil
+}
+
+//remove removes and returns the n-th item from the top.
+func (s *stack) remove(n int) ([]byte, error) {
+	b, err := s.peek(n)
+	if err != nil {
+		return nil, err
+	}
+	i := len(*s) - 1 - n
+	*s = append((*s)[:i], (*s)[i+1:]...)
+	return b, nil
+}
+
+func (s *stack) popNum(max int) (num, error) {
+	b, err := s.pop()
+	if err != nil {
+		return 0, err
+	}
+	return toNum(b, max)
+}
+
+func (s *stack) popBool() (bool, error) {
+	b, err := s.pop()
+	if err != nil {
+		return false, err
+	}
+	return toBool(b), nil
+}
+
+//dup pushes copies of the n items from the top.
+func (s *stack) dup(n int) error {
+	if n > len(*s) {
+		return errStackEmpty
+	}
+	for _, b := range (*s)[len(*s)-n:] {
+		s.push(b)
+	}
+	return nil
+}
@@ -0,0 +1,939 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/block"
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/tx"
+)
+
+//Error codes of JSON-RPC, which are same as bitcoind.
+const (
+	errInvalidRequest = -32600
+	errMethodNotFound = -32601
+	errInvalidParams  = -32602
+	errParse          = -32700
+	errWallet         = -4
+	errInvalidAddress = -5
+	errFunds          = -6
+	errUnlockNeeded   = -13
+	errPassphrase     = -14
+	errWrongEncState  = -15
+)
+
+//RPCConfig is the configuration of the JSON-RPC server.
+type RPCConfig struct {
+	//Addr is the address to listen, e.g. 127.0.0.1:9402.
+	Addr     string
+	User     string
+	Password string
+	//Broadcast sends a signed transaction to the network.
+	//sendtoaddress and sendmany fail if it is nil.
+	Broadcast func(*msg.Tx) error
+}
+
+type rpcRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     interface{}       `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	Result interface{} `json:"result"`
+	Error  *rpcError   `json:"error"`
+	ID     interface{} `json:"id"`
+}
+
+type rpcHandler func(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error)
+
+var rpcHandlers map[string]rpcHandler
+
+func init() {
+	rpcHandlers = map[string]rpcHandler{
+		"getbalance":       getBalance,
+		"getnewaddress":    getNewAddress,
+		"listunspent":      listUnspent,
+		"sendtoaddress":    sendToAddress,
+		"sendmany":         sendMany,
+		"gettransaction":   getTransaction,
+		"listtransactions": listTransactions,
+		"getblockcount":    getBlockCount,
+		"validateaddress":  validateAddress,
+
+		"importaddress":          importAddress,
+		"importpubkey":           importPubKey,
+		"addmultisigaddress":     addMultisigAddress,
+		"encryptwallet":          encryptWallet,
+		"walletpassphrase":       walletPassphrase,
+		"walletpassphrasechange": walletPassphraseChange,
+		"walletlock":             walletLock,
+		"settxfee":               setTxFee,
+		"abandontransaction":     abandonTransaction,
+		"bumpfee":                bumpFee,
+		"cpfp":                   cpfp,
+	}
+}
+
+//StartRPC starts a JSON-RPC server in goroutine.
+func StartRPC(cfg *RPCConfig) (net.Listener, chan error, error) {
+	if cfg.User == "" || cfg.Password == "" {
+		return nil, nil, errors.New("rpc user and password must be set")
+	}
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	sm := http.NewServeMux()
+	sm.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		serveRPC(cfg, w, r)
+	})
+	s := &http.Server{
+		Addr:           cfg.Addr,
+		Handler:        sm,
+		ReadTimeout:    3 * time.Minute,
+		WriteTimeout:   3 * time.Minute,
+		MaxHeaderBytes: 1 << 20,
+	}
+	ch := make(chan error)
+	go func() {
+		ch <- s.Serve(listener)
+	}()
+	return listener, ch, nil
+}
+
+//authorized returns true if r has the basic auth of cfg.
+func authorized(cfg *RPCConfig, r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	u := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.User))
+	p := subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.Password))
+	return u&p == 1
+}
+
+func serveRPC(cfg *RPCConfig, w http.ResponseWriter, r *http.Request) {
+	if !authorized(cfg, r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC server handles only POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	var req rpcRequest
+	var resp rpcResponse
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		resp.Error = &rpcError{Code: errParse, Message: err.Error()}
+	} else {
+		resp.ID = req.ID
+		resp.Result, resp.Error = call(cfg, &req)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Error != nil {
+		w.WriteHeader(httpStatus(resp.Error.Code))
+	}
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		log.Print(err)
+	}
+}
+
+//httpStatus returns the http status for the error code like bitcoind.
+func httpStatus(code int) int {
+	switch code {
+	case errInvalidRequest:
+		return http.StatusBadRequest
+	case errMethodNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func call(cfg *RPCConfig, req *rpcRequest) (interface{}, *rpcError) {
+	if req.Method == "" {
+		return nil, &rpcError{Code: errInvalidRequest, Message: "method not specified"}
+	}
+	h, exist := rpcHandlers[req.Method]
+	if !exist {
+		return nil, &rpcError{Code: errMethodNotFound, Message: "Method not found"}
+	}
+	log.Println("rpc", req.Method)
+	result, err := h(cfg, req.Params)
+	if err == nil {
+		return result, nil
+	}
+	if re, ok := err.(*rpcError); ok {
+		return nil, re
+	}
+	return nil, &rpcError{Code: errWallet, Message: err.Error()}
+}
+
+//param unmarshals i-th param to v if exists.
+//It returns false if the param doesn't exist.
+func param(ps []json.RawMessage, i int, v interface{}) (bool, error) {
+	if len(ps) <= i || string(ps[i]) == "null" {
+		return false, nil
+	}
+	if err := json.Unmarshal(ps[i], v); err != nil {
+		return false, &rpcError{
+			Code:    errInvalidParams,
+			Message: fmt.Sprintf("invalid parameter %d: %s", i+1, err),
+		}
+	}
+	return true, nil
+}
+
+//toAmount converts amount in MONA to base unit.
+func toAmount(f float64) (uint64, error) {
+	if f <= 0 || math.IsInf(f, 0) || math.IsNaN(f) || f > math.MaxInt64/params.Unit {
+		return 0, &rpcError{Code: errInvalidParams, Message: "Invalid amount"}
+	}
+	return uint64(math.Round(f * params.Unit)), nil
+}
+
+//fromAmount converts amount in base unit to MONA.
+func fromAmount(a uint64) float64 {
+	return float64(a) / params.Unit
+}
+
+//height returns the height of the tip of the main chain.
+func height() uint64 {
+	return block.Tip().Height
+}
+
+//confirmations returns the number of confirmations of blockhash.
+func confirmations(blockhash []byte) uint64 {
+	return block.Confirmations(blockhash)
+}
+
+func getBalance(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	minconf := uint64(1)
+	watchonly := false
+	if _, err := param(ps, 1, &minconf); err != nil {
+		return nil, err
+	}
+	if _, err := param(ps, 2, &watchonly); err != nil {
+		return nil, err
+	}
+	var total uint64
+	for _, c := range tx.SortedCoins() {
+		if (c.WatchOnly() && !watchonly) || c.Locked() {
+			continue
+		}
+		if confirmations(c.Block) >= minconf {
+			total += c.Value
+		}
+	}
+	return fromAmount(total), nil
+}
+
+func getNewAddress(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var typ string
+	if _, err := param(ps, 1, &typ); err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "", key.AddressLegacy, key.AddressP2SHSegwit, key.AddressBech32:
+	default:
+		return nil, &rpcError{Code: errInvalidAddress, Message: "Unknown address type '" + typ + "'"}
+	}
+	pub, err := key.New()
+	if err == key.ErrLocked {
+		return nil, &rpcError{Code: errUnlockNeeded, Message: "Error: Please enter the wallet passphrase with walletpassphrase first."}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pub.AddressOf(typ)
+}
+
+type unspent struct {
+	TxID          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	Address       string  `json:"address"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Amount        float64 `json:"amount"`
+	Confirmations uint64  `json:"confirmations"`
+	Spendable     bool    `json:"spendable"`
+	RedeemScript  string  `json:"redeemScript,omitempty"`
+}
+
+func listUnspent(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	minconf := uint64(1)
+	maxconf := uint64(9999999)
+	var addrs []string
+	if _, err := param(ps, 0, &minconf); err != nil {
+		return nil, err
+	}
+	if _, err := param(ps, 1, &maxconf); err != nil {
+		return nil, err
+	}
+	if _, err := param(ps, 2, &addrs); err != nil {
+		return nil, err
+	}
+	filter := make(map[string]struct{})
+	for _, a := range addrs {
+		filter[a] = struct{}{}
+	}
+	r := []*unspent{}
+	for _, c := range tx.SortedCoins() {
+		conf := confirmations(c.Block)
+		if conf < minconf || conf > maxconf || c.Locked() {
+			continue
+		}
+		adr := c.Address()
+		if _, ok := filter[adr]; len(filter) > 0 && !ok {
+			continue
+		}
+		r = append(r, &unspent{
+			TxID:          behex.EncodeToString(c.TxHash),
+			Vout:          c.TxIndex,
+			Address:       adr,
+			ScriptPubKey:  hex.EncodeToString(c.Script),
+			Amount:        fromAmount(c.Value),
+			Confirmations: conf,
+			Spendable:     !c.WatchOnly(),
+			RedeemScript:  hex.EncodeToString(c.RedeemScript()),
+		})
+	}
+	return r, nil
+}
+
+//sendOptions returns options of sendtoaddress and sendmany.
+//replaceable at index 5 is used like bitcoind. conf_target and estimate_mode
+//at index 6 and 7 are accepted but ignored, because the fee rate is set by settxfee.
+//The object of wallet options at index 8, past the params of bitcoind, has
+//"selection", the name of the coin selection strategy.
+//verbose is true if the wallet options are given.
+func sendOptions(ps []json.RawMessage) (*tx.Options, bool, error) {
+	opt := &tx.Options{}
+	if _, err := param(ps, 5, &opt.Replaceable); err != nil {
+		return nil, false, err
+	}
+	var confTarget int
+	if _, err := param(ps, 6, &confTarget); err != nil {
+		return nil, false, err
+	}
+	var estimateMode string
+	if _, err := param(ps, 7, &estimateMode); err != nil {
+		return nil, false, err
+	}
+	var wopt struct {
+		Selection string `json:"selection"`
+	}
+	verbose, err := param(ps, 8, &wopt)
+	if err != nil {
+		return nil, false, err
+	}
+	if wopt.Selection != "" {
+		sel, err := tx.SelectorByName(wopt.Selection)
+		if err != nil {
+			return nil, false, &rpcError{Code: errInvalidParams, Message: err.Error()}
+		}
+		opt.Selector = sel
+	}
+	return opt, verbose, nil
+}
+
+type sent struct {
+	TxID     string  `json:"txid"`
+	Fee      float64 `json:"fee"`
+	Selector string  `json:"selector"`
+}
+
+//send creates a transaction from sends and broadcasts it.
+//It returns the txid, or the txid with the fee and the coin selection strategy
+//if verbose.
+func send(cfg *RPCConfig, opt *tx.Options, verbose bool, sends ...*tx.Send) (interface{}, error) {
+	if cfg.Broadcast == nil {
+		return nil, errors.New("broadcasting transactions is not available")
+	}
+	r, err := tx.Create(opt, sends...)
+	if err == key.ErrLocked {
+		return nil, &rpcError{Code: errUnlockNeeded, Message: "Error: Please enter the wallet passphrase with walletpassphrase first."}
+	}
+	if err != nil {
+		return nil, &rpcError{Code: errFunds, Message: err.Error()}
+	}
+	mtx := r.Tx
+	if err := cfg.Broadcast(mtx); err != nil {
+		return nil, err
+	}
+	if err := r.Commit(); err != nil {
+		return nil, err
+	}
+	if verbose {
+		return &sent{
+			TxID:     behex.EncodeToString(mtx.Hash()),
+			Fee:      fromAmount(r.Fee),
+			Selector: r.Selector,
+		}, nil
+	}
+	return behex.EncodeToString(mtx.Hash()), nil
+}
+
+func sendToAddress(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var adr string
+	var amount float64
+	ok, err := param(ps, 0, &adr)
+	if err != nil {
+		return nil, err
+	}
+	ok2, err := param(ps, 1, &amount)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || !ok2 {
+		return nil, &rpcError{Code: errInvalidParams, Message: "address and amount are required"}
+	}
+	if !validAddress(adr) {
+		return nil, &rpcError{Code: errInvalidAddress, Message: "Invalid Monacoin address"}
+	}
+	a, err := toAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	opt, verbose, err := sendOptions(ps)
+	if err != nil {
+		return nil, err
+	}
+	return send(cfg, opt, verbose, &tx.Send{Addr: adr, Amount: a})
+}
+
+func sendMany(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var amounts map[string]float64
+	ok, err := param(ps, 1, &amounts)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || len(amounts) == 0 {
+		return nil, &rpcError{Code: errInvalidParams, Message: "amounts are required"}
+	}
+	sends := make([]*tx.Send, 0, len(amounts))
+	for adr, amount := range amounts {
+		if !validAddress(adr) {
+			return nil, &rpcError{Code: errInvalidAddress, Message: "Invalid Monacoin address: " + adr}
+		}
+		a, err := toAmount(amount)
+		if err != nil {
+			return nil, err
+		}
+		sends = append(sends, &tx.Send{Addr: adr, Amount: a})
+	}
+	sort.Slice(sends, func(i, j int) bool { return sends[i].Addr < sends[j].Addr })
+	opt, verbose, err := sendOptions(ps)
+	if err != nil {
+		return nil, err
+	}
+	return send(cfg, opt, verbose, sends...)
+}
+
+type transaction struct {
+	TxID          string   `json:"txid"`
+	Category      string   `json:"category"`
+	Amount        float64  `json:"amount"`
+	Fee           float64  `json:"fee,omitempty"`
+	Addresses     []string `json:"addresses"`
+	Confirmations uint64   `json:"confirmations"`
+	BlockHash     string   `json:"blockhash,omitempty"`
+	BlockHeight   uint64   `json:"blockheight"`
+	BlockTime     int64    `json:"blocktime,omitempty"`
+	Time          int64    `json:"time"`
+	Replaces      string   `json:"replaces_txid,omitempty"`
+}
+
+func newTransaction(h *tx.History) *transaction {
+	t := &transaction{
+		TxID:          behex.EncodeToString(h.TxID),
+		Category:      h.Direction.String(),
+		Amount:        float64(h.Amount) / params.Unit,
+		Addresses:     h.Addresses,
+		Confirmations: confirmations(h.Block),
+		BlockHeight:   h.Height,
+		Time:          h.Time,
+	}
+	if h.Fee > 0 {
+		t.Fee = -fromAmount(h.Fee)
+	}
+	if h.Replaces != nil {
+		t.Replaces = behex.EncodeToString(h.Replaces)
+	}
+	//the block of an unconfirmed tx is nil or zero.
+	if len(h.Block) > 0 && !bytes.Equal(h.Block, make([]byte, len(h.Block))) {
+		t.BlockHash = behex.EncodeToString(h.Block)
+	}
+	if hdr, err := block.Header(h.Block); err == nil {
+		t.BlockTime = int64(hdr.Timestamp)
+	}
+	return t
+}
+
+func getTransaction(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var txid string
+	ok, err := param(ps, 0, &txid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &rpcError{Code: errInvalidParams, Message: "txid is required"}
+	}
+	hash, err := behex.DecodeString(txid)
+	if err != nil {
+		return nil, &rpcError{Code: errInvalidParams, Message: "invalid txid"}
+	}
+	h, err := tx.GetHistory(hash)
+	if err != nil {
+		return nil, &rpcError{Code: errInvalidAddress, Message: "Invalid or non-wallet transaction id"}
+	}
+	return newTransaction(h), nil
+}
+
+//listTransactions returns most recent transactions like bitcoind.
+//Unlike bitcoind, the first param is an address to filter
+//transactions instead of an account.
+func listTransactions(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var adr string
+	count := 10
+	from := 0
+	if _, err := param(ps, 0, &adr); err != nil {
+		return nil, err
+	}
+	if _, err := param(ps, 1, &count); err != nil {
+		return nil, err
+	}
+	if _, err := param(ps, 2, &from); err != nil {
+		return nil, err
+	}
+	if count < 0 || from < 0 {
+		return nil, &rpcError{Code: errInvalidParams, Message: "Negative count or from"}
+	}
+	if adr == "*" {
+		adr = ""
+	}
+	hs, err := tx.Histories(&tx.Query{Address: adr})
+	if err != nil {
+		return nil, err
+	}
+	//skip the most recent from txs and return count txs in old-to-new order.
+	end := len(hs) - from
+	if end < 0 {
+		end = 0
+	}
+	start := end - count
+	if start < 0 {
+		start = 0
+	}
+	r := make([]*transaction, 0, end-start)
+	for _, h := range hs[start:end] {
+		r = append(r, newTransaction(h))
+	}
+	return r, nil
+}
+
+func getBlockCount(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	return height(), nil
+}
+
+//validAddress returns true if adr is a valid address in the current network.
+func validAddress(adr string) bool {
+	_, err := key.ParseAddress(adr)
+	return err == nil
+}
+
+type addressInfo struct {
+	IsValid        bool   `json:"isvalid"`
+	Address        string `json:"address,omitempty"`
+	ScriptPubKey   string `json:"scriptPubKey,omitempty"`
+	IsMine         bool   `json:"ismine"`
+	IsWatchOnly    bool   `json:"iswatchonly"`
+	IsScript       bool   `json:"isscript"`
+	IsWitness      bool   `json:"iswitness"`
+	WitnessVersion *int   `json:"witness_version,omitempty"`
+	WitnessProgram string `json:"witness_program,omitempty"`
+}
+
+func validateAddress(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var adr string
+	ok, err := param(ps, 0, &adr)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &rpcError{Code: errInvalidParams, Message: "address is required"}
+	}
+	a, err := key.ParseAddress(adr)
+	if err != nil {
+		return &addressInfo{}, nil
+	}
+	info := &addressInfo{
+		IsValid:      true,
+		Address:      a.String(),
+		ScriptPubKey: hex.EncodeToString(a.Script()),
+		IsScript:     a.Type == key.AddrP2SH || a.Type == key.AddrP2WSH,
+	}
+	switch a.Type {
+	case key.AddrP2WPKH, key.AddrP2WSH, key.AddrWitness:
+		info.IsWitness = true
+		info.WitnessVersion = &a.Version
+		info.WitnessProgram = hex.EncodeToString(a.Hash)
+	}
+	if a.Type == key.AddrP2PKH || a.Type == key.AddrP2WPKH {
+		if pub, err := key.FromPubHash(a.Hash); err == nil {
+			info.IsWatchOnly = key.IsWatchOnly(pub)
+			info.IsMine = !info.IsWatchOnly
+		} else {
+			info.IsWatchOnly = key.IsWatchedHash(a.Hash)
+		}
+	}
+	return info, nil
+}
+
+func importAddress(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var adr string
+	if _, err := param(ps, 0, &adr); err != nil {
+		return nil, err
+	}
+	if err := key.ImportAddress(adr); err != nil {
+		return nil, &rpcError{Code: errInvalidAddress, Message: err.Error()}
+	}
+	return nil, nil
+}
+
+func importPubKey(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var h string
+	if _, err := param(ps, 0, &h); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, &rpcError{Code: errInvalidAddress, Message: "Pubkey must be a hex string"}
+	}
+	pub, err := key.NewPublicKey(b)
+	if err != nil {
+		return nil, &rpcError{Code: errInvalidAddress, Message: "Pubkey is not a valid public key"}
+	}
+	return nil, key.ImportPubKey(pub)
+}
+
+func addMultisigAddress(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var m int
+	var keys []string
+	if _, err := param(ps, 0, &m); err != nil {
+		return nil, err
+	}
+	if _, err := param(ps, 1, &keys); err != nil {
+		return nil, err
+	}
+	pubs := make([]*key.PublicKey, len(keys))
+	for i, k := range keys {
+		var err error
+		if pubs[i], err = pubKey(k); err != nil {
+			return nil, &rpcError{Code: errInvalidAddress, Message: err.Error()}
+		}
+	}
+	if m < 1 || m > len(pubs) {
+		return nil, &rpcError{Code: errInvalidParams, Message: "illegal number of required signatures"}
+	}
+	adr, _, err := tx.AddMultisig(byte(m), pubs)
+	if err != nil {
+		return nil, &rpcError{Code: errWallet, Message: err.Error()}
+	}
+	return adr, nil
+}
+
+//pubKey returns the public key of hex string or of an address in the wallet.
+func pubKey(k string) (*key.PublicKey, error) {
+	if b, err := hex.DecodeString(k); err == nil {
+		return key.NewPublicKey(b)
+	}
+	a, err := key.ParseAddress(k)
+	if err != nil || (a.Type != key.AddrP2PKH && a.Type != key.AddrP2WPKH) {
+		return nil, fmt.Errorf("invalid public key or address %s", k)
+	}
+	return key.FromPubHash(a.Hash)
+}
+
+//walletError converts errors about encryption of the wallet to rpcError.
+func walletError(err error) error {
+	switch err {
+	case key.ErrPassphrase:
+		return &rpcError{Code: errPassphrase, Message: "Error: The wallet passphrase entered was incorrect."}
+	case key.ErrNotEncrypted:
+		return &rpcError{Code: errWrongEncState, Message: "Error: running with an unencrypted wallet."}
+	}
+	return err
+}
+
+func encryptWallet(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var pass string
+	if _, err := param(ps, 0, &pass); err != nil {
+		return nil, err
+	}
+	if pass == "" {
+		return nil, &rpcError{Code: errInvalidParams, Message: "passphrase can not be empty"}
+	}
+	if key.IsEncrypted() {
+		return nil, &rpcError{Code: errWrongEncState, Message: "Error: running with an encrypted wallet, but encryptwallet was called."}
+	}
+	if err := key.Encrypt(pass); err != nil {
+		return nil, err
+	}
+	return "wallet encrypted; the keypool has been flushed.", nil
+}
+
+func walletPassphrase(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var pass string
+	var timeout int64
+	if _, err := param(ps, 0, &pass); err != nil {
+		return nil, err
+	}
+	ok, err := param(ps, 1, &timeout)
+	if err != nil {
+		return nil, err
+	}
+	if pass == "" || !ok || timeout <= 0 {
+		return nil, &rpcError{Code: errInvalidParams, Message: "passphrase and positive timeout are required"}
+	}
+	return nil, walletError(key.Unlock(pass, time.Duration(timeout)*time.Second))
+}
+
+func walletPassphraseChange(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var oldpass, newpass string
+	if _, err := param(ps, 0, &oldpass); err != nil {
+		return nil, err
+	}
+	if _, err := param(ps, 1, &newpass); err != nil {
+		return nil, err
+	}
+	if oldpass == "" || newpass == "" {
+		return nil, &rpcError{Code: errInvalidParams, Message: "old and new passphrases are required"}
+	}
+	return nil, walletError(key.ChangePassphrase(oldpass, newpass))
+}
+
+func walletLock(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	if !key.IsEncrypted() {
+		return nil, &rpcError{Code: errWrongEncState, Message: "Error: running with an unencrypted wallet, but walletlock was called."}
+	}
+	key.Lock()
+	return nil, nil
+}
+
+//setTxFee sets the fee rate per kB. 0 resets it to the default.
+func setTxFee(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var amount float64
+	ok, err := param(ps, 0, &amount)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &rpcError{Code: errInvalidParams, Message: "amount is required"}
+	}
+	rate := params.Fee
+	if amount != 0 {
+		if rate, err = toAmount(amount); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.SetFeeRate(rate); err != nil {
+		return nil, &rpcError{Code: errInvalidParams, Message: err.Error()}
+	}
+	return true, nil
+}
+
+func abandonTransaction(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	var txid string
+	ok, err := param(ps, 0, &txid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &rpcError{Code: errInvalidParams, Message: "txid is required"}
+	}
+	h, err := behex.DecodeString(txid)
+	if err != nil || len(h) != 32 {
+		return nil, &rpcError{Code: errInvalidParams, Message: "invalid txid"}
+	}
+	if err := tx.Abandon(h); err != nil {
+		return nil, &rpcError{Code: errInvalidAddress, Message: err.Error()}
+	}
+	return nil, nil
+}
+
+type bumped struct {
+	TxID    string   `json:"txid"`
+	OrigFee float64  `json:"origfee"`
+	Fee     float64  `json:"fee"`
+	Errors  []string `json:"errors"`
+}
+
+//bumpFee replaces a pending transaction with one paying more fee and broadcasts it.
+//The feeRate option is in MONA/kB like bitcoind; the wallet fee rate is used if omitted.
+func bumpFee(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	if cfg.Broadcast == nil {
+		return nil, errors.New("broadcasting transactions is not available")
+	}
+	var txid string
+	ok, err := param(ps, 0, &txid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &rpcError{Code: errInvalidParams, Message: "txid is required"}
+	}
+	h, err := behex.DecodeString(txid)
+	if err != nil || len(h) != 32 {
+		return nil, &rpcError{Code: errInvalidParams, Message: "invalid txid"}
+	}
+	var opt struct {
+		FeeRate float64 `json:"feeRate"`
+	}
+	if _, err = param(ps, 1, &opt); err != nil {
+		return nil, err
+	}
+	rate := tx.FeeRate()
+	if opt.FeeRate != 0 {
+		if rate, err = toAmount(opt.FeeRate); err != nil {
+			return nil, err
+		}
+	}
+	b, err := tx.BumpFee(h, rate)
+	if err == key.ErrLocked {
+		return nil, &rpcError{Code: errUnlockNeeded, Message: "Error: Please enter the wallet passphrase with walletpassphrase first."}
+	}
+	if _, ok := err.(*tx.ShortageError); ok {
+		return nil, &rpcError{Code: errFunds, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, &rpcError{Code: errWallet, Message: err.Error()}
+	}
+	r := &bumped{
+		TxID:    behex.EncodeToString(b.Tx.Hash()),
+		OrigFee: fromAmount(b.OrigFee),
+		Fee:     fromAmount(b.Fee),
+		Errors:  []string{},
+	}
+	if err := cfg.Broadcast(b.Tx); err != nil {
+		//the original tx stays pending.
+		return nil, err
+	}
+	if err := b.Commit(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type child struct {
+	TxID string  `json:"txid"`
+	Fee  float64 `json:"fee"`
+}
+
+//cpfp spends an output of an unconfirmed parent, given as a txid of a pending
+//transaction or a raw transaction in hex, to accelerate the parent and
+//broadcasts the child. Options are feeRate in MONA/kB for the package and replaceable.
+func cpfp(cfg *RPCConfig, ps []json.RawMessage) (interface{}, error) {
+	if cfg.Broadcast == nil {
+		return nil, errors.New("broadcasting transactions is not available")
+	}
+	var parent string
+	var vout uint32
+	ok, err := param(ps, 0, &parent)
+	if err != nil {
+		return nil, err
+	}
+	ok2, err := param(ps, 1, &vout)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || !ok2 {
+		return nil, &rpcError{Code: errInvalidParams, Message: "parent and vout are required"}
+	}
+	ptx, err := tx.ParentTx(parent)
+	if err != nil {
+		return nil, &rpcError{Code: errInvalidParams, Message: "invalid parent: " + err.Error()}
+	}
+	var opt struct {
+		FeeRate     float64 `json:"feeRate"`
+		Replaceable bool    `json:"replaceable"`
+	}
+	if _, err = param(ps, 2, &opt); err != nil {
+		return nil, err
+	}
+	rate := tx.FeeRate()
+	if opt.FeeRate != 0 {
+		if rate, err = toAmount(opt.FeeRate); err != nil {
+			return nil, err
+		}
+	}
+	r, err := tx.CPFP(ptx, vout, rate, &tx.Options{Replaceable: opt.Replaceable})
+	if err == key.ErrLocked {
+		return nil, &rpcError{Code: errUnlockNeeded, Message: "Error: Please enter the wallet passphrase with walletpassphrase first."}
+	}
+	if err != nil {
+		return nil, &rpcError{Code: errFunds, Message: err.Error()}
+	}
+	if err := cfg.Broadcast(r.Tx); err != nil {
+		return nil, err
+	}
+	if err := r.Commit(); err != nil {
+		return nil, err
+	}
+	return &child{
+		TxID: behex.EncodeToString(r.Tx.Hash()),
+		Fee:  fromAmount(r.Fee),
+	}, nil
+}
@@ -37,17 +37,32 @@ import (
 	"sync"
 
 	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/base58check"
 	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/block"
 	"github.com/monarj/wallet/db"
 	"github.com/monarj/wallet/key"
 	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
+	"golang.org/x/crypto/ripemd160"
 )
 
 var (
 	notifyTX = make(map[string]chan *msg.Tx)
 	mutex    sync.RWMutex
+	wdb      *db.DB
 )
 
+func init() {
+	block.OnDisconnect(disconnect)
+}
+
+//Init sets the database used in this package.
+func Init(d *db.DB) {
+	wdb = d
+}
+
 //AddNotify adds pubscript to be notified.
 func AddNotify(pubscr []byte) chan *msg.Tx {
 	ch := make(chan *msg.Tx)
@@ -67,7 +82,7 @@ func (c Coins) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
 //GetCoins get coin list.
 func GetCoins(pub *key.PublicKey) (Coins, error) {
 	var coins Coins
-	err := db.DB.Batch(func(tx *bolt.Tx) error {
+	err := wdb.Batch(func(tx *bolt.Tx) error {
 		var errr error
 		coins, errr = getCoins(tx, pub)
 		return errr
@@ -102,7 +117,7 @@ func getCoins(tx *bolt.Tx, pub *key.PublicKey) (Coins, error) {
 //SortedCoins returns value-sorted coins that cointans all address.
 func SortedCoins() Coins {
 	var coins Coins
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	err := wdb.View(func(tx *bolt.Tx) error {
 		var errr error
 		coins, errr = getCoins(tx, nil)
 		return errr
@@ -114,31 +129,58 @@ func SortedCoins() Coins {
 	return coins
 }
 
-func (c *Coin) save() error {
-	spent := false
-	err := db.DB.Batch(func(tx *bolt.Tx) error {
-		if db.HasKey(tx, "spend", c.TxHash) {
-			spent = true
-			return db.Del(tx, "spend", c.TxHash)
+//getCoin returns the coin of the txout, or nil if it is not in the wallet.
+func getCoin(hash []byte, index uint32) (*Coin, error) {
+	var c *Coin
+	err := wdb.View(func(tx *bolt.Tx) error {
+		v, err := db.Get(tx, "coin", db.ToKey(hash, index), nil)
+		if err != nil {
+			return nil
 		}
-		return nil
+		c = &Coin{}
+		return msg.Unpack(bytes.NewBuffer(v), c)
 	})
-	if err != nil {
-		return err
-	}
-	if spent {
-		return nil
+	return c, err
+}
+
+//txOut returns the txout of the coin.
+func (c *Coin) txOut() *msg.TxOut {
+	return &msg.TxOut{
+		Value:  c.Value,
+		Script: c.Script,
 	}
+}
+
+func (c *Coin) save() error {
+	return wdb.Batch(c.put)
+}
 
-	dat := bytes.Buffer{}
-	if err := msg.Pack(&dat, *c); err != nil {
+//put stores the coin in the db tx.
+func (c *Coin) put(tx *bolt.Tx) error {
+	var buf bytes.Buffer
+	if err := msg.Pack(&buf, *c); err != nil {
 		return err
 	}
+	dat := buf.Bytes()
 	k := db.ToKey(c.TxHash, c.TxIndex)
-	return db.Batch("coin", k, dat.Bytes())
+	if v, err := db.Get(tx, "spent", k, nil); err == nil {
+		if v[32] == 1 {
+			return nil
+		}
+		//the tx spending this coin came before this coin.
+		spender := make([]byte, 32)
+		copy(spender, v)
+		if err := db.Put(tx, "spent", k, spentValue(spender, dat)); err != nil {
+			return err
+		}
+		return addSpent(tx, spender, c.Value)
+	}
+	return db.Put(tx, "coin", k, dat)
 }
 
 //Coin represents an available transaction.
+//Ttype is the type of Script, and Pubkey is the redeem script if it is P2SH,
+//or the pubkey hash if it is watched by address.
 type Coin struct {
 	Pubkey   []byte `len:"prev"`
 	TxHash   []byte `len:"32"`
@@ -150,9 +192,76 @@ type Coin struct {
 	Ttype    byte
 }
 
+//confirmed returns true if the block of the coin is confirmed in the main chain.
+func (c *Coin) confirmed() bool {
+	b, err := block.LoadBlock(c.Block)
+	return err == nil && block.Confirmed(b)
+}
+
+//WatchOnly returns true if the wallet cannot sign for the coin.
+func (c *Coin) WatchOnly() bool {
+	if c.Ttype == ttypeP2SH {
+		return !canSign(c.Pubkey)
+	}
+	if len(c.Pubkey) == ripemd160.Size {
+		return true
+	}
+	pub, err := key.NewPublicKey(c.Pubkey)
+	if err != nil {
+		return true
+	}
+	return key.IsWatchOnly(pub)
+}
+
+//RedeemScript returns the redeem script if the coin is P2SH, or nil.
+func (c *Coin) RedeemScript() []byte {
+	switch c.Ttype {
+	case ttypeP2SH:
+		return c.Pubkey
+	case ttypeP2SHP2WPKH:
+		return key.WitnessProgram(c.pubHash())
+	}
+	return nil
+}
+
+//isWitness returns true if the coin is spent with witness.
+func (c *Coin) isWitness() bool {
+	return c.Ttype == ttypeP2WPKH || c.Ttype == ttypeP2SHP2WPKH
+}
+
+//pubHash returns the pubkey hash of the coin if it is not P2SH.
+func (c *Coin) pubHash() []byte {
+	if len(c.Pubkey) == ripemd160.Size {
+		return c.Pubkey
+	}
+	return key.Hash160(c.Pubkey)
+}
+
+//Address returns the address which owns the coin.
+func (c *Coin) Address() string {
+	switch c.Ttype {
+	case ttypeP2SH:
+		return base58check.Encode(params.Net.P2SHHeader, key.Hash160(c.Pubkey))
+	case ttypeP2WPKH:
+		return key.WitnessAddress(c.pubHash())
+	case ttypeP2SHP2WPKH:
+		adr, _ := key.P2SHWitnessAddress(c.pubHash())
+		return adr
+	}
+	if len(c.Pubkey) == ripemd160.Size {
+		return base58check.Encode(params.Net.AddressHeader, c.Pubkey)
+	}
+	pub, err := key.NewPublicKey(c.Pubkey)
+	if err != nil {
+		return ""
+	}
+	adr, _ := pub.Address()
+	return adr
+}
+
 //RemoveKey removes coins associated with pub.
 func RemoveKey(pub *key.PublicKey) error {
-	return db.DB.Batch(func(tx *bolt.Tx) error {
+	return wdb.Batch(func(tx *bolt.Tx) error {
 		coin, err := getCoins(tx, pub)
 		if err != nil {
 			return err
@@ -166,130 +275,154 @@ func RemoveKey(pub *key.PublicKey) error {
 	})
 }
 
-//remove removes one tx.
-func remove(hash []byte, index uint32) error {
-	return db.DB.Batch(func(tx *bolt.Tx) error {
-		coin, err := getCoins(tx, nil)
-		if err != nil {
-			return err
-		}
-		for _, c := range coin {
-			if bytes.Equal(c.TxHash, hash) && c.TxIndex == index {
-				return db.Del(tx, "coin", db.ToKey(c.TxHash, c.TxIndex))
-			}
-		}
-		return db.Put(tx, "spent", db.ToKey(hash, index), hash)
-	})
-}
-
-//ScriptSigH is the header of scriptsig this program supports.
-type ScriptSigH struct {
-	SigLength byte
-	Prefix30  byte
-	RSLength  byte
-	PrefixR02 byte
-	RLength   byte
-	R         []byte `len:"var"`
-	PrefixL02 byte
-	SLength   byte
-	S         []byte `len:"var"`
-}
-
-//ScriptSigT is the tail of  scriptsig this program supports.
-type ScriptSigT struct {
-	Postfix01 byte
-	Length    byte
-	Pubkey    []byte `len:"var"`
-}
-
-//Script the default out scrip this program supports.
-type Script struct {
-	Dup         byte
-	Hash160     byte
-	HashLength  byte
-	PubHash     []byte `len:"20"`
-	EqualVerify byte
-	CheckSig    byte
-}
-
-//Script2 is P2SH script.
-type Script2 struct {
-	Length   byte
-	Pubkey   []byte `len:"var"`
-	CheckSig byte
+//spentValue returns a value in "spent" bucket, which is spender txid,
+//a flag whether the value of the coin is added to the history of spender,
+//and the packed coin if the flag is on.
+func spentValue(spender []byte, coin []byte) []byte {
+	v := make([]byte, 33, 33+len(coin))
+	copy(v, spender)
+	if coin != nil {
+		v[32] = 1
+		v = append(v, coin...)
+	}
+	return v
 }
 
-func parse(s interface{}, data []byte) error {
-	buf := bytes.NewBuffer(data)
-	if err := msg.Unpack(buf, s); err != nil {
-		return fmt.Errorf("this tx is not supported(unknown format) %s", err)
+//remove removes one coin which is spent by spender,
+//and adds its value to the history of spender.
+//The coin is marked as spent in the "spent" bucket, so that the coin
+//will not be saved again even if it comes after the spender,
+//and can be restored if the spender is disconnected from the chain.
+func remove(tx *bolt.Tx, hash []byte, index uint32, spender []byte) error {
+	k := db.ToKey(hash, index)
+	if db.HasKey(tx, "spent", k) {
+		return nil
 	}
-	if buf.Len() != 0 {
-		return fmt.Errorf("this tx is not supported")
+	v, err := db.Get(tx, "coin", k, nil)
+	if err != nil {
+		return db.Put(tx, "spent", k, spentValue(spender, nil))
 	}
-	return nil
+	c := &Coin{}
+	if err := msg.Unpack(bytes.NewBuffer(v), c); err != nil {
+		return err
+	}
+	if err := db.Put(tx, "spent", k, spentValue(spender, v)); err != nil {
+		return err
+	}
+	if err := db.Del(tx, "coin", k); err != nil {
+		return err
+	}
+	return addSpent(tx, spender, c.Value)
 }
 
-func parseScriptsigHT(data []byte) (*ScriptSigT, error) {
-	s := ScriptSigH{}
-	buf := bytes.NewBuffer(data)
-	if err := msg.Unpack(buf, &s); err != nil {
-		return nil, errors.New("this tx is not supported(unknown format)")
+//disconnect removes coins and histories of txs in block b
+//which is disconnected from the main chain, and restores coins
+//spent by the txs.
+func disconnect(tx *bolt.Tx, b *block.Block) error {
+	txids := make(map[string]struct{})
+	if bucket := tx.Bucket([]byte("txhistory")); bucket != nil {
+		err := bucket.ForEach(func(k, v []byte) error {
+			h := &History{}
+			if err := db.B2v(v, h); err != nil {
+				return err
+			}
+			if bytes.Equal(h.Block, b.Hash) {
+				txids[string(k)] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
-	if buf.Len() == 0 {
-		return nil, errors.New("old type of scriptsig, ignoring")
+	type kv struct{ k, v []byte }
+	var spent []kv
+	if bucket := tx.Bucket([]byte("spent")); bucket != nil {
+		err := bucket.ForEach(func(k, v []byte) error {
+			if _, ok := txids[string(v[:32])]; ok {
+				spent = append(spent, kv{
+					k: append([]byte{}, k...),
+					v: append([]byte{}, v...),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
-	switch {
-	case s.Prefix30 != 0x30:
-		fallthrough
-	case s.PrefixR02 != 0x02:
-		fallthrough
-	case s.PrefixL02 != 0x02:
-		return nil, errors.New("unsuported scriptsig")
+	for _, s := range spent {
+		if err := db.Del(tx, "spent", s.k); err != nil {
+			return err
+		}
+		if s.v[32] == 1 {
+			if err := db.Put(tx, "coin", s.k, s.v[33:]); err != nil {
+				return err
+			}
+		}
 	}
-
-	st := ScriptSigT{}
-	if err := msg.Unpack(buf, &st); err != nil {
-		return nil, errors.New("this tx is not supported(unknown format)")
+	for txid := range txids {
+		if err := db.Del(tx, "txhistory", []byte(txid)); err != nil {
+			return err
+		}
 	}
-	if buf.Len() != 0 {
-		return nil, fmt.Errorf("this tx is not supported")
+	coins, err := getCoins(tx, nil)
+	if err != nil {
+		return err
 	}
-	return &st, nil
+	for _, c := range coins {
+		if !bytes.Equal(c.Block, b.Hash) {
+			continue
+		}
+		if err := db.Del(tx, "coin", db.ToKey(c.TxHash, c.TxIndex)); err != nil {
+			return err
+		}
+	}
+	if len(txids) > 0 {
+		log.Println(len(txids), "txs are disconnected from the chain")
+	}
+	return nil
 }
 
-//Add adds or removes transanctions from a tx packet.
+//Add adds or removes transanctions from a tx packet,
+//and records the tx to the history if it concerns the wallet.
 func Add(mtx *msg.Tx, hash []byte) error {
 	coinbase := false
 	zero := make([]byte, 32)
-	for _, in := range mtx.TxIn {
+	txid := mtx.Hash()
+	inBlock := !bytes.Equal(hash, zero)
+	var own []msg.TxIn
+	var senders []string
+	for i, in := range mtx.TxIn {
 		if bytes.Equal(in.Hash, zero) && in.Index == 0xffffffff {
 			log.Println("coinbase")
 			coinbase = true
 			break
 		}
-		s, err := parseScriptsigHT(in.Script)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		if _, err = checkTxin(s); err != nil {
+		if adr, err := checkTxin(mtx, i, inBlock); err != nil {
 			log.Println(err)
+			if adr != "" {
+				senders = append(senders, adr)
+			}
 			continue
 		}
-		if err := remove(in.Hash, in.Index); err != nil {
-			log.Println(err)
-		}
+		own = append(own, in)
 	}
+	var received, total uint64
+	var recipients []string
 	for i, in := range mtx.TxOut {
-		pubkey, ttype, err := parseTXout(in.Script)
+		total += in.Value
+		owner, ttype, err := parseTXout(in.Script)
 		if err != nil {
-			log.Println(err, behex.EncodeToString(mtx.Hash()))
+			log.Println(err, behex.EncodeToString(txid))
+			if adr := scriptAddress(in.Script); adr != "" {
+				recipients = append(recipients, adr)
+			}
 			continue
 		}
 		c := &Coin{
-			Pubkey:   pubkey.Serialize(),
-			TxHash:   mtx.Hash(),
+			Pubkey:   owner,
+			TxHash:   txid,
 			TxIndex:  uint32(i),
 			Value:    mtx.TxOut[i].Value,
 			Ttype:    ttype,
@@ -300,9 +433,58 @@ func Add(mtx *msg.Tx, hash []byte) error {
 		if err = c.save(); err != nil {
 			return err
 		}
+		if pub, errr := key.NewPublicKey(owner); errr == nil {
+			if err = key.MarkUsed(pub); err != nil {
+				return err
+			}
+		}
+		received += in.Value
 		notify(mtx, in.Script)
 	}
-	return nil
+	if len(own) == 0 && received == 0 {
+		return nil
+	}
+	height := blockHeight(hash)
+	btime := blockTime(hash)
+	return wdb.Batch(func(tx *bolt.Tx) error {
+		h := getHistory(tx, txid)
+		if h.Block == nil {
+			//the history was recorded by BumpFee before the tx was seen,
+			//and values of spent coins are added again below.
+			h.Spent = 0
+			h.OwnInputs = 0
+		}
+		if inBlock {
+			//the replacement lost the race.
+			h.ReplacedBy = nil
+		}
+		if btime != 0 {
+			h.Time = btime
+		}
+		h.Received = received
+		h.Total = total
+		h.Inputs = len(mtx.TxIn)
+		h.Block = hash
+		h.Height = height
+		if len(own) > 0 {
+			h.Addresses = recipients
+		} else {
+			h.Addresses = senders
+		}
+		p := &Pending{}
+		if _, err := db.Get(tx, "pendingtx", txid, p); err == nil {
+			h.Replaces = p.Replaces
+		}
+		if err := putHistory(tx, h); err != nil {
+			return err
+		}
+		for _, in := range own {
+			if err := remove(tx, in.Hash, in.Index, txid); err != nil {
+				return err
+			}
+		}
+		return resolvePending(tx, mtx)
+	})
 }
 
 func notify(mtx *msg.Tx, inscript []byte) {
@@ -314,73 +496,103 @@ func notify(mtx *msg.Tx, inscript []byte) {
 	}
 }
 
-func parseTXout(inscript []byte) (*key.PublicKey, byte, error) {
-	s1 := Script{}
-	err1 := parse(&s1, inscript)
-	s2 := Script2{}
-	err2 := parse(&s2, inscript)
-
-	var pubkey *key.PublicKey
-	var err error
-	var ttype byte
-	switch {
-	case err1 == nil:
-		log.Println("pubkeyhash scriptsig")
-		pubkey, err = checkTxout(&s1)
-		ttype = 0
-	case err2 == nil:
-		log.Println("pubkey scriptsig")
-		pubkey, err = checkTxout2(&s2)
-		ttype = 1
-	default:
-		log.Println(err1, err2)
-		err = fmt.Errorf("This txout is not supproted")
-	}
-	return pubkey, ttype, err
+//parseTXout returns the serialized pubkey which owns the txout,
+//the pubkey hash if the address is imported as watch-only,
+//or the redeem script if the txout is P2SH.
+func parseTXout(pkscript []byte) ([]byte, byte, error) {
+	t := script.Classify(pkscript)
+	switch t.Class {
+	case script.PubKeyHash:
+		owner, err := checkTxout(t.Data[0])
+		return owner, ttypeP2PKH, err
+	case script.PubKey:
+		owner, err := checkTxout2(t.Data[0])
+		return owner, ttypeP2PK, err
+	case script.WitnessPubKeyHash:
+		owner, err := checkTxout(t.Data[0])
+		return owner, ttypeP2WPKH, err
+	case script.ScriptHash:
+		if pub, err := key.FromP2SHWitness(t.Data[0]); err == nil {
+			return pub.Serialize(), ttypeP2SHP2WPKH, nil
+		}
+		owner, err := key.RedeemScript(t.Data[0])
+		return owner, ttypeP2SH, err
+	}
+	return nil, 0, fmt.Errorf("%s txout is not supported", t.Class)
 }
 
-func checkTxin(s *ScriptSigT) (*key.PublicKey, error) {
-	if s.Postfix01 != 0x01 {
-		return nil, errors.New("unsuported scriptsig")
+//checkTxin returns nil if the input i of mtx spends a coin in the wallet.
+//If the input spends a coin stored in the wallet, its scriptsig is verified.
+//A failure of the verification is only logged if mtx is in a block,
+//because block inclusion is the consensus and the coin is spent anyway.
+//Otherwise the owner is guessed from the scriptsig, and the address of
+//the sender is returned with an error if it is not in the wallet.
+func checkTxin(mtx *msg.Tx, i int, inBlock bool) (string, error) {
+	in := mtx.TxIn[i]
+	c, err := getCoin(in.Hash, in.Index)
+	if err != nil {
+		return "", err
+	}
+	if c != nil {
+		if c.isWitness() && !mtx.HasWitness() {
+			//txs in filtered blocks are relayed without witness,
+			//so the spend cannot be verified.
+			return "", nil
+		}
+		if err := script.VerifyInput(mtx, i, c.txOut(), script.StandardFlags); err != nil {
+			err = fmt.Errorf("invalid spend of %s: %s", c.Address(), err)
+			if !inBlock {
+				return "", err
+			}
+			log.Println(err, "but the tx is in a block")
+		}
+		return "", nil
 	}
-	pubkey, err := key.NewPublicKey(s.Pubkey)
+	data, err := script.PushedData(in.Script)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	if key.Find(pubkey) == nil {
-		adr, _ := pubkey.Address()
-		return nil, errors.New("not concerened address " + adr)
+	if len(in.Witness) > 0 {
+		data = in.Witness
+	}
+	if len(data) >= 2 {
+		if _, err := key.RedeemScript(key.Hash160(data[len(data)-1])); err == nil {
+			return "", nil
+		}
+	}
+	if len(data) != 2 {
+		return "", errors.New("unsupported scriptsig")
+	}
+	pubkey, err := key.NewPublicKey(data[1])
+	if err != nil {
+		return "", err
 	}
-	return pubkey, nil
+	adr, hash := pubkey.Address()
+	if !key.Has(pubkey) && !key.IsWatchedHash(hash) {
+		return adr, errors.New("not concerened address " + adr)
+	}
+	return "", nil
 }
 
-func checkTxout(s *Script) (*key.PublicKey, error) {
-	switch {
-	case s.Dup != opDUP:
-		fallthrough
-	case s.Hash160 != opHASH160:
-		fallthrough
-	case s.HashLength != 0x14:
-		fallthrough
-	case s.EqualVerify != opEQUALVERIFY:
-		fallthrough
-	case s.CheckSig != opCHECKSIG:
-		return nil, errors.New("unsuported scriptsig")
-	}
-	return key.FromPubHash(s.PubHash)
+func checkTxout(pubhash []byte) ([]byte, error) {
+	pub, err := key.FromPubHash(pubhash)
+	if err == nil {
+		return pub.Serialize(), nil
+	}
+	if key.IsWatchedHash(pubhash) {
+		return pubhash, nil
+	}
+	return nil, err
 }
 
-func checkTxout2(s *Script2) (*key.PublicKey, error) {
-	if s.CheckSig != opCHECKSIG {
-		return nil, errors.New("unsuported scriptsig")
-	}
-	pubkey, err := key.NewPublicKey(s.Pubkey)
+func checkTxout2(pub []byte) ([]byte, error) {
+	pubkey, err := key.NewPublicKey(pub)
 	if err != nil {
 		return nil, err
 	}
-	if key.Find(pubkey) == nil {
+	if !key.Has(pubkey) {
 		adr, _ := pubkey.Address()
 		return nil, errors.New("not concerened address" + adr)
 	}
-	return pubkey, nil
+	return pubkey.Serialize(), nil
 }
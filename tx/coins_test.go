@@ -31,24 +31,56 @@ package tx
 import (
 	"bytes"
 	"encoding/hex"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/boltdb/bolt"
 	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/block"
 	"github.com/monarj/wallet/db"
 	"github.com/monarj/wallet/key"
 	"github.com/monarj/wallet/msg"
 	"github.com/monarj/wallet/params"
 )
 
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "tx")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := db.Open(filepath.Join(dir, db.FileName), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	key.Init(d)
+	if err = block.Init(d); err != nil {
+		log.Fatal(err)
+	}
+	Init(d)
+	//change goes to keys derived from the seed.
+	if err = key.SetSeed(bytes.Repeat([]byte{0x11}, 32)); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	if err = d.Close(); err != nil {
+		log.Print(err)
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
+
 func del() {
-	errr := db.DB.Update(func(tx *bolt.Tx) error {
-		if err := tx.DeleteBucket([]byte("key")); err != nil {
-			return err
-		}
-		if err := tx.DeleteBucket([]byte("coin")); err != nil {
-			return err
+	errr := wdb.Update(func(tx *bolt.Tx) error {
+		for _, b := range []string{"key", "coin", "spent", "txhistory", "locked", "pendingtx"} {
+			err := tx.DeleteBucket([]byte(b))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
 		}
 		return nil
 	})
@@ -71,7 +103,7 @@ func addpubkey(addr string) *key.PublicKey {
 		log.Fatal(err)
 	}
 	//only for test
-	err = db.DB.Update(func(tx *bolt.Tx) error {
+	err = wdb.Update(func(tx *bolt.Tx) error {
 		return db.Put(tx, "key", a, p.Serialize())
 	})
 	if err != nil {
@@ -186,3 +218,53 @@ func TestTx2(t *testing.T) {
 		t.Fatal("cound not remove coin", len(coins))
 	}
 }
+
+func TestInvalidSpend(t *testing.T) {
+	del()
+	pkey, err := key.FromWIF("T81eGkQ2nrQZGvkcSKCtV1tZJ4WrsKhRsBA1jCgyfMdDjmn5TwGn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Add(pkey)
+	pkscript, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	coin := &Coin{
+		Pubkey:  pkey.PublicKey.Serialize(),
+		TxHash:  bytes.Repeat([]byte{0x09}, 32),
+		Value:   params.Unit,
+		Block:   params.Net.GenesisHash,
+		Script:  pkscript,
+		TxIndex: 0,
+	}
+	if err = coin.save(); err != nil {
+		t.Fatal(err)
+	}
+	//a scriptsig with a broken signature.
+	pub := pkey.PublicKey.Serialize()
+	sig := append([]byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01}, 0x01)
+	scr := append(append([]byte{byte(len(sig))}, sig...), byte(len(pub)))
+	spend := &msg.Tx{
+		Version: 1,
+		TxIn: []msg.TxIn{{
+			Hash:   coin.TxHash,
+			Index:  coin.TxIndex,
+			Script: append(scr, pub...),
+			Seq:    0xffffffff,
+		}},
+		TxOut: []msg.TxOut{{Value: params.Unit / 2, Script: make([]byte, 25)}},
+	}
+	if err = Add(spend, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if c, errr := getCoin(coin.TxHash, coin.TxIndex); errr != nil || c == nil {
+		t.Fatal("unconfirmed invalid spend must not remove the coin")
+	}
+	if err = Add(spend, bytes.Repeat([]byte{0x0a}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if c, errr := getCoin(coin.TxHash, coin.TxIndex); errr != nil || c != nil {
+		t.Error("spend in a block must remove the coin even if it cannot be verified")
+	}
+}
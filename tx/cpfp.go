@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
+)
+
+//ParentTx returns the parent tx to be accelerated by CPFP from s, which is
+//the txid of a pending tx of the wallet or a raw tx in hex.
+func ParentTx(s string) (*msg.Tx, error) {
+	if len(s) == 64 {
+		txid, err := behex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		p, err := GetPending(txid)
+		if err != nil {
+			return nil, err
+		}
+		return p.Tx()
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	mtx := &msg.Tx{}
+	if err := msg.Unpack(bytes.NewBuffer(raw), mtx); err != nil {
+		return nil, err
+	}
+	return mtx, nil
+}
+
+//parentFee returns the fee of mtx if it is known, i.e. if all inputs of mtx
+//are coins of the wallet, or 0 if unknown.
+func parentFee(mtx *msg.Tx) uint64 {
+	if h, err := GetHistory(mtx.Hash()); err == nil && h.Fee > 0 {
+		return h.Fee
+	}
+	var in, out uint64
+	for _, i := range mtx.TxIn {
+		c, err := getCoin(i.Hash, i.Index)
+		if err != nil || c == nil {
+			return 0
+		}
+		in += c.Value
+	}
+	for _, o := range mtx.TxOut {
+		out += o.Value
+	}
+	if in < out {
+		return 0
+	}
+	return in - out
+}
+
+//parentCoin returns the unconfirmed coin at index of parent.
+//If parent is an incoming tx which is not tracked yet, the coin is made
+//from its output to the wallet but not saved, so that it is not in
+//the balance until parent is seen in the network.
+func parentCoin(parent *msg.Tx, index uint32) (*Coin, error) {
+	txid := parent.Hash()
+	c, err := getCoin(txid, index)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		if c, err = untrackedCoin(parent, index); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case c.confirmed():
+		return nil, errors.New("the parent is already confirmed")
+	case c.WatchOnly():
+		return nil, errors.New("the output of the parent is watch-only")
+	case c.Locked():
+		return nil, errors.New("the output of the parent is spent by a pending tx")
+	}
+	return c, nil
+}
+
+//untrackedCoin returns the coin at index of parent which is not in the db.
+func untrackedCoin(parent *msg.Tx, index uint32) (*Coin, error) {
+	errNotOwn := errors.New("the output of the parent is not in the wallet")
+	if int(index) >= len(parent.TxOut) {
+		return nil, errNotOwn
+	}
+	for _, in := range parent.TxIn {
+		if own, err := getCoin(in.Hash, in.Index); err == nil && own != nil {
+			//outputs of txs from the wallet must have been tracked.
+			return nil, errNotOwn
+		}
+	}
+	out := parent.TxOut[index]
+	owner, ttype, err := parseTXout(out.Script)
+	if err != nil {
+		return nil, errNotOwn
+	}
+	return &Coin{
+		Pubkey:  owner,
+		TxHash:  parent.Hash(),
+		TxIndex: index,
+		Value:   out.Value,
+		Ttype:   ttype,
+		Block:   make([]byte, 32),
+		Script:  out.Script,
+	}, nil
+}
+
+//CPFP creates a child tx which spends the output at index of the unconfirmed
+//parent to sends, or only to the change if sends are empty, and pays the fee
+//so that the package of the parent and the child has the fee rate per kB
+//(child-pays-for-parent). Other coins are added if the output is not enough.
+//The fee of the parent is regarded as 0 if it is unknown, e.g. if the parent
+//is an incoming payment. Options other than Replaceable are not used.
+//The wallet is not changed until Commit is called.
+func CPFP(parent *msg.Tx, index uint32, rate uint64, opt *Options, sends ...*Send) (*Result, error) {
+	if opt == nil {
+		opt = &Options{}
+	}
+	var txouts []msg.TxOut
+	var amount uint64
+	var err error
+	if len(sends) > 0 {
+		if txouts, amount, err = p2pkTxouts(sends...); err != nil {
+			return nil, err
+		}
+	}
+	c, err := parentCoin(parent, index)
+	if err != nil {
+		return nil, err
+	}
+	psize, err := vsizeOf(parent)
+	if err != nil {
+		return nil, err
+	}
+	pfee := parentFee(parent)
+	if feeOf(psize, rate) <= pfee {
+		return nil, fmt.Errorf("the parent already pays the fee rate %d", pfee*1000/uint64(psize))
+	}
+	fee := func(cs []*Coin, change bool) uint64 {
+		size := coinsSize(cs, txouts)
+		if change {
+			size += changeSize
+		}
+		f := feeOf(psize+size, rate) - pfee
+		if min := feeOf(size, params.MinRelayFee); f < min {
+			f = min
+		}
+		return f
+	}
+	sel, err := fund([]*Coin{c}, amount, fee, len(txouts) == 0, "cpfp")
+	if err != nil {
+		return nil, err
+	}
+	if sel.change != nil {
+		pub, errr := changeKey(nil, false)
+		if errr != nil {
+			return nil, errr
+		}
+		_, hash := pub.Address()
+		sel.change.Script = script.PayToPubKeyHash(hash)
+		sel.changeKey = pub.Serialize()
+	}
+	if opt.Replaceable {
+		for i := range sel.txins {
+			sel.txins[i].Seq = SeqReplaceable
+		}
+	}
+	txouts, changeIndex, err := sel.addChange(txouts)
+	if err != nil {
+		return nil, err
+	}
+	result := msg.Tx{
+		Version:  1,
+		TxIn:     sel.txins,
+		TxOut:    txouts,
+		Locktime: 0,
+	}
+	if err = fillSign(&result, sel.coins); err != nil {
+		return nil, err
+	}
+	return &Result{
+		Tx:       &result,
+		Fee:      sel.fee,
+		Selector: sel.selector,
+		Change:   changeIndex,
+		sel:      sel,
+	}, nil
+}
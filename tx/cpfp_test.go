@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+)
+
+func TestCPFP(t *testing.T) {
+	del()
+	setup()
+	pkey, err := key.FromWIF("T81eGkQ2nrQZGvkcSKCtV1tZJ4WrsKhRsBA1jCgyfMdDjmn5TwGn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Add(pkey)
+	pkscript, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate := FeeRate()
+
+	//an incoming payment with no fee.
+	parent := &msg.Tx{
+		Version: 1,
+		TxIn: []msg.TxIn{{
+			Hash:   bytes.Repeat([]byte{0x06}, 32),
+			Script: []byte{},
+			Seq:    math.MaxUint32,
+		}},
+		TxOut: []msg.TxOut{
+			{Value: 5 * params.Unit, Script: make([]byte, 25)},
+			{Value: params.Unit, Script: pkscript},
+		},
+	}
+	if _, err = CPFP(parent, 0, 5*rate, nil); err == nil {
+		t.Error("output to others must not be spent")
+	}
+	r, err := CPFP(parent, 1, 5*rate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Selector != "cpfp" || len(r.Tx.TxIn) != 1 || len(r.Tx.TxOut) != 1 || r.Change != 0 {
+		t.Fatal("child must spend the output only to the change", r.Selector, r.Change)
+	}
+	if !bytes.Equal(r.Tx.TxIn[0].Hash, parent.Hash()) || r.Tx.TxIn[0].Index != 1 {
+		t.Error("child must spend the output of the parent")
+	}
+	if c, errr := getCoin(parent.Hash(), 1); errr != nil || c != nil {
+		t.Error("output of the untracked parent must not be saved", errr)
+	}
+	if b := GetBalance(); b.Confirmed != 0 || b.Unconfirmed != r.Tx.TxOut[0].Value || b.Locked != 0 {
+		t.Error("untracked parent must not be in the balance", b)
+	}
+	c, err := untrackedCoin(parent, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Locked() {
+		t.Error("output of the parent must be locked")
+	}
+	checkFee(t, r.Tx, []*Coin{c}, r.Fee)
+	psize := vsize(t, parent)
+	if r.Fee != feeOf(psize+coinsSize([]*Coin{c}, r.Tx.TxOut), 5*rate) {
+		t.Error("child must pay the fee of the package", r.Fee)
+	}
+	if _, err = CPFP(parent, 1, 5*rate, nil); err == nil {
+		t.Error("locked output must not be spent")
+	}
+
+	//a pending tx of the wallet with the known fee.
+	coin := &Coin{
+		Pubkey:  pkey.PublicKey.Serialize(),
+		TxHash:  bytes.Repeat([]byte{0x03}, 32),
+		Value:   10 * params.Unit,
+		Block:   params.Net.GenesisHash,
+		Script:  pkscript,
+		TxIndex: 0,
+	}
+	if err = coin.save(); err != nil {
+		t.Fatal(err)
+	}
+	send := &Send{
+		Addr:   "MS43dMzRKfEs99Q931zFECfUhdvtWmbsPt",
+		Amount: 3 * params.Unit,
+	}
+	p, err := Create(nil, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CPFP(p.Tx, uint32(p.Change), rate, nil, send); err == nil {
+		t.Error("parent already pays the fee rate")
+	}
+	r, err = CPFP(p.Tx, uint32(p.Change), 5*rate, &Options{Replaceable: true}, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Tx.TxIn) != 1 || r.Change < 0 || !Replaceable(r.Tx) {
+		t.Fatal("child must spend the change to send", r.Change)
+	}
+	c, err = getCoin(p.Tx.Hash(), uint32(p.Change))
+	if err != nil || c == nil {
+		t.Fatal(err)
+	}
+	checkFee(t, r.Tx, []*Coin{c}, r.Fee)
+	size := vsize(t, p.Tx) + coinsSize([]*Coin{c}, r.Tx.TxOut)
+	if r.Fee+p.Fee != feeOf(size, 5*rate) {
+		t.Error("child must pay the fee of the package minus the fee of the parent", r.Fee, p.Fee)
+	}
+	if err = r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetPending(r.Tx.Hash()); err != nil {
+		t.Error("child must be pending")
+	}
+}
@@ -30,21 +30,22 @@ package tx
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"log"
 	"math"
-
-	"golang.org/x/crypto/ripemd160"
+	"math/big"
+	"sort"
 
 	"encoding/hex"
 
+	"github.com/boltdb/bolt"
 	"github.com/monarj/wallet/block"
-	"github.com/monarj/wallet/btcec"
 	"github.com/monarj/wallet/key"
 	"github.com/monarj/wallet/msg"
 	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
 )
 
 //Send is information about addrress and amount to send.
@@ -53,48 +54,60 @@ type Send struct {
 	Amount uint64
 }
 
+//p2pkTtxout returns the txout which pays to the address of send,
+//whose script depends on the type of the address.
+//It returns an error if the prefix of the address is unknown or the amount is 0.
 func p2pkTtxout(send *Send) (*msg.TxOut, error) {
-	addr, err := key.DecodeAddress(send.Addr)
+	addr, err := key.ParseAddress(send.Addr)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid address %s: %s", send.Addr, err)
+	}
+	if send.Amount == 0 {
+		return nil, errors.New("amount to send to " + send.Addr + " must be positive")
 	}
-	script := make([]byte, 0, len(addr)+5)
-	script = append(script, opDUP, opHASH160, byte(len(addr)))
-	script = append(script, addr...)
-	script = append(script, opEQUALVERIFY, opCHECKSIG)
 	return &msg.TxOut{
 		Value:  send.Amount,
-		Script: script,
+		Script: addr.Script(),
 	}, nil
 }
 
+//p2pkTxouts validates sends and returns txouts of them and the total amount
+//to send. This must be called before selecting coins.
 func p2pkTxouts(sends ...*Send) ([]msg.TxOut, uint64, error) {
-	total := params.Fee
+	if len(sends) == 0 {
+		return nil, 0, errors.New("no address to send")
+	}
+	var total uint64
 	txouts := make([]msg.TxOut, len(sends))
 	for i, send := range sends {
-		total += send.Amount
 		txout, err := p2pkTtxout(send)
 		if err != nil {
 			return nil, 0, err
 		}
+		if total+send.Amount < total || total+send.Amount > math.MaxInt64 {
+			return nil, 0, errors.New("total amount to send overflows")
+		}
+		total += send.Amount
 		txouts[i] = *txout
 	}
 	return txouts, total, nil
 }
 
-func newTxins(total uint64) ([]msg.TxIn, []*key.PrivateKey, *msg.TxOut, error) {
-	var txins []msg.TxIn
-	var amount uint64
-	coins := SortedCoins()
-	var privs []*key.PrivateKey
-	for i := 0; i < len(coins) && amount < total; i++ {
-		c := coins[i]
-		last := block.Lastblock()
-		current, err := block.LoadBlock(c.Block)
-		if err != nil {
-			log.Fatal(err)
+//changeSize is the size of a P2PKH change txout.
+const changeSize = 8 + 1 + 25
+
+//spendableCoins returns coins which can be spent now,
+//i.e. confirmed coins which are not spent by pending txs.
+//It returns only watch-only coins if watch is true.
+func spendableCoins(watch bool) []*Coin {
+	var spendable []*Coin
+	last := block.Lastblock()
+	for _, c := range SortedCoins() {
+		if c.WatchOnly() != watch || c.Locked() {
+			continue
 		}
-		if !block.Confirmed(current) {
+		current, err := block.LoadBlock(c.Block)
+		if err != nil || !block.Confirmed(current) {
 			log.Println("unspendable because of height")
 			continue
 		}
@@ -102,97 +115,421 @@ func newTxins(total uint64) ([]msg.TxIn, []*key.PrivateKey, *msg.TxOut, error) {
 			log.Println("unspendable coinbase because of height")
 			continue
 		}
-		txins = append(txins, msg.TxIn{
+		spendable = append(spendable, c)
+	}
+	return spendable
+}
+
+//selection is coins selected to pay txouts.
+type selection struct {
+	txins  []msg.TxIn
+	coins  []*Coin
+	change *msg.TxOut
+	//changeKey is the serialized public key of the change.
+	changeKey []byte
+	fee       uint64
+	//selector is the name of the strategy which selected coins.
+	selector string
+}
+
+//newTxins selects coins by sel whose total covers txouts and the fee of the tx
+//in the current fee rate, and returns txins of them, the coins and the change txout.
+//The change goes to a new key of the internal chain, and is omitted if it is dust.
+//It selects only watch-only coins if watch is true.
+func newTxins(txouts []msg.TxOut, watch bool, sel CoinSelector) (*selection, error) {
+	t := &Target{
+		TxOuts:  txouts,
+		FeeRate: FeeRate(),
+	}
+	for _, out := range txouts {
+		t.Amount += out.Value
+	}
+	if sel == nil {
+		sel = DefaultSelector
+	}
+	candidates := spendableCoins(watch)
+	used, err := sel.Select(candidates, t)
+	if err == ErrNoChangeless && sel == DefaultSelector {
+		sel = LargestFirst
+		used, err = sel.Select(candidates, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !t.Covered(used) {
+		return nil, t.shortage(used)
+	}
+	result := &selection{
+		coins:    used,
+		selector: sel.Name(),
+	}
+	var amount uint64
+	for _, c := range used {
+		result.txins = append(result.txins, msg.TxIn{
+			Hash:   c.TxHash,
+			Index:  c.TxIndex,
+			Script: c.scriptCode(), //pubscript to sign.
+			Seq:    math.MaxUint32,
+		})
+		amount += c.Value
+	}
+	result.fee = t.Fee(used, true)
+	change := &msg.TxOut{
+		Script: make([]byte, changeSize-9),
+	}
+	if amount > t.Amount+result.fee {
+		change.Value = amount - t.Amount - result.fee
+	}
+	if isDust(change) {
+		result.fee = amount - t.Amount
+		return result, nil
+	}
+	pub, err := changeKey(used, watch)
+	if err != nil {
+		return nil, err
+	}
+	_, hash := pub.Address()
+	change.Script = script.PayToPubKeyHash(hash)
+	result.change = change
+	result.changeKey = pub.Serialize()
+	return result, nil
+}
+
+//fund adds the largest spendable coins to coins until they cover amount and
+//the fee, which is computed by fee from the coins and whether the tx has change,
+//and returns txins of the coins, the fee and the change without its script.
+//The change is omitted if it is dust, unless needChange is true.
+func fund(coins []*Coin, amount uint64, fee func([]*Coin, bool) uint64,
+	needChange bool, selector string) (*selection, error) {
+	var total uint64
+	for _, c := range coins {
+		total += c.Value
+	}
+	candidates := spendableCoins(false)
+	sort.Stable(sort.Reverse(Coins(candidates)))
+	result := &selection{selector: selector}
+	for {
+		f := fee(coins, true)
+		if total > amount+f {
+			change := &msg.TxOut{
+				Value:  total - amount - f,
+				Script: make([]byte, changeSize-9),
+			}
+			if !isDust(change) {
+				result.fee = f
+				result.change = change
+				break
+			}
+		}
+		if f = fee(coins, false); !needChange && total >= amount+f {
+			result.fee = total - amount
+			break
+		}
+		if len(candidates) == 0 {
+			return nil, &ShortageError{Total: total, Amount: amount + f, Fee: f}
+		}
+		coins = append(coins, candidates[0])
+		total += candidates[0].Value
+		candidates = candidates[1:]
+	}
+	result.coins = coins
+	for _, c := range coins {
+		result.txins = append(result.txins, msg.TxIn{
 			Hash:   c.TxHash,
 			Index:  c.TxIndex,
-			Script: c.Script, //pubscript to sign.
+			Script: c.scriptCode(), //pubscript to sign.
 			Seq:    math.MaxUint32,
 		})
+	}
+	return result, nil
+}
+
+//changeKey returns a new key of the internal chain for change.
+//For watch-only coins the key is derived from the imported extended public key
+//of the account of the coins, so that funds of the account are not mixed
+//with the wallet.
+func changeKey(coins []*Coin, watch bool) (*key.PublicKey, error) {
+	if !watch {
+		return key.NewChange()
+	}
+	for _, c := range coins {
 		pub, err := key.NewPublicKey(c.Pubkey)
 		if err != nil {
-			return nil, nil, nil, err
+			continue
 		}
-		privs = append(privs, key.Find(pub))
-		amount += c.Value
+		if p, err := key.PathOf(pub); err == nil && p.Watch {
+			return key.NewWatchChange(p.Account)
+		}
+	}
+	return nil, errors.New("no extended public key of the watch-only coins to derive change")
+}
+
+//addChange inserts the change txout to txouts at a random position,
+//and returns txouts and the index of the change, or -1 if there is no change.
+func (s *selection) addChange(txouts []msg.TxOut) ([]msg.TxOut, int, error) {
+	if s.change == nil {
+		return txouts, -1, nil
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(txouts)+1)))
+	if err != nil {
+		return nil, 0, err
 	}
-	if amount < total {
-		return nil, nil, nil, fmt.Errorf("shortage of coin %d < %d %d",
-			amount, total, len(coins))
-	}
-	remain := amount - total
-	var mto *msg.TxOut
-	var err error
-	if remain > 0 {
-		myadr, _ := privs[0].Address()
-		s := Send{
-			Addr:   myadr,
-			Amount: remain,
+	i := int(n.Int64())
+	txouts = append(txouts, msg.TxOut{})
+	copy(txouts[i+1:], txouts[i:])
+	txouts[i] = *s.change
+	return txouts, i, nil
+}
+
+//commit records the change txout at index of signed mtx and mtx replacing
+//the tx replaces, which can be nil, as pending in the db tx.
+//It fails if a coin spent by mtx is spent by another pending tx.
+func (s *selection) commit(tx *bolt.Tx, mtx *msg.Tx, index int, replaces []byte) error {
+	for _, in := range mtx.TxIn {
+		if lockedBy(tx, in.Hash, in.Index) != nil {
+			return errors.New("a coin spent by the tx is spent by a pending tx")
 		}
-		mto, err = p2pkTtxout(&s)
 	}
-	return txins, privs, mto, err
+	if err := s.putChange(tx, mtx, index); err != nil {
+		return err
+	}
+	return putPending(tx, mtx, replaces)
+}
+
+//putChange records the change txout at index of signed mtx as a coin
+//of the wallet, which is unconfirmed until mtx is in a block.
+func (s *selection) putChange(tx *bolt.Tx, mtx *msg.Tx, index int) error {
+	if index < 0 {
+		return nil
+	}
+	c := &Coin{
+		Pubkey:  s.changeKey,
+		TxHash:  mtx.Hash(),
+		TxIndex: uint32(index),
+		Value:   s.change.Value,
+		Ttype:   ttypeP2PKH,
+		Block:   make([]byte, 32),
+		Script:  s.change.Script,
+	}
+	return c.put(tx)
+}
+
+//scriptCode returns the script which is signed to spend the coin.
+func (c *Coin) scriptCode() []byte {
+	switch c.Ttype {
+	case ttypeP2SH:
+		return c.Pubkey
+	case ttypeP2WPKH, ttypeP2SHP2WPKH:
+		return script.PayToPubKeyHash(c.pubHash())
+	}
+	return c.Script
+}
+
+//sigHash returns the hash of result to be signed for the input i,
+//which spends the coin.
+func (c *Coin) sigHash(result *msg.Tx, i int) ([]byte, error) {
+	if c.isWitness() {
+		return script.WitnessSignatureHash(result, i, c.scriptCode(), c.Value, script.SigHashAll)
+	}
+	return script.SignatureHash(result, i, c.scriptCode(), script.SigHashAll)
 }
 
-func signTx(result *msg.Tx, privs []*key.PrivateKey) ([][]byte, error) {
-	var buf bytes.Buffer
-	if err := msg.Pack(&buf, *result); err != nil {
+//signTx signs the first input of result, whose script code is subscript.
+func signTx(result *msg.Tx, subscript []byte, privs []*key.PrivateKey) ([][]byte, error) {
+	h, err := script.SignatureHash(result, 0, subscript, script.SigHashAll)
+	if err != nil {
 		return nil, err
 	}
-	beforeb := buf.Bytes()
-	beforeb = append(beforeb, 0x01, 0, 0, 0) //hash code type
-	h := sha256.Sum256(beforeb)
-	h = sha256.Sum256(h[:])
-	log.Println(hex.EncodeToString(h[:]))
+	log.Println(hex.EncodeToString(h))
 	sign := make([][]byte, len(privs))
-	var err error
 	for i, p := range privs {
-		sign[i], err = p.Sign(h[:])
+		sign[i], err = p.Sign(h)
 		if err != nil {
 			return nil, err
 		}
 	}
 	return sign, nil
 }
-func fillSign(result *msg.Tx, privs []*key.PrivateKey) error {
-	signs, err := signTx(result, privs)
-	if err != nil {
-		return err
+
+//fillSign signs result and sets scriptsigs which spend coins,
+//and verifies the scriptsigs.
+func fillSign(result *msg.Tx, coins []*Coin) error {
+	if key.IsLocked() {
+		return key.ErrLocked
+	}
+	for i, c := range coins {
+		h, err := c.sigHash(result, i)
+		if err != nil {
+			return err
+		}
+		if c.Ttype == ttypeP2SH {
+			if result.TxIn[i].Script, err = p2shScriptSig(h, c.Pubkey); err != nil {
+				return err
+			}
+			continue
+		}
+		pub, err := key.NewPublicKey(c.Pubkey)
+		if err != nil {
+			return err
+		}
+		priv, err := key.Find(pub)
+		if err != nil {
+			return err
+		}
+		s, err := priv.Sign(h)
+		if err != nil {
+			return err
+		}
+		sig := append(s, byte(script.SigHashAll))
+		switch c.Ttype {
+		case ttypeP2PK:
+			result.TxIn[i].Script = script.PushData(sig)
+		case ttypeP2PKH:
+			result.TxIn[i].Script = append(script.PushData(sig), script.PushData(pub.Serialize())...)
+		case ttypeP2WPKH, ttypeP2SHP2WPKH:
+			result.TxIn[i].Script = nil
+			if c.Ttype == ttypeP2SHP2WPKH {
+				result.TxIn[i].Script = script.PushData(c.RedeemScript())
+			}
+			result.TxIn[i].Witness = [][]byte{sig, pub.Serialize()}
+		}
 	}
-	for i, s := range signs {
-		s = append(s, 0x1)
-		scr := result.TxIn[i].Script[:0]
-		scr = append(scr, byte(len(s)))
-		scr = append(scr, s...)
-		pub := privs[i].PublicKey.Serialize()
-		scr = append(scr, byte(len(pub)))
-		scr = append(scr, pub...)
-		result.TxIn[i].Script = scr
+	for i, c := range coins {
+		if err := script.VerifyInput(result, i, c.txOut(), script.StandardFlags); err != nil {
+			return fmt.Errorf("created an invalid input %d: %s", i, err)
+		}
 	}
 	return nil
 }
 
-//NewP2PK creates msg.Tx from send infos.
-func NewP2PK(sends ...*Send) (*msg.Tx, error) {
-	txouts, total, err := p2pkTxouts(sends...)
+//Options is options to create a tx.
+type Options struct {
+	//Selector is the strategy to select coins.
+	//DefaultSelector is used if nil.
+	Selector CoinSelector
+	//Replaceable signals that the tx can be replaced by BumpFee (BIP125).
+	Replaceable bool
+}
+
+//Result is a tx created by the wallet and how it was created.
+type Result struct {
+	Tx *msg.Tx
+	//Fee is the fee which Tx pays.
+	Fee uint64
+	//Selector is the name of the strategy which selected coins.
+	Selector string
+	//Change is the index of the change txout, or -1 if there is no change.
+	Change int
+
+	sel *selection
+}
+
+//Commit records the change of the tx as a coin and the tx as pending,
+//which locks coins spent by the tx until it is confirmed or abandoned.
+//It should be called after the tx is broadcasted, so that nothing is
+//left in the wallet if broadcasting fails.
+func (r *Result) Commit() error {
+	return wdb.Batch(func(tx *bolt.Tx) error {
+		return r.sel.commit(tx, r.Tx, r.Change, nil)
+	})
+}
+
+//Create creates a signed msg.Tx from send infos with opt.
+//opt can be nil.
+//The wallet is not changed until Commit is called.
+func Create(opt *Options, sends ...*Send) (*Result, error) {
+	if opt == nil {
+		opt = &Options{}
+	}
+	txouts, _, err := p2pkTxouts(sends...)
 	if err != nil {
 		return nil, err
 	}
-	txins, privs, mto, err := newTxins(total)
+	sel, err := newTxins(txouts, false, opt.Selector)
 	if err != nil {
 		return nil, err
 	}
-	if mto != nil {
-		txouts = append(txouts, *mto)
+	if opt.Replaceable {
+		for i := range sel.txins {
+			sel.txins[i].Seq = SeqReplaceable
+		}
+	}
+	txouts, change, err := sel.addChange(txouts)
+	if err != nil {
+		return nil, err
 	}
 	result := msg.Tx{
 		Version:  1,
-		TxIn:     txins,
+		TxIn:     sel.txins,
 		TxOut:    txouts,
 		Locktime: 0,
 	}
-	err = fillSign(&result, privs)
+	if err = fillSign(&result, sel.coins); err != nil {
+		return nil, err
+	}
+	return &Result{
+		Tx:       &result,
+		Fee:      sel.fee,
+		Selector: sel.selector,
+		Change:   change,
+		sel:      sel,
+	}, nil
+}
+
+//NewP2PK creates msg.Tx from send infos.
+//Coins spent by the tx are locked until the tx is confirmed or abandoned.
+func NewP2PK(sends ...*Send) (*msg.Tx, error) {
+	r, err := Create(nil, sends...)
+	if err != nil {
+		return nil, err
+	}
+	return r.Tx, r.Commit()
+}
 
-	return &result, err
+//Unsigned is a tx to be signed offline.
+type Unsigned struct {
+	//Tx is the tx whose scripts of txins are the scripts of previous txouts.
+	Tx *msg.Tx
+	//Prevs are txouts spent by txins of Tx in the same order.
+	//Their values are needed to sign segwit inputs (BIP143).
+	Prevs []msg.TxOut
+}
+
+//NewUnsigned creates an unsigned tx from send infos using watch-only coins,
+//so that a wallet with the private keys can sign it offline.
+//Coins spent by the tx are locked until the signed tx is in a block or
+//the tx is abandoned by its (unsigned) txid.
+func NewUnsigned(sends ...*Send) (*Unsigned, error) {
+	txouts, _, err := p2pkTxouts(sends...)
+	if err != nil {
+		return nil, err
+	}
+	sel, err := newTxins(txouts, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	txouts, index, err := sel.addChange(txouts)
+	if err != nil {
+		return nil, err
+	}
+	u := &Unsigned{
+		Tx: &msg.Tx{
+			Version:  1,
+			TxIn:     sel.txins,
+			TxOut:    txouts,
+			Locktime: 0,
+		},
+	}
+	for _, c := range sel.coins {
+		u.Prevs = append(u.Prevs, *c.txOut())
+	}
+	err = wdb.Batch(func(tx *bolt.Tx) error {
+		return sel.commit(tx, u.Tx, index, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
 }
 
 //PubInfo is infor of public key in M of N multisig.
@@ -203,61 +540,65 @@ type PubInfo struct {
 	M      byte
 }
 
+//validate checks the number of keys and the amount of the multisig.
+func (p *PubInfo) validate() error {
+	if p.M == 0 || len(p.Pubs) == 0 || int(p.M) > len(p.Pubs) || len(p.Pubs) > 16 {
+		return errors.New("illegal number of keys for multisig")
+	}
+	if p.Amount == 0 {
+		return errors.New("amount of multisig must be positive")
+	}
+	return nil
+}
+
 func (p *PubInfo) redeemScript() []byte {
-	scr := make([]byte, 0, 3+len(p.Pubs)*btcec.PubKeyBytesLenUncompressed)
-	scr = append(scr, op1+(p.M-1))
-	for _, pu := range p.Pubs {
-		ser := pu.Serialize()
-		scr = append(scr, byte(len(ser)))
-		scr = append(scr, ser...)
+	pubs := make([][]byte, len(p.Pubs))
+	for i, pu := range p.Pubs {
+		pubs[i] = pu.Serialize()
 	}
-	scr = append(scr, op1+(byte(len(p.Pubs)-1)))
-	scr = append(scr, opCHECKMULTISIG)
-	return scr
+	return script.MultisigScript(int(p.M), pubs)
 }
 
+//redeemHash returns the P2SH script of the redeem script.
 func (p *PubInfo) redeemHash() ([]byte, error) {
-	redeem := p.redeemScript()
-	h := sha256.Sum256(redeem)
-	ripeHash := ripemd160.New()
-	if _, err := ripeHash.Write(h[:]); err != nil {
-		return nil, err
-	}
-	hash160 := ripeHash.Sum(nil)
-	script := make([]byte, 0, len(hash160)+3)
-	script = append(script, opHASH160, byte(len(hash160)))
-	script = append(script, hash160...)
-	script = append(script, opEQUAL)
-	return script, nil
+	return script.PayToScriptHash(key.Hash160(p.redeemScript())), nil
 }
 
 //MultisigOut creates multisig output.
 func (p *PubInfo) MultisigOut() (*msg.Tx, error) {
-	script, err := p.redeemHash()
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	pkscript, err := p.redeemHash()
 	if err != nil {
 		return nil, err
 	}
 	txouts := make([]msg.TxOut, 1, 2)
 	txouts[0] = msg.TxOut{
 		Value:  p.Amount,
-		Script: script,
+		Script: pkscript,
 	}
-	txins, privs, mto, err := newTxins(p.Amount + params.Fee)
+	sel, err := newTxins(txouts, false, nil)
 	if err != nil {
 		return nil, err
 	}
-	if mto != nil {
-		txouts = append(txouts, *mto)
+	txouts, change, err := sel.addChange(txouts)
+	if err != nil {
+		return nil, err
 	}
 	result := msg.Tx{
 		Version:  1,
-		TxIn:     txins,
+		TxIn:     sel.txins,
 		TxOut:    txouts,
 		Locktime: 0,
 	}
-	err = fillSign(&result, privs)
 	p.Prev = &result
-	return &result, err
+	if err = fillSign(&result, sel.coins); err != nil {
+		return &result, err
+	}
+	return &result, wdb.Batch(func(tx *bolt.Tx) error {
+		return sel.commit(tx, &result, change, nil)
+	})
 }
 
 func (p *PubInfo) searchTxout() (uint32, error) {
@@ -277,7 +618,9 @@ func (p *PubInfo) txForSign(seq, locktime uint32, sends ...*Send) (*msg.Tx, erro
 	if p.Prev == nil {
 		return nil, errors.New("must call MultisigOut first")
 	}
-	total := params.Fee
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
 	txouts, total, err := p2pkTxouts(sends...)
 	if err != nil {
 		return nil, err
@@ -290,11 +633,10 @@ func (p *PubInfo) txForSign(seq, locktime uint32, sends ...*Send) (*msg.Tx, erro
 	if err != nil {
 		return nil, err
 	}
-	script := p.Prev.TxOut[index].Script
 	mtxin := msg.TxIn{
 		Hash:   p.Prev.Hash(),
 		Index:  index,
-		Script: script,
+		Script: p.redeemScript(), //script code to sign.
 		Seq:    seq,
 	}
 	mtx := msg.Tx{
@@ -308,15 +650,11 @@ func (p *PubInfo) txForSign(seq, locktime uint32, sends ...*Send) (*msg.Tx, erro
 }
 
 func (p *PubInfo) verify(mtx *msg.Tx, sign []byte, i int) error {
-	var buf bytes.Buffer
-	if err := msg.Pack(&buf, *mtx); err != nil {
+	h, err := script.SignatureHash(mtx, 0, p.redeemScript(), script.SigHashAll)
+	if err != nil {
 		return err
 	}
-	beforeb := buf.Bytes()
-	beforeb = append(beforeb, 0x01, 0, 0, 0) //hash code type
-	h := sha256.Sum256(beforeb)
-	h = sha256.Sum256(h[:])
-	return p.Pubs[i].Verify(sign, h[:])
+	return p.Pubs[i].Verify(sign, h)
 }
 
 //SignMultisig signs multisig transaction by priv.
@@ -326,7 +664,7 @@ func (p *PubInfo) SignMultisig(priv *key.PrivateKey,
 	if err != nil {
 		return nil, err
 	}
-	signs, err := signTx(mtx, []*key.PrivateKey{priv})
+	signs, err := signTx(mtx, p.redeemScript(), []*key.PrivateKey{priv})
 	if err != nil {
 		return nil, err
 	}
@@ -345,16 +683,19 @@ func (p *PubInfo) MultisigIn(seq, locktime uint32, sigs [][]byte, sends ...*Send
 	}
 	redeem := p.redeemScript()
 	script2 := make([]byte, 0, 73*len(sigs)+len(redeem)+3)
-	script2 = append(script2, op0)
+	script2 = append(script2, script.Op0)
 	var nsig byte
 	for i, s := range sigs {
 		if s == nil {
-			pri := key.Find(p.Pubs[i])
-			if pri == nil {
+			pri, err := key.Find(p.Pubs[i])
+			if err == key.ErrLocked {
+				return nil, err
+			}
+			if err != nil {
 				log.Printf("no private key from pubkey %d", i)
 				continue
 			}
-			signs, err := signTx(mtx, []*key.PrivateKey{pri})
+			signs, err := signTx(mtx, redeem, []*key.PrivateKey{pri})
 			if err != nil {
 				return nil, err
 			}
@@ -364,16 +705,17 @@ func (p *PubInfo) MultisigIn(seq, locktime uint32, sigs [][]byte, sends ...*Send
 				return nil, fmt.Errorf("%s at %d", err, i)
 			}
 		}
-		script2 = append(script2, byte(len(s)))
-		script2 = append(script2, s...)
+		script2 = append(script2, script.PushData(append(s, byte(script.SigHashAll)))...)
 		nsig++
 	}
 	if nsig != p.M {
 		return nil, errors.New("signatures are not enough")
 	}
-	script2 = append(script2, opPUSHDATA1, byte(len(redeem)))
-	script2 = append(script2, redeem...)
+	script2 = append(script2, script.PushData(redeem)...)
 	mtx.TxIn[0].Script = script2
-
+	prev := &p.Prev.TxOut[mtx.TxIn[0].Index]
+	if err := script.VerifyInput(mtx, 0, prev, script.StandardFlags); err != nil {
+		return nil, fmt.Errorf("created an invalid multisig input: %s", err)
+	}
 	return mtx, nil
 }
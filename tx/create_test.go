@@ -39,15 +39,17 @@ import (
 	"bytes"
 
 	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/base58check"
 	"github.com/monarj/wallet/behex"
 	"github.com/monarj/wallet/db"
 	"github.com/monarj/wallet/key"
 	"github.com/monarj/wallet/msg"
 	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
 )
 
 func setup() {
-	err := db.DB.Update(func(tx *bolt.Tx) error {
+	err := wdb.Update(func(tx *bolt.Tx) error {
 		out := make([]byte, 8+32)
 		binary.LittleEndian.PutUint64(out[:8], 100)
 		return db.Put(tx, "status", []byte("lastblock"), out)
@@ -57,7 +59,29 @@ func setup() {
 	}
 }
 
+//changePath returns the path of the key which the change txout pays to,
+//which must be in the internal chain of the seed.
+func changePath(t *testing.T, change *msg.TxOut) *key.Path {
+	owner, _, err := parseTXout(change.Script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.NewPublicKey(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := key.PathOf(pub)
+	if err != nil {
+		t.Fatal("change must go to a key of the wallet", err)
+	}
+	if p.Change != key.Internal || p.Watch {
+		t.Error("change must go to the internal chain", p)
+	}
+	return p
+}
+
 func TestCreate2(t *testing.T) {
+	del()
 	setup()
 	log.SetFlags(log.Ldate | log.Lshortfile | log.Ltime)
 
@@ -84,7 +108,7 @@ func TestCreate2(t *testing.T) {
 		"12c2f61d839b2b38146715e4dfc0fd988806253920480298816f108513e53e5c",
 	}
 
-	script, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
+	pkscript, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -92,12 +116,9 @@ func TestCreate2(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	hashout, err := hex.DecodeString("938fec7fa5ab2d7d3f5febe71bc295bfaa4b8fdf2cf414c3d7e5fccd56942364")
-	if err != nil {
-		t.Fatal(err)
-	}
 	values := []uint64{100 * params.Unit, 150 * params.Unit}
 
+	var coins []*Coin
 	for i, h := range txhashes {
 		var ha []byte
 		ha, err = behex.DecodeString(h)
@@ -109,14 +130,15 @@ func TestCreate2(t *testing.T) {
 			TxHash:   ha,
 			Value:    values[i],
 			Ttype:    0,
-			Block:    params.GenesisHash,
-			Script:   script,
+			Block:    params.Net.GenesisHash,
+			Script:   pkscript,
 			TxIndex:  uint32(i + 1),
 			Coinbase: false,
 		}
 		if err = coin.save(); err != nil {
 			t.Fatal(err)
 		}
+		coins = append(coins, coin)
 	}
 	pi := &PubInfo{
 		Pubs:   []*key.PublicKey{pkey2.PublicKey, pkey3.PublicKey, pkey.PublicKey},
@@ -142,20 +164,35 @@ func TestCreate2(t *testing.T) {
 	}
 	byt := buf.Bytes()
 	log.Println(hex.EncodeToString(byt))
-	for _, in := range txout.TxIn {
-		slen := in.Script[0]
-		script = in.Script[1:slen]
-		//in.Script[slen]=0x01,in.Script[slen+1]=length of pubkey
-		var pubk *key.PublicKey
-		pubk, err = key.NewPublicKey(in.Script[slen+2:])
-		if err != nil {
-			t.Fatal(err)
-		}
-		if err = pubk.Verify(script, hashout); err != nil {
-			t.Error("illegal tx")
+	for i := range txout.TxIn {
+		prev := &msg.TxOut{Script: pkscript}
+		if err = script.VerifyInput(txout, i, prev, script.StandardFlags); err != nil {
+			t.Error("illegal tx", err)
 		}
 	}
 
+	//the multisig output and the change which pays the size-based fee.
+	p2sh, err := pi.redeemHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txout.TxIn) != 2 || len(txout.TxOut) != 2 {
+		t.Fatal("tx must spend both coins to the multisig and the change")
+	}
+	multi := 0
+	if !bytes.Equal(txout.TxOut[multi].Script, p2sh) {
+		multi = 1
+	}
+	if !bytes.Equal(txout.TxOut[multi].Script, p2sh) || txout.TxOut[multi].Value != pi.Amount {
+		t.Fatal("illegal multisig output")
+	}
+	fee := feeOf(coinsSize(coins, txout.TxOut), FeeRate())
+	checkFee(t, txout, coins, fee)
+	if change := txout.TxOut[1-multi]; change.Value != 250*params.Unit-pi.Amount-fee {
+		t.Error("change must be the rest of the fee", change.Value, fee)
+	}
+	changePath(t, &txout.TxOut[1-multi])
+
 	//for test
 	scr := "483045022100902c0effe741979fd353a038897ab7eee17e1bea3ea8987298e52539de9a70f20220458310b9129b1123a72b22f0206857bec67b71d1e3df3502c8adef93f37818e801210373f0f5d4488616b20537810f5281ea27dd65213fa40be696086c6d2c3319419e"
 	txhash := "1eb8d0cfd1963d6295fcb5a76800fb8ae0a0c5332c349131d9bdf3d340f57eed"
@@ -163,8 +200,19 @@ func TestCreate2(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	pi.Prev.TxIn[0].Script = scrb
-	pi.Prev.TxIn[1].Script = scrb
+	//a multisig tx created from the same coins by an older version,
+	//which paid the flat fee and sent the change to the spent address.
+	pi.Prev = &msg.Tx{
+		Version: 1,
+		TxIn: []msg.TxIn{
+			{Hash: coins[0].TxHash, Index: coins[0].TxIndex, Script: scrb, Seq: math.MaxUint32},
+			{Hash: coins[1].TxHash, Index: coins[1].TxIndex, Script: scrb, Seq: math.MaxUint32},
+		},
+		TxOut: []msg.TxOut{
+			{Value: 200 * params.Unit, Script: p2sh},
+			{Value: 50*params.Unit - params.Fee, Script: pkscript},
+		},
+	}
 	txhashb, err := behex.DecodeString(txhash)
 	if err != nil {
 		t.Fatal(err)
@@ -172,10 +220,6 @@ func TestCreate2(t *testing.T) {
 	if !bytes.Equal(pi.Prev.Hash(), txhashb) {
 		t.Fatal("tx unamtches")
 	}
-	hashin, err := hex.DecodeString("c19a154a97e2fbc86f1b647f4e610a74932c33144a09f16b400c6354a7258090")
-	if err != nil {
-		t.Fatal(err)
-	}
 
 	send := &Send{
 		Addr:   "MTi4x2NtDpdyXSwEvwU3aZ1Uronz1JBNC3",
@@ -197,19 +241,21 @@ func TestCreate2(t *testing.T) {
 	}
 	byt = buf2.Bytes()
 	log.Println(hex.EncodeToString(byt))
-	slen := tx.TxIn[0].Script[1]
-	script = tx.TxIn[0].Script[2 : slen+2]
-	if err = pkey2.PublicKey.Verify(script, hashin); err != nil {
-		t.Error("illegal tx")
+	data, err := script.PushedData(tx.TxIn[0].Script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4 || len(data[0]) != 0 || !bytes.Equal(data[3], redeem) {
+		t.Fatal("illegal scriptsig of multisig")
 	}
-	slen2 := tx.TxIn[0].Script[slen+2]
-	script = tx.TxIn[0].Script[slen+3 : slen+slen2+3]
-	if err = pkey.PublicKey.Verify(script, hashin); err != nil {
-		t.Error("illegal tx")
+	prev := &pi.Prev.TxOut[tx.TxIn[0].Index]
+	if err = script.VerifyInput(tx, 0, prev, script.StandardFlags); err != nil {
+		t.Error("illegal tx", err)
 	}
 }
 
 func TestCreate1(t *testing.T) {
+	del()
 	setup()
 	log.SetFlags(log.Ldate | log.Lshortfile | log.Ltime)
 
@@ -224,15 +270,11 @@ func TestCreate1(t *testing.T) {
 		"12c2f61d839b2b38146715e4dfc0fd914906253920480298816f108513e53e5c",
 		"12c2f61d839b2b38146715e4dfc0fd988806253920480298816f108513e53e5c",
 	}
-	script, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
+	pkscript, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
 	if err != nil {
 		t.Fatal(err)
 	}
 	values := []uint64{100 * params.Unit, 150 * params.Unit}
-	hashresult, err := hex.DecodeString("54d2f42aa370fea481145a699a86191f625b01e0160427062bb01fca91cb644c")
-	if err != nil {
-		t.Fatal(err)
-	}
 
 	key.Add(pkey)
 
@@ -247,8 +289,8 @@ func TestCreate1(t *testing.T) {
 			TxHash:   ha,
 			Value:    values[i],
 			Ttype:    0,
-			Block:    params.GenesisHash,
-			Script:   script,
+			Block:    params.Net.GenesisHash,
+			Script:   pkscript,
 			TxIndex:  uint32(i + 1),
 			Coinbase: false,
 		}
@@ -270,16 +312,323 @@ func TestCreate1(t *testing.T) {
 	}
 	byt := buf.Bytes()
 	log.Println(hex.EncodeToString(byt))
-	for _, in := range tx.TxIn {
-		slen := in.Script[0]
-		script := in.Script[1:slen]
-		//in.Script[slen]=0x01,in.Script[slen+1]=length of pubkey
-		pubk, err := key.NewPublicKey(in.Script[slen+2:])
+	for i := range tx.TxIn {
+		prev := &msg.TxOut{Script: pkscript}
+		if err = script.VerifyInput(tx, i, prev, script.StandardFlags); err != nil {
+			t.Error("illegal tx", err)
+		}
+	}
+}
+
+func TestSendAddress(t *testing.T) {
+	redeem, err := hex.DecodeString("52210235dad6f5b0655e5ec633e71c3d8e0acee49a314c76a2650f6d60bc291d631c9d2103bd9b94f58dd51233a1380accd944aa44d9846fab673497ca4de794f79ecdbccd210373f0f5d4488616b20537810f5281ea27dd65213fa40be696086c6d2c3319419e53ae")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := key.Hash160(redeem)
+	p2sh := script.PayToScriptHash(h)
+	adr := base58check.Encode(params.Net.P2SHHeader, h)
+	if adr[0] != 'P' {
+		t.Error("P2SH address must start with P", adr)
+	}
+	for _, a := range []string{adr, base58check.Encode(params.Net.P2SHHeaderAlt, h)} {
+		out, err := p2pkTtxout(&Send{Addr: a, Amount: params.Unit})
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err = pubk.Verify(script, hashresult); err != nil {
-			t.Error("illegal tx")
+		if !bytes.Equal(out.Script, p2sh) {
+			t.Error("output to P2SH address must be P2SH", a)
+		}
+	}
+	out, err := p2pkTtxout(&Send{Addr: "MTi4x2NtDpdyXSwEvwU3aZ1Uronz1JBNC3", Amount: params.Unit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if script.Classify(out.Script).Class != script.PubKeyHash {
+		t.Error("output to P2PKH address must be P2PKH")
+	}
+
+	invalid := [][]*Send{
+		nil,
+		{{Addr: base58check.Encode(0x00, h), Amount: params.Unit}},
+		{{Addr: adr, Amount: 0}},
+		{{Addr: adr, Amount: math.MaxUint64}, {Addr: adr, Amount: params.Unit}},
+	}
+	for i, sends := range invalid {
+		if _, err := NewP2PK(sends...); err == nil {
+			t.Error("invalid sends must be rejected", i)
+		}
+	}
+}
+
+func TestChange(t *testing.T) {
+	del()
+	setup()
+	pkey, err := key.FromWIF("T81eGkQ2nrQZGvkcSKCtV1tZJ4WrsKhRsBA1jCgyfMdDjmn5TwGn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Add(pkey)
+	pkscript, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	coin := &Coin{
+		Pubkey:  pkey.PublicKey.Serialize(),
+		TxHash:  bytes.Repeat([]byte{0x03}, 32),
+		Value:   10 * params.Unit,
+		Block:   params.Net.GenesisHash,
+		Script:  pkscript,
+		TxIndex: 0,
+	}
+	if err = coin.save(); err != nil {
+		t.Fatal(err)
+	}
+	send := &Send{
+		Addr:   "MS43dMzRKfEs99Q931zFECfUhdvtWmbsPt",
+		Amount: 3 * params.Unit,
+	}
+	r, err := Create(&Options{Selector: LargestFirst}, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Selector != LargestFirst.Name() || r.Change < 0 || len(r.Tx.TxOut) != 2 {
+		t.Fatal("illegal result", r.Selector, r.Change)
+	}
+	change := r.Tx.TxOut[r.Change]
+	if bytes.Equal(change.Script, pkscript) {
+		t.Error("change must not go to the spent address")
+	}
+	if change.Value != 7*params.Unit-r.Fee {
+		t.Error("illegal value of change", change.Value, r.Fee)
+	}
+	if r.Fee != feeOf(coinsSize([]*Coin{coin}, r.Tx.TxOut), FeeRate()) {
+		t.Error("fee must be calculated from the size", r.Fee)
+	}
+	out, err := p2pkTtxout(send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Tx.TxOut[1-r.Change].Script, out.Script) {
+		t.Error("Change must index the change txout")
+	}
+	path := changePath(t, &change)
+	c, err := getCoin(r.Tx.Hash(), uint32(r.Change))
+	if err != nil || c != nil || coin.Locked() {
+		t.Fatal("the wallet must not be changed before commit", err)
+	}
+	if err = r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Commit(); err == nil {
+		t.Error("tx must not be committed twice")
+	}
+	c, err = getCoin(r.Tx.Hash(), uint32(r.Change))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.Value != change.Value || c.WatchOnly() {
+		t.Fatal("change is not recorded as a coin of the wallet")
+	}
+	for _, s := range spendableCoins(false) {
+		if bytes.Equal(s.TxHash, c.TxHash) {
+			t.Error("unconfirmed change must not be spendable")
+		}
+	}
+	checkFee(t, r.Tx, []*Coin{coin}, r.Fee)
+
+	if !coin.Locked() {
+		t.Error("spent coin must be locked")
+	}
+	if _, err = NewP2PK(send); err == nil {
+		t.Error("locked coin must not be selected")
+	}
+	b := GetBalance()
+	if b.Locked != coin.Value || b.Confirmed != 0 || b.Unconfirmed != change.Value {
+		t.Error("illegal balance", b)
+	}
+	if err = Abandon(r.Tx.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if coin.Locked() {
+		t.Error("coin must be released")
+	}
+	if c, err = getCoin(r.Tx.Hash(), uint32(r.Change)); err != nil || c != nil {
+		t.Error("change of abandoned tx must be removed")
+	}
+	if err = Abandon(r.Tx.Hash()); err == nil {
+		t.Error("abandoned tx is not pending")
+	}
+	r, err = Create(&Options{Selector: LargestFirst}, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := changePath(t, &r.Tx.TxOut[r.Change]); p.Index <= path.Index {
+		t.Error("change must go to a fresh key", p, path)
+	}
+
+	//the change less than dust is added to the fee.
+	send.Amount = 10*params.Unit - changelessFee([]*Coin{coin}) - 100
+	r, err = Create(nil, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Change != -1 || len(r.Tx.TxOut) != 1 {
+		t.Error("dust change must be omitted")
+	}
+	if r.Fee != changelessFee([]*Coin{coin})+100 {
+		t.Error("dust change must be added to the fee", r.Fee)
+	}
+	checkFee(t, r.Tx, []*Coin{coin}, r.Fee)
+	if err = r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	//a conflicting tx in a block releases the pending tx.
+	txid := r.Tx.Hash()
+	if _, err = GetPending(txid); err != nil {
+		t.Fatal(err)
+	}
+	conflict := msg.Tx{
+		Version: 1,
+		TxIn:    append([]msg.TxIn{}, r.Tx.TxIn...),
+		TxOut:   []msg.TxOut{{Value: 9 * params.Unit, Script: pkscript}},
+	}
+	if err = fillSign(&conflict, []*Coin{coin}); err != nil {
+		t.Fatal(err)
+	}
+	if err = Add(&conflict, params.Net.GenesisHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetPending(txid); err == nil {
+		t.Error("conflicted tx must be released")
+	}
+	if coin.Locked() {
+		t.Error("spent coin must not be locked")
+	}
+}
+
+func TestUnsigned(t *testing.T) {
+	del()
+	setup()
+	seed := bytes.Repeat([]byte{0x22}, 32)
+	k, err := key.NewMaster(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []uint32{44, params.Net.HDCoinType, 0} {
+		if k, err = k.Child(key.HardenedKeyStart + i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if k, err = k.Neuter(); err != nil {
+		t.Fatal(err)
+	}
+	if err = key.ImportXPub(k.String()); err != nil {
+		t.Fatal(err)
+	}
+	ext, err := k.Child(key.External)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek, err := ext.Child(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ek.Address()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hash := pub.Address()
+	coin := &Coin{
+		Pubkey:  pub.Serialize(),
+		TxHash:  bytes.Repeat([]byte{0x08}, 32),
+		Value:   10 * params.Unit,
+		Block:   params.Net.GenesisHash,
+		Script:  script.PayToPubKeyHash(hash),
+		TxIndex: 0,
+	}
+	if err = coin.save(); err != nil {
+		t.Fatal(err)
+	}
+	if !coin.WatchOnly() {
+		t.Fatal("coin is not watch-only")
+	}
+	send := &Send{
+		Addr:   "MS43dMzRKfEs99Q931zFECfUhdvtWmbsPt",
+		Amount: 3 * params.Unit,
+	}
+	u, err := NewUnsigned(send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Prevs) != 1 || u.Prevs[0].Value != coin.Value || !bytes.Equal(u.Prevs[0].Script, coin.Script) {
+		t.Error("illegal previous txouts", u.Prevs)
+	}
+	if !coin.Locked() {
+		t.Error("exported coin must be locked")
+	}
+	if _, err = NewUnsigned(send); err == nil {
+		t.Error("locked coin must not be exported twice")
+	}
+	var change *Coin
+	for i := range u.Tx.TxOut {
+		if c, errr := getCoin(u.Tx.Hash(), uint32(i)); errr == nil && c != nil {
+			change = c
+		}
+	}
+	if change == nil {
+		t.Fatal("no change")
+	}
+	cpub, err := key.NewPublicKey(change.Pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := key.PathOf(cpub)
+	if err != nil || !p.Watch || p.Change != key.Internal {
+		t.Error("change must go to the internal chain of the watch-only account", p, err)
+	}
+	if err = Abandon(u.Tx.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if coin.Locked() {
+		t.Error("coin must be released")
+	}
+}
+
+func TestSelectorFallback(t *testing.T) {
+	del()
+	setup()
+	pkey, err := key.FromWIF("T81eGkQ2nrQZGvkcSKCtV1tZJ4WrsKhRsBA1jCgyfMdDjmn5TwGn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Add(pkey)
+	pkscript, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []uint64{1, 2, 5} {
+		c := &Coin{
+			Pubkey:  pkey.PublicKey.Serialize(),
+			TxHash:  bytes.Repeat([]byte{0x30 + byte(i)}, 32),
+			Value:   v * params.Unit,
+			Block:   params.Net.GenesisHash,
+			Script:  pkscript,
+			TxIndex: 0,
 		}
+		if err = c.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := Create(nil, &Send{
+		Addr:   "MS43dMzRKfEs99Q931zFECfUhdvtWmbsPt",
+		Amount: params.Unit / 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Selector != LargestFirst.Name() || len(r.Tx.TxIn) != 1 {
+		t.Error("default selector must fall back to largest-first", r.Selector, len(r.Tx.TxIn))
 	}
 }
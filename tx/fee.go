@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+)
+
+//sigSize is the maximum size of a low-S DER signature with the hash type.
+const sigSize = 72
+
+var (
+	feeRate   = params.Fee
+	feeRateMu sync.RWMutex
+)
+
+//SetFeeRate sets the fee rate per kB of transactions created by the wallet.
+func SetFeeRate(rate uint64) error {
+	if rate < params.MinRelayFee {
+		return fmt.Errorf("fee rate %d is less than the minimum relay fee %d", rate, params.MinRelayFee)
+	}
+	feeRateMu.Lock()
+	defer feeRateMu.Unlock()
+	feeRate = rate
+	return nil
+}
+
+//FeeRate returns the fee rate per kB of transactions created by the wallet.
+func FeeRate() uint64 {
+	feeRateMu.RLock()
+	defer feeRateMu.RUnlock()
+	return feeRate
+}
+
+//feeOf returns the fee of a tx whose virtual size is size in the rate per kB.
+//It is never less than the minimum relay fee.
+func feeOf(size int, rate uint64) uint64 {
+	if rate < params.MinRelayFee {
+		rate = params.MinRelayFee
+	}
+	return (rate*uint64(size) + 999) / 1000
+}
+
+//vsizeOf returns the virtual size of mtx, i.e. its weight divided by 4.
+func vsizeOf(mtx *msg.Tx) (int, error) {
+	var full, stripped bytes.Buffer
+	if err := msg.Pack(&full, *mtx); err != nil {
+		return 0, err
+	}
+	if err := msg.Pack(&stripped, *mtx.Stripped()); err != nil {
+		return 0, err
+	}
+	return (stripped.Len()*3 + full.Len() + 3) / 4, nil
+}
+
+//p2pkhInputSize is the size of a txin which spends a P2PKH txout
+//with a compressed public key.
+const p2pkhInputSize = 32 + 4 + 1 + 1 + sigSize + 1 + 33 + 4
+
+//isDust returns true if the value of out is less than
+//the fee to spend it in the dust relay fee.
+func isDust(out *msg.TxOut) bool {
+	size := 8 + varIntSize(len(out.Script)) + len(out.Script) + p2pkhInputSize
+	return out.Value < feeOf(size, params.DustRelayFee)
+}
+
+//inputSize is the estimated size of a txin.
+type inputSize struct {
+	script  int
+	witness int
+}
+
+func varIntSize(n int) int {
+	switch {
+	case n < 0xfd:
+		return 1
+	case n <= 0xffff:
+		return 3
+	case n <= 0xffffffff:
+		return 5
+	}
+	return 9
+}
+
+//pushSize returns the size of the script which pushes n bytes.
+func pushSize(n int) int {
+	switch {
+	case n < 0x4c:
+		return 1 + n
+	case n <= 0xff:
+		return 2 + n
+	case n <= 0xffff:
+		return 3 + n
+	}
+	return 5 + n
+}
+
+//multisigInputSize returns the size of the scriptsig which spends
+//the P2SH m of n multisig whose redeem script is redeem.
+func multisigInputSize(m int, redeem []byte) inputSize {
+	return inputSize{
+		script: 1 + m*pushSize(sigSize) + pushSize(len(redeem)),
+	}
+}
+
+//inputSize estimates the size of the txin which spends the coin.
+func (c *Coin) inputSize() inputSize {
+	pubSize := len(c.Pubkey)
+	if pubSize == 20 {
+		//watched address, whose public key is unknown.
+		pubSize = 33
+	}
+	switch c.Ttype {
+	case ttypeP2PK:
+		return inputSize{script: pushSize(sigSize)}
+	case ttypeP2SH:
+		m := 1
+		if n, _, err := parseMultisig(c.Pubkey); err == nil {
+			m = n
+		}
+		return multisigInputSize(m, c.Pubkey)
+	case ttypeP2WPKH:
+		return inputSize{witness: 1 + pushSize(sigSize) + pushSize(33)}
+	case ttypeP2SHP2WPKH:
+		return inputSize{
+			script:  pushSize(22),
+			witness: 1 + pushSize(sigSize) + pushSize(33),
+		}
+	}
+	return inputSize{script: pushSize(sigSize) + pushSize(pubSize)}
+}
+
+//estimateSize returns the estimated virtual size of a signed tx
+//which has inputs of ins and txouts.
+func estimateSize(ins []inputSize, txouts []msg.TxOut) int {
+	base := 4 + varIntSize(len(ins)) + varIntSize(len(txouts)) + 4
+	var witness int
+	for _, in := range ins {
+		base += 32 + 4 + varIntSize(in.script) + in.script + 4
+		witness += in.witness
+	}
+	for _, out := range txouts {
+		base += 8 + varIntSize(len(out.Script)) + len(out.Script)
+	}
+	if witness > 0 {
+		//marker, flag and the number of items of inputs without witness.
+		witness += 2
+		for _, in := range ins {
+			if in.witness == 0 {
+				witness++
+			}
+		}
+	}
+	return (base*4 + witness + 3) / 4
+}
+
+//coinsSize returns the estimated virtual size of a signed tx which spends coins to txouts.
+func coinsSize(coins []*Coin, txouts []msg.TxOut) int {
+	ins := make([]inputSize, len(coins))
+	for i, c := range coins {
+		ins[i] = c.inputSize()
+	}
+	return estimateSize(ins, txouts)
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+)
+
+//vsize returns the virtual size of mtx.
+func vsize(t *testing.T, mtx *msg.Tx) int {
+	var full, stripped bytes.Buffer
+	if err := msg.Pack(&full, *mtx); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Pack(&stripped, *mtx.Stripped()); err != nil {
+		t.Fatal(err)
+	}
+	return (stripped.Len()*3 + full.Len() + 3) / 4
+}
+
+//changelessFee returns the fee of a tx which spends all coins to one P2PKH address.
+func changelessFee(coins []*Coin) uint64 {
+	out := msg.TxOut{Script: make([]byte, 25)}
+	return feeOf(coinsSize(coins, []msg.TxOut{out}), FeeRate())
+}
+
+//checkFee checks mtx which spends coins pays fee and its size is
+//not more than the estimated one.
+func checkFee(t *testing.T, mtx *msg.Tx, coins []*Coin, fee uint64) {
+	var in, out uint64
+	for _, c := range coins {
+		in += c.Value
+	}
+	for _, o := range mtx.TxOut {
+		out += o.Value
+	}
+	if in-out != fee {
+		t.Error("illegal fee", in-out, fee)
+	}
+	est := coinsSize(coins, mtx.TxOut)
+	if size := vsize(t, mtx); size > est || size < est-3 {
+		t.Error("illegal estimation of size", size, est)
+	}
+}
+
+func TestFee(t *testing.T) {
+	if feeOf(250, params.Fee) != params.Fee/4 {
+		t.Error("illegal fee", feeOf(250, params.Fee))
+	}
+	if feeOf(1, params.Fee) != params.Fee/1000 {
+		t.Error("fee must be rounded up", feeOf(1, params.Fee))
+	}
+	if feeOf(1000, 0) != params.MinRelayFee {
+		t.Error("fee must be more than the minimum relay fee")
+	}
+	if err := SetFeeRate(params.MinRelayFee - 1); err == nil {
+		t.Error("fee rate less than the minimum relay fee must be rejected")
+	}
+	if err := SetFeeRate(2 * params.Fee); err != nil {
+		t.Fatal(err)
+	}
+	if FeeRate() != 2*params.Fee {
+		t.Error("fee rate is not set")
+	}
+	if err := SetFeeRate(params.Fee); err != nil {
+		t.Fatal(err)
+	}
+	sizes := []struct {
+		in   inputSize
+		size int
+	}{
+		{inputSize{script: pushSize(sigSize) + pushSize(33)}, 10 + 148 + 34},
+		{inputSize{witness: 1 + pushSize(sigSize) + pushSize(33)}, 11 + 41 + 27 + 34},
+	}
+	for _, s := range sizes {
+		out := msg.TxOut{Script: make([]byte, 25)}
+		if size := estimateSize([]inputSize{s.in}, []msg.TxOut{out}); size != s.size {
+			t.Error("illegal size", size, s.size)
+		}
+	}
+}
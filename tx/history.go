@@ -0,0 +1,283 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/block"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/msg"
+)
+
+//Direction is the direction of funds in a transaction.
+type Direction byte
+
+//Directions of transactions.
+const (
+	//Incoming means the wallet received coins from others.
+	Incoming Direction = iota
+	//Outgoing means the wallet sent coins to others.
+	Outgoing
+	//Internal means the wallet sent coins only to itself.
+	Internal
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Incoming:
+		return "receive"
+	case Outgoing:
+		return "send"
+	case Internal:
+		return "internal"
+	}
+	return "unknown"
+}
+
+//History is a record of a transaction which concerns the wallet.
+type History struct {
+	TxID      []byte
+	Direction Direction
+	//Amount is the net amount the wallet got, i.e. Received - Spent.
+	Amount int64
+	//Received is the total value of outputs to the wallet.
+	Received uint64
+	//Spent is the total value of the wallet's coins spent by the tx.
+	Spent uint64
+	//Fee is the fee of the tx, which is known only when all inputs
+	//are from the wallet.
+	Fee uint64
+	//Addresses are addresses of counterparties, i.e. senders of incoming
+	//txs and recipients of outgoing txs.
+	Addresses []string
+	Block     []byte
+	Height    uint64
+	//Time is the timestamp of the block which confirmed the tx,
+	//or the unix time when the tx was first seen if it is unconfirmed.
+	Time int64
+	//Replaces is the txid of the tx which this tx replaced by fee bumping.
+	Replaces []byte
+	//ReplacedBy is the txid of the tx which replaced this tx by fee bumping.
+	ReplacedBy []byte
+
+	//Inputs is the number of inputs of the tx.
+	Inputs int
+	//OwnInputs is the number of inputs from the wallet whose values are known.
+	OwnInputs int
+	//Total is the total value of all outputs of the tx.
+	Total uint64
+}
+
+//update recalculates the derived fields of h.
+func (h *History) update() {
+	h.Amount = int64(h.Received) - int64(h.Spent)
+	switch {
+	case h.Spent == 0 && h.OwnInputs == 0:
+		h.Direction = Incoming
+	case len(h.Addresses) == 0:
+		h.Direction = Internal
+	default:
+		h.Direction = Outgoing
+	}
+	h.Fee = 0
+	if h.Inputs > 0 && h.OwnInputs == h.Inputs && h.Spent >= h.Total {
+		h.Fee = h.Spent - h.Total
+	}
+}
+
+//Query is a condition to search histories.
+type Query struct {
+	//Address selects histories whose counterparties include it if not empty.
+	Address string
+	//MinHeight and MaxHeight select histories within the block heights.
+	//MaxHeight=0 means no upper limit.
+	MinHeight uint64
+	MaxHeight uint64
+	//Offset is the number of histories to skip.
+	Offset int
+	//Limit is the max number of histories. 0 means no limit.
+	Limit int
+}
+
+func (q *Query) match(h *History) bool {
+	if h.Height < q.MinHeight || (q.MaxHeight > 0 && h.Height > q.MaxHeight) {
+		return false
+	}
+	if q.Address == "" {
+		return true
+	}
+	for _, a := range h.Addresses {
+		if a == q.Address {
+			return true
+		}
+	}
+	return false
+}
+
+//GetHistory returns the history of txid.
+func GetHistory(txid []byte) (*History, error) {
+	h := &History{}
+	err := wdb.View(func(tx *bolt.Tx) error {
+		_, err := db.Get(tx, "txhistory", txid, h)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("no history for tx " + behex.EncodeToString(txid))
+	}
+	return h, nil
+}
+
+//Histories returns histories which match q, sorted by height and time.
+func Histories(q *Query) ([]*History, error) {
+	var hs []*History
+	err := wdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("txhistory"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			h := &History{}
+			if err := db.B2v(v, h); err != nil {
+				return err
+			}
+			if q.match(h) {
+				hs = append(hs, h)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(hs, func(i, j int) bool {
+		if hs[i].Height != hs[j].Height {
+			return hs[i].Height < hs[j].Height
+		}
+		return hs[i].Time < hs[j].Time
+	})
+	if q.Offset >= len(hs) {
+		return nil, nil
+	}
+	hs = hs[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(hs) {
+		hs = hs[:q.Limit]
+	}
+	return hs, nil
+}
+
+//getHistory returns the history of txid in the db, or a new one if not found.
+func getHistory(tx *bolt.Tx, txid []byte) *History {
+	h := &History{}
+	if _, err := db.Get(tx, "txhistory", txid, h); err != nil {
+		return &History{
+			TxID: txid,
+			Time: time.Now().Unix(),
+		}
+	}
+	return h
+}
+
+func putHistory(tx *bolt.Tx, h *History) error {
+	h.update()
+	return db.Put(tx, "txhistory", h.TxID, h)
+}
+
+//pendingHistory returns the history of mtx created by the wallet, which spends
+//coins and sends the change at index, before mtx is seen in the network.
+//Block of the history is nil until mtx is added by Add.
+func pendingHistory(tx *bolt.Tx, mtx *msg.Tx, coins []*Coin, change int) *History {
+	h := getHistory(tx, mtx.Hash())
+	if h.Block != nil {
+		return h
+	}
+	h.Spent = 0
+	for _, c := range coins {
+		h.Spent += c.Value
+	}
+	h.OwnInputs = len(coins)
+	h.Inputs = len(mtx.TxIn)
+	h.Received = 0
+	h.Total = 0
+	h.Addresses = nil
+	for i, out := range mtx.TxOut {
+		h.Total += out.Value
+		if i == change {
+			h.Received += out.Value
+			continue
+		}
+		if adr := scriptAddress(out.Script); adr != "" {
+			h.Addresses = append(h.Addresses, adr)
+		}
+	}
+	return h
+}
+
+//addSpent adds value of a wallet coin which was spent by txid
+//to the history of txid.
+func addSpent(tx *bolt.Tx, txid []byte, value uint64) error {
+	h := getHistory(tx, txid)
+	h.Spent += value
+	h.OwnInputs++
+	return putHistory(tx, h)
+}
+
+//blockTime returns the timestamp of the header of the block hash,
+//or 0 if the header is unknown.
+func blockTime(hash []byte) int64 {
+	h, err := block.Header(hash)
+	if err != nil {
+		return 0
+	}
+	return int64(h.Timestamp)
+}
+
+//blockHeight returns the height of the block hash, or 0 if unknown.
+func blockHeight(hash []byte) uint64 {
+	b, err := block.LoadBlock(hash)
+	if err != nil {
+		return 0
+	}
+	return b.Height
+}
+
+//scriptAddress returns the address of the output script,
+//or empty string if the script is not a standard one.
+func scriptAddress(pkscript []byte) string {
+	a := key.ScriptAddress(pkscript)
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/block"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+)
+
+func TestHistory(t *testing.T) {
+	// MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G
+	addr := "0341573692e18d367df964ba1effc151c5952a6128a0f973cb5006b0151d32e517"
+
+	stx := []string{
+		//coinbase->MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G,50mona
+		"01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff2703a51f04062f503253482f049434515408f829e69b910100000d2f7374726174756d506f6f6c2f000000000100f2052a010000001976a914b7c62137082c0846943c1b8d1c3eab628baa156f88ac00000000",
+		// MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G -> 50mona
+		"010000000188fa5c97be66845170db81a582888c55b24ca78943314f0a2d63c0b252854b4b000000006b483045022100a2e4bdc593bacb5918ac06dd6a718087c202dd7b8a8f5b62a243320c79c0629c022018e857dcdaa1afada0ebdf9b3f1086a95a70852d64fafd9d5233815392e5f81801210341573692e18d367df964ba1effc151c5952a6128a0f973cb5006b0151d32e517ffffffff04e2d10e06000000001976a914872455664fee9e4e9b5985f7ff09a3dfbd73bae688acaff98441000000001976a91431f10038a4debd33ca2d1c675575dc419b4b5fa288ac3a6eff6b000000001976a9146c1d53b7b5c18f34ad012c15439e4a0deb7c6b7988ac35b87276000000001976a914da2f111a4e3e2e88947577ae06b8e31958c887e788ac00000000",
+	}
+	txs := maketx(stx)
+	for _, order := range [][]int{{0, 1}, {1, 0}} {
+		del()
+		addpubkey(addr)
+		for _, i := range order {
+			if err := Add(txs[i], make([]byte, 32)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		coins, err := GetCoins(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(coins) != 0 {
+			t.Fatal("spent coin remains", order, len(coins))
+		}
+		h, err := GetHistory(txs[0].Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Direction != Incoming || h.Amount != 50*params.Unit {
+			t.Error("invalid incoming history", order, h.Direction, h.Amount)
+		}
+		h, err = GetHistory(txs[1].Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Direction != Outgoing || h.Amount != -50*params.Unit || h.Spent != 50*params.Unit {
+			t.Error("invalid outgoing history", order, h.Direction, h.Amount)
+		}
+		if h.OwnInputs != 1 || h.Fee != 0 || len(h.Addresses) != 4 {
+			t.Error("invalid outgoing history", order, h.OwnInputs, h.Fee, h.Addresses)
+		}
+		hs, err := Histories(&Query{Address: h.Addresses[0]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hs) != 1 {
+			t.Error("invalid number of histories", len(hs))
+		}
+		hs, err = Histories(&Query{Offset: 1, Limit: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hs) != 1 {
+			t.Error("invalid number of histories", len(hs))
+		}
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	// MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G
+	addr := "0341573692e18d367df964ba1effc151c5952a6128a0f973cb5006b0151d32e517"
+
+	stx := []string{
+		//coinbase->MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G,50mona
+		"01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff2703a51f04062f503253482f049434515408f829e69b910100000d2f7374726174756d506f6f6c2f000000000100f2052a010000001976a914b7c62137082c0846943c1b8d1c3eab628baa156f88ac00000000",
+		// MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G -> 50mona
+		"010000000188fa5c97be66845170db81a582888c55b24ca78943314f0a2d63c0b252854b4b000000006b483045022100a2e4bdc593bacb5918ac06dd6a718087c202dd7b8a8f5b62a243320c79c0629c022018e857dcdaa1afada0ebdf9b3f1086a95a70852d64fafd9d5233815392e5f81801210341573692e18d367df964ba1effc151c5952a6128a0f973cb5006b0151d32e517ffffffff04e2d10e06000000001976a914872455664fee9e4e9b5985f7ff09a3dfbd73bae688acaff98441000000001976a91431f10038a4debd33ca2d1c675575dc419b4b5fa288ac3a6eff6b000000001976a9146c1d53b7b5c18f34ad012c15439e4a0deb7c6b7988ac35b87276000000001976a914da2f111a4e3e2e88947577ae06b8e31958c887e788ac00000000",
+	}
+	txs := maketx(stx)
+	del()
+	addpubkey(addr)
+	blocks := [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)}
+	for i, tx := range txs {
+		if err := Add(tx, blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, n := range []int{1, 0} {
+		err := wdb.Update(func(tx *bolt.Tx) error {
+			return disconnect(tx, &block.Block{Hash: blocks[1-i]})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		coins, err := GetCoins(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(coins) != n {
+			t.Error("invalid number of coins", len(coins))
+		}
+		if _, err := GetHistory(txs[1-i].Hash()); err == nil {
+			t.Error("history of disconnected tx remains")
+		}
+	}
+}
+
+func TestHistoryTime(t *testing.T) {
+	// MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G
+	addr := "0341573692e18d367df964ba1effc151c5952a6128a0f973cb5006b0151d32e517"
+	stx := []string{
+		//coinbase->MQesEqAZNxeNNHS2XDNy23ozchyt1PXX2G,50mona
+		"01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff2703a51f04062f503253482f049434515408f829e69b910100000d2f7374726174756d506f6f6c2f000000000100f2052a010000001976a914b7c62137082c0846943c1b8d1c3eab628baa156f88ac00000000",
+	}
+	txs := maketx(stx)
+	del()
+	addpubkey(addr)
+
+	//unconfirmed tx has the time when it was first seen.
+	now := time.Now().Unix()
+	if err := Add(txs[0], make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	h, err := GetHistory(txs[0].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Time < now || h.Time > time.Now().Unix() {
+		t.Error("invalid time of unconfirmed tx", h.Time)
+	}
+
+	//confirmed tx has the timestamp of the block.
+	hash := bytes.Repeat([]byte{7}, 32)
+	hdr := msg.HBlockHeader{
+		Version:   2,
+		Prev:      make([]byte, 32),
+		Merkle:    make([]byte, 32),
+		Timestamp: 1400000000,
+		Nonce:     make([]byte, 4),
+	}
+	buf := bytes.NewBuffer(make([]byte, 8+32))
+	if err = msg.Pack(buf, hdr); err != nil {
+		t.Fatal(err)
+	}
+	err = wdb.Update(func(tx *bolt.Tx) error {
+		return db.Put(tx, "block", hash, buf.Bytes())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Add(txs[0], hash); err != nil {
+		t.Fatal(err)
+	}
+	if h, err = GetHistory(txs[0].Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if h.Time != 1400000000 {
+		t.Error("time must be the timestamp of the block", h.Time)
+	}
+}
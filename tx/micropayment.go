@@ -37,6 +37,7 @@ import (
 	"github.com/monarj/wallet/key"
 	"github.com/monarj/wallet/msg"
 	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
 	"golang.org/x/crypto/ripemd160"
 )
 
@@ -90,25 +91,35 @@ func NewMicroPayee(payer []byte, payee *key.PrivateKey, amount uint64) (*MicroPa
 	}, nil
 }
 
+//fee returns the fee of txs which spend the bond to the payer and the payee.
+//The fee is calculated in the default fee rate, not in the rate of the wallet,
+//because the payer and the payee must create the same tx.
+func (m *PubInfo) fee() uint64 {
+	txouts := make([]msg.TxOut, len(m.Pubs))
+	for i, p := range m.Pubs {
+		_, h := p.Address()
+		txouts[i].Script = script.PayToPubKeyHash(h)
+	}
+	in := multisigInputSize(int(m.M), m.redeemScript())
+	return feeOf(estimateSize([]inputSize{in}, txouts), params.Fee)
+}
+
 func sendstruct(m *PubInfo, amount uint64) ([]*Send, error) {
 	payer, _ := m.Pubs[0].Address()
 	payee, _ := m.Pubs[1].Address()
+	fee := m.fee()
 	sends := make([]*Send, 0, 2)
 	switch {
-	case m.Amount-params.Fee-amount < 0:
+	case m.Amount < fee || m.Amount-fee < amount:
 		return nil, errors.New("negative amount for payer")
-	case m.Amount-params.Fee-amount == 0:
+	case m.Amount-fee-amount == 0:
 	default:
 		sends = append(sends, &Send{
 			Addr:   payer,
-			Amount: m.Amount - params.Fee - amount,
+			Amount: m.Amount - fee - amount,
 		})
 	}
-	switch {
-	case amount < 0:
-		return nil, errors.New("negative amount for payee")
-	case amount == 0:
-	default:
+	if amount > 0 {
 		sends = append(sends, &Send{
 			Addr:   payee,
 			Amount: amount,
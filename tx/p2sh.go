@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"errors"
+
+	"github.com/monarj/wallet/base58check"
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
+)
+
+//Types of scripts of coins.
+const (
+	ttypeP2PKH      = byte(0)
+	ttypeP2PK       = byte(1)
+	ttypeP2SH       = byte(2)
+	ttypeP2WPKH     = byte(3)
+	ttypeP2SHP2WPKH = byte(4)
+)
+
+//AddMultisig registers the redeem script of m of n multisig by pubs,
+//so that coins to its P2SH address are tracked in the wallet.
+//It returns the address and the redeem script.
+func AddMultisig(m byte, pubs []*key.PublicKey) (string, []byte, error) {
+	if m == 0 || len(pubs) == 0 || int(m) > len(pubs) || len(pubs) > 16 {
+		return "", nil, errors.New("illegal number of keys for multisig")
+	}
+	p := &PubInfo{
+		Pubs: pubs,
+		M:    m,
+	}
+	redeem := p.redeemScript()
+	hash, err := key.AddRedeemScript(redeem)
+	if err != nil {
+		return "", nil, err
+	}
+	return base58check.Encode(params.Net.P2SHHeader, hash), redeem, nil
+}
+
+//parseMultisig returns the number of required signatures and
+//public keys of the multisig redeem script.
+func parseMultisig(redeem []byte) (int, []*key.PublicKey, error) {
+	t := script.Classify(redeem)
+	if t.Class != script.Multisig {
+		return 0, nil, errors.New("not a multisig script")
+	}
+	pubs := make([]*key.PublicKey, len(t.Data))
+	for i, d := range t.Data {
+		var err error
+		if pubs[i], err = key.NewPublicKey(d); err != nil {
+			return 0, nil, err
+		}
+	}
+	return t.M, pubs, nil
+}
+
+//canSign returns true if the wallet has enough private keys
+//to spend a coin of the redeem script.
+func canSign(redeem []byte) bool {
+	m, pubs, err := parseMultisig(redeem)
+	if err != nil {
+		return false
+	}
+	for _, pub := range pubs {
+		if key.Has(pub) && !key.IsWatchOnly(pub) {
+			m--
+		}
+	}
+	return m <= 0
+}
+
+//p2shScriptSig returns the scriptsig which spends a coin of the multisig
+//redeem script with signatures of hash by private keys in the wallet.
+func p2shScriptSig(hash []byte, redeem []byte) ([]byte, error) {
+	m, pubs, err := parseMultisig(redeem)
+	if err != nil {
+		return nil, err
+	}
+	scr := []byte{script.Op0}
+	for _, pub := range pubs {
+		if m == 0 {
+			break
+		}
+		priv, err := key.Find(pub)
+		if err == key.ErrLocked {
+			return nil, err
+		}
+		if err != nil {
+			continue
+		}
+		sig, err := priv.Sign(hash)
+		if err != nil {
+			return nil, err
+		}
+		scr = append(scr, script.PushData(append(sig, byte(script.SigHashAll)))...)
+		m--
+	}
+	if m > 0 {
+		return nil, errors.New("private keys are not enough to sign")
+	}
+	return append(scr, script.PushData(redeem)...), nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
+)
+
+func TestP2SH(t *testing.T) {
+	del()
+	setup()
+	var pubs []*key.PublicKey
+	for i, wif := range []string{
+		"T81eGkQ2nrQZGvkcSKCtV1tZJ4WrsKhRsBA1jCgyfMdDjmn5TwGn",
+		"T4MzbNi83oaNzi8Yid22ZeNqHzaFhLqQkKmkffuQ58jR4ytz9QG2",
+		"T9QEmRobyTDTJe4qzSEu2mD1SMu6Wtzun6xkawnwRpBX5brimeCN",
+	} {
+		pkey, err := key.FromWIF(wif)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			if err = key.Add(pkey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pubs = append(pubs, pkey.PublicKey)
+	}
+	adr, redeem, err := AddMultisig(2, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := key.Hash160(redeem)
+	if !key.BloomFilter().Match(hash) {
+		t.Error("script hash is not in the bloom filter")
+	}
+
+	fund := &msg.Tx{
+		Version: 1,
+		TxIn: []msg.TxIn{
+			{
+				Hash:   bytes.Repeat([]byte{0x01}, 32),
+				Script: []byte{script.Op1},
+				Seq:    math.MaxUint32,
+			},
+		},
+		TxOut: []msg.TxOut{
+			{
+				Value:  10 * params.Unit,
+				Script: script.PayToScriptHash(hash),
+			},
+		},
+	}
+	if err = Add(fund, params.Net.GenesisHash); err != nil {
+		t.Fatal(err)
+	}
+	coins := SortedCoins()
+	if len(coins) != 1 {
+		t.Fatal("P2SH coin is not tracked", len(coins))
+	}
+	c := coins[0]
+	if c.Ttype != ttypeP2SH || !bytes.Equal(c.RedeemScript(), redeem) {
+		t.Error("illegal coin type or redeem script")
+	}
+	if c.Address() != adr || c.WatchOnly() {
+		t.Error("illegal address or spendability of P2SH coin", c.Address())
+	}
+
+	fee := changelessFee(coins)
+	spend, err := NewP2PK(&Send{
+		Addr:   "MS43dMzRKfEs99Q931zFECfUhdvtWmbsPt",
+		Amount: 10*params.Unit - fee,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFee(t, spend, coins, fee)
+	data, err := script.PushedData(spend.TxIn[0].Script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4 || len(data[0]) != 0 || !bytes.Equal(data[3], redeem) {
+		t.Fatal("illegal scriptsig of P2SH")
+	}
+	if err = script.VerifyInput(spend, 0, &fund.TxOut[0], script.StandardFlags); err != nil {
+		t.Error("illegal signatures", err)
+	}
+
+	if err = Add(spend, params.Net.GenesisHash); err != nil {
+		t.Fatal(err)
+	}
+	if len(SortedCoins()) != 0 {
+		t.Error("spent P2SH coin remains")
+	}
+	if _, err = GetPending(spend.Hash()); err == nil {
+		t.Error("confirmed tx must not be pending")
+	}
+}
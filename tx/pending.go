@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/msg"
+)
+
+//Pending is a tx created by the wallet which is not in a block yet.
+//Coins spent by the tx are locked in "locked" bucket so that
+//they are not selected again until the tx is abandoned or conflicted.
+type Pending struct {
+	TxID []byte
+	//Raw is the serialized tx with witness.
+	Raw []byte
+	//Time is the unix time when the tx was created.
+	Time int64
+	//Replaces is the txid of the tx which this tx replaced by BumpFee.
+	Replaces []byte
+}
+
+//Tx returns the pending tx.
+func (p *Pending) Tx() (*msg.Tx, error) {
+	mtx := &msg.Tx{}
+	if err := msg.Unpack(bytes.NewBuffer(p.Raw), mtx); err != nil {
+		return nil, err
+	}
+	return mtx, nil
+}
+
+//putPending records mtx as pending and locks coins spent by mtx in the db tx.
+//replaces is the txid of the tx replaced by mtx, or nil.
+func putPending(tx *bolt.Tx, mtx *msg.Tx, replaces []byte) error {
+	var raw bytes.Buffer
+	if err := msg.Pack(&raw, *mtx); err != nil {
+		return err
+	}
+	p := &Pending{
+		TxID:     mtx.Hash(),
+		Raw:      raw.Bytes(),
+		Time:     time.Now().Unix(),
+		Replaces: replaces,
+	}
+	if err := db.Put(tx, "pendingtx", p.TxID, p); err != nil {
+		return err
+	}
+	for _, in := range mtx.TxIn {
+		if err := db.Put(tx, "locked", db.ToKey(in.Hash, in.Index), p.TxID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//GetPending returns the pending tx whose txid is txid.
+func GetPending(txid []byte) (*Pending, error) {
+	p := &Pending{}
+	err := wdb.View(func(tx *bolt.Tx) error {
+		_, err := db.Get(tx, "pendingtx", txid, p)
+		return err
+	})
+	if err != nil {
+		return nil, errors.New("tx " + behex.EncodeToString(txid) + " is not pending")
+	}
+	return p, nil
+}
+
+//Pendings returns all pending txs.
+func Pendings() ([]*Pending, error) {
+	var ps []*Pending
+	err := wdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("pendingtx"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			p := &Pending{}
+			if err := db.B2v(v, p); err != nil {
+				return err
+			}
+			ps = append(ps, p)
+			return nil
+		})
+	})
+	return ps, err
+}
+
+//lockedBy returns the txid of the pending tx which spends the coin,
+//or nil if the coin is not locked.
+func lockedBy(tx *bolt.Tx, hash []byte, index uint32) []byte {
+	v, err := db.Get(tx, "locked", db.ToKey(hash, index), nil)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+//Locked returns true if the coin is spent by a pending tx.
+func (c *Coin) Locked() bool {
+	locked := false
+	err := wdb.View(func(tx *bolt.Tx) error {
+		locked = lockedBy(tx, c.TxHash, c.TxIndex) != nil
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return locked
+}
+
+//Abandon releases coins locked by the pending tx whose txid is txid,
+//and removes its change coins.
+//The tx must not be broadcasted, or it may be confirmed later.
+func Abandon(txid []byte) error {
+	return wdb.Batch(func(tx *bolt.Tx) error {
+		if !db.HasKey(tx, "pendingtx", txid) {
+			return errors.New("tx " + behex.EncodeToString(txid) + " is not pending")
+		}
+		return release(tx, txid)
+	})
+}
+
+//release removes the pending tx txid, unlocks coins spent by it
+//and removes its unconfirmed change coins.
+func release(tx *bolt.Tx, txid []byte) error {
+	p := &Pending{}
+	if _, err := db.Get(tx, "pendingtx", txid, p); err != nil {
+		return nil
+	}
+	mtx, err := p.Tx()
+	if err != nil {
+		return err
+	}
+	for _, in := range mtx.TxIn {
+		k := db.ToKey(in.Hash, in.Index)
+		if v, err := db.Get(tx, "locked", k, nil); err == nil && bytes.Equal(v, txid) {
+			if err := db.Del(tx, "locked", k); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range mtx.TxOut {
+		k := db.ToKey(txid, uint32(i))
+		v, err := db.Get(tx, "coin", k, nil)
+		if err != nil {
+			continue
+		}
+		c := &Coin{}
+		if err := msg.Unpack(bytes.NewBuffer(v), c); err != nil {
+			return err
+		}
+		if !bytes.Equal(c.Block, make([]byte, 32)) {
+			continue
+		}
+		if err := db.Del(tx, "coin", k); err != nil {
+			return err
+		}
+	}
+	log.Println("released pending tx", behex.EncodeToString(txid))
+	return db.Del(tx, "pendingtx", txid)
+}
+
+//resolvePending removes mtx from pending txs if it was created by the wallet,
+//and releases other pending txs which conflict with mtx.
+func resolvePending(tx *bolt.Tx, mtx *msg.Tx) error {
+	txid := mtx.Hash()
+	var conflicts [][]byte
+	for _, in := range mtx.TxIn {
+		k := db.ToKey(in.Hash, in.Index)
+		v, err := db.Get(tx, "locked", k, nil)
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(v, txid) {
+			log.Println("pending tx", behex.EncodeToString(v), "conflicts with",
+				behex.EncodeToString(txid))
+			conflicts = append(conflicts, v)
+			continue
+		}
+		if err := db.Del(tx, "locked", k); err != nil {
+			return err
+		}
+	}
+	for _, c := range conflicts {
+		if err := release(tx, c); err != nil {
+			return err
+		}
+	}
+	if !db.HasKey(tx, "pendingtx", txid) {
+		return nil
+	}
+	return db.Del(tx, "pendingtx", txid)
+}
+
+//Balance is the balance of the wallet.
+//Coins spent by pending txs are not included in Confirmed and Unconfirmed.
+type Balance struct {
+	//Confirmed is the total value of confirmed coins.
+	Confirmed uint64
+	//Unconfirmed is the total value of coins which are not confirmed,
+	//including change of pending txs.
+	Unconfirmed uint64
+	//Locked is the total value of coins spent by pending txs.
+	Locked uint64
+	//WatchOnly is the total value of watch-only coins.
+	WatchOnly uint64
+}
+
+//GetBalance returns the balance of the wallet.
+func GetBalance() *Balance {
+	b := &Balance{}
+	for _, c := range SortedCoins() {
+		switch {
+		case c.WatchOnly():
+			b.WatchOnly += c.Value
+		case c.Locked():
+			b.Locked += c.Value
+		case c.confirmed():
+			b.Confirmed += c.Value
+		default:
+			b.Unconfirmed += c.Value
+		}
+	}
+	return b
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/boltdb/bolt"
+	"github.com/monarj/wallet/behex"
+	"github.com/monarj/wallet/db"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
+)
+
+//SeqReplaceable is the sequence number of txins which signals
+//that the tx can be replaced by fee (BIP125).
+const SeqReplaceable = math.MaxUint32 - 2
+
+//Replaceable returns true if mtx signals replaceability by BIP125.
+func Replaceable(mtx *msg.Tx) bool {
+	for _, in := range mtx.TxIn {
+		if in.Seq < math.MaxUint32-1 {
+			return true
+		}
+	}
+	return false
+}
+
+//Bumped is a replacement tx created by BumpFee.
+type Bumped struct {
+	Result
+	//Replaces is the txid of the replaced tx.
+	Replaces []byte
+	//OrigFee is the fee of the replaced tx.
+	OrigFee uint64
+
+	orig       *msg.Tx
+	origCoins  []*Coin
+	origChange int
+}
+
+//Commit releases the replaced tx, records the replacement as pending
+//and both txs with the link between them to the history at once.
+//It should be called after the replacement is broadcasted,
+//so that the replaced tx stays pending if broadcasting fails.
+func (b *Bumped) Commit() error {
+	return wdb.Batch(func(tx *bolt.Tx) error {
+		if !db.HasKey(tx, "pendingtx", b.Replaces) {
+			return errors.New("tx " + behex.EncodeToString(b.Replaces) + " is not pending")
+		}
+		if err := release(tx, b.Replaces); err != nil {
+			return err
+		}
+		if err := b.sel.commit(tx, b.Tx, b.Change, b.Replaces); err != nil {
+			return err
+		}
+		oh := pendingHistory(tx, b.orig, b.origCoins, b.origChange)
+		oh.ReplacedBy = b.Tx.Hash()
+		if err := putHistory(tx, oh); err != nil {
+			return err
+		}
+		h := pendingHistory(tx, b.Tx, b.sel.coins, b.Change)
+		h.Replaces = b.Replaces
+		return putHistory(tx, h)
+	})
+}
+
+//BumpFee creates a tx which replaces the pending tx txid, spending the same
+//coins to the same txouts in the fee rate per kB.
+//The fee is paid by reducing the change, or by adding coins if the change
+//is not enough. The wallet is not changed until Commit is called.
+func BumpFee(txid []byte, rate uint64) (*Bumped, error) {
+	p, err := GetPending(txid)
+	if err != nil {
+		return nil, err
+	}
+	orig, err := p.Tx()
+	if err != nil {
+		return nil, err
+	}
+	if !Replaceable(orig) {
+		return nil, errors.New("the tx is not BIP125-replaceable")
+	}
+	coins := make([]*Coin, len(orig.TxIn))
+	var amount uint64
+	for i, in := range orig.TxIn {
+		if coins[i], err = getCoin(in.Hash, in.Index); err != nil {
+			return nil, err
+		}
+		if coins[i] == nil {
+			return nil, errors.New("a coin spent by the tx is not in the wallet")
+		}
+		amount += coins[i].Value
+	}
+	t := &Target{FeeRate: rate}
+	var change *msg.TxOut
+	var changePub []byte
+	var total uint64
+	origChange := -1
+	for i, out := range orig.TxOut {
+		total += out.Value
+		if c, errr := getCoin(txid, uint32(i)); errr == nil && c != nil && change == nil {
+			change = &msg.TxOut{Value: out.Value, Script: out.Script}
+			changePub = c.Pubkey
+			origChange = i
+			continue
+		}
+		t.TxOuts = append(t.TxOuts, out)
+		t.Amount += out.Value
+	}
+	if amount < total {
+		return nil, errors.New("the tx spends more than its coins")
+	}
+	origFee := amount - total
+	origSize, err := vsizeOf(orig)
+	if err != nil {
+		return nil, err
+	}
+	if feeOf(origSize, rate) <= origFee {
+		return nil, fmt.Errorf("fee rate must be more than the original rate %d", origFee*1000/uint64(origSize))
+	}
+	//BIP125 requires the replacement to pay its own relay fee in addition.
+	fee := func(cs []*Coin, change bool) uint64 {
+		size := coinsSize(cs, t.TxOuts)
+		if change {
+			size += changeSize
+		}
+		f := feeOf(size, rate)
+		if min := origFee + feeOf(size, params.MinRelayFee); f < min {
+			f = min
+		}
+		return f
+	}
+
+	sel, err := fund(coins, t.Amount, fee, false, "bumpfee")
+	if err != nil {
+		return nil, err
+	}
+	if sel.change != nil {
+		if change == nil {
+			pub, errr := changeKey(nil, false)
+			if errr != nil {
+				return nil, errr
+			}
+			_, hash := pub.Address()
+			change = &msg.TxOut{Script: script.PayToPubKeyHash(hash)}
+			changePub = pub.Serialize()
+		}
+		sel.change.Script = change.Script
+		sel.changeKey = changePub
+	}
+	for i := range sel.txins {
+		sel.txins[i].Seq = SeqReplaceable
+		if i < len(orig.TxIn) {
+			sel.txins[i].Seq = orig.TxIn[i].Seq
+		}
+	}
+	txouts, index, err := sel.addChange(append([]msg.TxOut{}, t.TxOuts...))
+	if err != nil {
+		return nil, err
+	}
+	result := msg.Tx{
+		Version:  orig.Version,
+		TxIn:     sel.txins,
+		TxOut:    txouts,
+		Locktime: orig.Locktime,
+	}
+	if err = fillSign(&result, sel.coins); err != nil {
+		return nil, err
+	}
+	return &Bumped{
+		Result: Result{
+			Tx:       &result,
+			Fee:      sel.fee,
+			Selector: sel.selector,
+			Change:   index,
+			sel:      sel,
+		},
+		Replaces:   txid,
+		OrigFee:    origFee,
+		orig:       orig,
+		origCoins:  coins,
+		origChange: origChange,
+	}, nil
+}
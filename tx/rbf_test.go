@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/params"
+)
+
+func TestBumpFee(t *testing.T) {
+	del()
+	setup()
+	pkey, err := key.FromWIF("T81eGkQ2nrQZGvkcSKCtV1tZJ4WrsKhRsBA1jCgyfMdDjmn5TwGn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Add(pkey)
+	pkscript, err := hex.DecodeString("76a914d94987ba89c258372030bc9d610f89547757896488ac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCoin := func(b byte, v uint64) *Coin {
+		c := &Coin{
+			Pubkey:  pkey.PublicKey.Serialize(),
+			TxHash:  bytes.Repeat([]byte{b}, 32),
+			Value:   v,
+			Block:   params.Net.GenesisHash,
+			Script:  pkscript,
+			TxIndex: 0,
+		}
+		if err = c.save(); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	coin := newCoin(0x03, 10*params.Unit)
+	send := &Send{
+		Addr:   "MS43dMzRKfEs99Q931zFECfUhdvtWmbsPt",
+		Amount: 3 * params.Unit,
+	}
+	rate := FeeRate()
+
+	r, err := Create(nil, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if Replaceable(r.Tx) {
+		t.Error("tx must not be replaceable without the option")
+	}
+	if _, err = BumpFee(r.Tx.Hash(), 5*rate); err == nil {
+		t.Error("non-replaceable tx must not be bumped")
+	}
+	if err = Abandon(r.Tx.Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	//the fee is paid by reducing the change.
+	r, err = Create(&Options{Replaceable: true}, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Replaceable(r.Tx) {
+		t.Fatal("tx must be replaceable")
+	}
+	if err = r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	txid := r.Tx.Hash()
+	if _, err = BumpFee(txid, rate); err == nil {
+		t.Error("fee rate must be increased")
+	}
+	b, err := BumpFee(txid, 5*rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetPending(txid); err != nil {
+		t.Error("replaced tx must stay pending until the replacement is committed")
+	}
+	if _, err = GetPending(b.Tx.Hash()); err == nil {
+		t.Error("replacement must not be pending before commit")
+	}
+	if err = b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Commit(); err == nil {
+		t.Error("replacement must not be committed twice")
+	}
+	if b.OrigFee != r.Fee || b.Fee <= r.Fee || !bytes.Equal(b.Replaces, txid) {
+		t.Error("illegal bumped result", b.OrigFee, b.Fee, r.Fee)
+	}
+	if len(b.Tx.TxIn) != 1 || !bytes.Equal(b.Tx.TxIn[0].Hash, coin.TxHash) || !Replaceable(b.Tx) {
+		t.Error("replacement must spend the same coin")
+	}
+	if b.Change < 0 || b.Tx.TxOut[b.Change].Value != r.Tx.TxOut[r.Change].Value-(b.Fee-r.Fee) {
+		t.Error("fee must be paid by the change")
+	}
+	if !bytes.Equal(b.Tx.TxOut[b.Change].Script, r.Tx.TxOut[r.Change].Script) {
+		t.Error("change must go to the same address")
+	}
+	checkFee(t, b.Tx, []*Coin{coin}, b.Fee)
+	if _, err = GetPending(txid); err == nil {
+		t.Error("replaced tx must be released")
+	}
+	if c, errr := getCoin(txid, uint32(r.Change)); errr != nil || c != nil {
+		t.Error("change of replaced tx must be removed")
+	}
+	p, err := GetPending(b.Tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Replaces, txid) {
+		t.Error("replacement must record the replaced tx")
+	}
+	if !coin.Locked() {
+		t.Error("coin must be locked by the replacement")
+	}
+	oh, err := GetHistory(txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(oh.ReplacedBy, b.Tx.Hash()) || oh.Fee != r.Fee {
+		t.Error("history must record the replaced tx", oh.Fee, r.Fee)
+	}
+	h, err := GetHistory(b.Tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h.Replaces, txid) || h.Fee != b.Fee || h.Spent != coin.Value {
+		t.Error("history must record the replacement", h.Fee, b.Fee, h.Spent)
+	}
+	if err = Add(b.Tx, params.Net.GenesisHash); err != nil {
+		t.Fatal(err)
+	}
+	h, err = GetHistory(b.Tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h.Replaces, txid) || h.Spent != coin.Value || h.OwnInputs != 1 {
+		t.Error("history must not count spent coins twice", h.Spent, h.OwnInputs)
+	}
+
+	//coins are added if the tx has no change.
+	coin2 := newCoin(0x04, 20*params.Unit)
+	send.Amount = coin2.Value - changelessFee([]*Coin{coin2}) - 100
+	r, err = Create(&Options{Selector: LargestFirst, Replaceable: true}, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Change != -1 || len(r.Tx.TxIn) != 1 {
+		t.Fatal("tx must be changeless", r.Change)
+	}
+	if err = r.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	b, err = BumpFee(r.Tx.Hash(), 5*rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Tx.TxIn) != 2 || !bytes.Equal(b.Tx.TxIn[0].Hash, coin2.TxHash) || b.Change < 0 {
+		t.Fatal("a coin and change must be added")
+	}
+	coin3, err := getCoin(b.Tx.TxIn[1].Hash, b.Tx.TxIn[1].Index)
+	if err != nil || coin3 == nil {
+		t.Fatal("added coin is not in the wallet", err)
+	}
+	if b.Tx.TxOut[1-b.Change].Value != send.Amount {
+		t.Error("txout must not be changed")
+	}
+	checkFee(t, b.Tx, []*Coin{coin2, coin3}, b.Fee)
+	if !coin3.Locked() {
+		t.Error("added coin must be locked")
+	}
+	if _, err = BumpFee(b.Tx.Hash(), 1000000*rate); err == nil {
+		t.Error("shortage of coins must be an error")
+	} else if _, ok := err.(*ShortageError); !ok {
+		t.Error("shortage of coins must be ShortageError", err)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+	"github.com/monarj/wallet/script"
+)
+
+func TestSegwit(t *testing.T) {
+	del()
+	setup()
+	pkey, err := key.FromWIF("T81eGkQ2nrQZGvkcSKCtV1tZJ4WrsKhRsBA1jCgyfMdDjmn5TwGn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = key.Add(pkey); err != nil {
+		t.Fatal(err)
+	}
+	_, pubhash := pkey.PublicKey.Address()
+	wadr, err := pkey.PublicKey.WitnessAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	padr, err := pkey.PublicKey.P2SHWitnessAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, shash := key.P2SHWitnessAddress(pubhash)
+	if !key.BloomFilter().Match(shash) {
+		t.Error("P2SH-P2WPKH script hash is not in the bloom filter")
+	}
+
+	fund := &msg.Tx{
+		Version: 1,
+		TxIn: []msg.TxIn{
+			{
+				Hash:   bytes.Repeat([]byte{0x02}, 32),
+				Script: []byte{script.Op1},
+				Seq:    math.MaxUint32,
+			},
+		},
+		TxOut: []msg.TxOut{
+			{
+				Value:  3 * params.Unit,
+				Script: script.PayToWitness(0, pubhash),
+			},
+			{
+				Value:  5 * params.Unit,
+				Script: script.PayToScriptHash(shash),
+			},
+		},
+	}
+	if err = Add(fund, params.Net.GenesisHash); err != nil {
+		t.Fatal(err)
+	}
+	coins := SortedCoins()
+	if len(coins) != 2 {
+		t.Fatal("segwit coins are not tracked", len(coins))
+	}
+	if coins[0].Ttype != ttypeP2WPKH || coins[0].Address() != wadr {
+		t.Error("illegal P2WPKH coin", coins[0].Address())
+	}
+	if coins[1].Ttype != ttypeP2SHP2WPKH || coins[1].Address() != padr {
+		t.Error("illegal P2SH-P2WPKH coin", coins[1].Address())
+	}
+	if !bytes.Equal(coins[1].RedeemScript(), key.WitnessProgram(pubhash)) {
+		t.Error("illegal redeem script of P2SH-P2WPKH")
+	}
+
+	fee := changelessFee(coins)
+	spend, err := NewP2PK(&Send{
+		Addr:   "MS43dMzRKfEs99Q931zFECfUhdvtWmbsPt",
+		Amount: 8*params.Unit - fee,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFee(t, spend, coins, fee)
+	if !spend.HasWitness() {
+		t.Fatal("spending tx has no witness")
+	}
+	for i, in := range spend.TxIn {
+		prev := &fund.TxOut[in.Index]
+		if err = script.VerifyInput(spend, i, prev, script.StandardFlags); err != nil {
+			t.Error("illegal witness", i, err)
+		}
+	}
+	if bytes.Equal(spend.Hash(), spend.WitnessHash()) {
+		t.Error("txid must differ from wtxid")
+	}
+
+	//txs in merkle blocks come without witness.
+	if err = Add(spend.Stripped(), params.Net.GenesisHash); err != nil {
+		t.Fatal(err)
+	}
+	if len(SortedCoins()) != 0 {
+		t.Error("spent segwit coins remain")
+	}
+}
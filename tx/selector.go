@@ -0,0 +1,323 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/monarj/wallet/block"
+	"github.com/monarj/wallet/msg"
+)
+
+//ErrNoChangeless is returned by BranchAndBound when no set of coins
+//pays txouts without change.
+var ErrNoChangeless = errors.New("no changeless selection of coins")
+
+//bnbTries is the maximum number of nodes BranchAndBound visits.
+const bnbTries = 100000
+
+//Target is the amount which selected coins must cover.
+type Target struct {
+	//Amount is the total value of TxOuts.
+	Amount uint64
+	TxOuts []msg.TxOut
+	//FeeRate is the fee rate per kB.
+	FeeRate uint64
+}
+
+//Fee returns the fee of the tx which spends coins to TxOuts,
+//with a change txout if change is true.
+func (t *Target) Fee(coins []*Coin, change bool) uint64 {
+	size := coinsSize(coins, t.TxOuts)
+	if change {
+		size += changeSize
+	}
+	return feeOf(size, t.FeeRate)
+}
+
+//Covered returns true if coins pay TxOuts and the fee without change.
+func (t *Target) Covered(coins []*Coin) bool {
+	var amount uint64
+	for _, c := range coins {
+		amount += c.Value
+	}
+	return amount >= t.Amount+t.Fee(coins, false)
+}
+
+//ShortageError is the error that coins are not enough to pay the amount
+//and the fee.
+type ShortageError struct {
+	//Total is the total value of the coins.
+	Total uint64
+	//Amount is the amount to pay including Fee.
+	Amount uint64
+	Fee    uint64
+}
+
+func (e *ShortageError) Error() string {
+	return fmt.Sprintf("shortage of coin %d < %d (fee %d)", e.Total, e.Amount, e.Fee)
+}
+
+//shortage returns the error that candidates cannot cover t.
+func (t *Target) shortage(candidates []*Coin) error {
+	var amount uint64
+	for _, c := range candidates {
+		amount += c.Value
+	}
+	fee := t.Fee(candidates, false)
+	return &ShortageError{Total: amount, Amount: t.Amount + fee, Fee: fee}
+}
+
+//CoinSelector is a strategy to select coins to spend.
+type CoinSelector interface {
+	//Name returns the name of the strategy.
+	Name() string
+	//Select returns coins in candidates which cover t.
+	Select(candidates []*Coin, t *Target) ([]*Coin, error)
+}
+
+//Strategies of coin selection.
+var (
+	//SmallestFirst adds coins in ascending order of value.
+	SmallestFirst CoinSelector = smallestFirst{}
+	//LargestFirst adds coins in descending order of value,
+	//which makes txs with few inputs.
+	LargestFirst CoinSelector = largestFirst{}
+	//OldestFirst adds coins in ascending order of height.
+	OldestFirst CoinSelector = oldestFirst{}
+	//Privacy spends all coins of an address together and
+	//avoids mixing addresses as possible.
+	Privacy CoinSelector = privacy{}
+	//BranchAndBound searches coins which pay without change.
+	//It returns ErrNoChangeless if there are no such coins.
+	BranchAndBound CoinSelector = branchAndBound{}
+
+	//DefaultSelector is used when a selector is not specified.
+	//It falls back to LargestFirst, which needs fewer inputs, if it returns
+	//ErrNoChangeless. Result.Selector reports the strategy actually used.
+	DefaultSelector = BranchAndBound
+)
+
+var selectors = []CoinSelector{SmallestFirst, LargestFirst, OldestFirst, Privacy, BranchAndBound}
+
+//SelectorNames returns names of all strategies.
+func SelectorNames() []string {
+	names := make([]string, len(selectors))
+	for i, s := range selectors {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+//SelectorByName returns the strategy whose name is name.
+func SelectorByName(name string) (CoinSelector, error) {
+	for _, s := range selectors {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return nil, errors.New("unknown coin selection " + name)
+}
+
+//accumulate adds coins in order until they cover t.
+func accumulate(coins []*Coin, t *Target) ([]*Coin, error) {
+	for i := range coins {
+		if t.Covered(coins[:i+1]) {
+			return coins[:i+1], nil
+		}
+	}
+	return nil, t.shortage(coins)
+}
+
+type smallestFirst struct{}
+
+func (smallestFirst) Name() string {
+	return "smallest"
+}
+
+func (smallestFirst) Select(candidates []*Coin, t *Target) ([]*Coin, error) {
+	coins := append(Coins{}, candidates...)
+	sort.Stable(coins)
+	return accumulate(coins, t)
+}
+
+type largestFirst struct{}
+
+func (largestFirst) Name() string {
+	return "largest"
+}
+
+func (largestFirst) Select(candidates []*Coin, t *Target) ([]*Coin, error) {
+	coins := append(Coins{}, candidates...)
+	sort.Stable(sort.Reverse(coins))
+	return accumulate(coins, t)
+}
+
+type oldestFirst struct{}
+
+func (oldestFirst) Name() string {
+	return "oldest"
+}
+
+func (oldestFirst) Select(candidates []*Coin, t *Target) ([]*Coin, error) {
+	coins := append(Coins{}, candidates...)
+	heights := make(map[*Coin]uint64, len(coins))
+	for _, c := range coins {
+		b, err := block.LoadBlock(c.Block)
+		if err != nil {
+			return nil, err
+		}
+		heights[c] = b.Height
+	}
+	sort.SliceStable(coins, func(i, j int) bool {
+		return heights[coins[i]] < heights[coins[j]]
+	})
+	return accumulate(coins, t)
+}
+
+type privacy struct{}
+
+func (privacy) Name() string {
+	return "privacy"
+}
+
+//Select selects the smallest group of coins of an address which covers t.
+//If there is no such address, it adds groups in descending order of value.
+func (privacy) Select(candidates []*Coin, t *Target) ([]*Coin, error) {
+	var groups [][]*Coin
+	var sums []uint64
+	index := make(map[string]int)
+	for _, c := range candidates {
+		adr := c.Address()
+		i, exist := index[adr]
+		if !exist {
+			i = len(groups)
+			index[adr] = i
+			groups = append(groups, nil)
+			sums = append(sums, 0)
+		}
+		groups[i] = append(groups[i], c)
+		sums[i] += c.Value
+	}
+	best := -1
+	for i, g := range groups {
+		if t.Covered(g) && (best < 0 || sums[i] < sums[best]) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return groups[best], nil
+	}
+	order := make([]int, len(groups))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sums[order[i]] > sums[order[j]]
+	})
+	var coins []*Coin
+	for _, i := range order {
+		coins = append(coins, groups[i]...)
+		if t.Covered(coins) {
+			return coins, nil
+		}
+	}
+	return nil, t.shortage(candidates)
+}
+
+type branchAndBound struct{}
+
+func (branchAndBound) Name() string {
+	return "bnb"
+}
+
+//Select searches coins whose total minus fees of their inputs is between
+//the target and the target plus the cost of change,
+//and returns ones which waste the least.
+func (branchAndBound) Select(candidates []*Coin, t *Target) ([]*Coin, error) {
+	type effective struct {
+		coin  *Coin
+		value uint64
+	}
+	var coins []effective
+	var rest uint64
+	for _, c := range candidates {
+		fee := feeOf(estimateSize([]inputSize{c.inputSize()}, nil)-estimateSize(nil, nil), t.FeeRate)
+		if c.Value <= fee {
+			continue
+		}
+		coins = append(coins, effective{c, c.Value - fee})
+		rest += c.Value - fee
+	}
+	sort.SliceStable(coins, func(i, j int) bool {
+		return coins[i].value > coins[j].value
+	})
+	target := t.Amount + t.Fee(nil, false)
+	upper := target + feeOf(changeSize, t.FeeRate)
+
+	var best []int
+	var bestExcess uint64
+	var selected []int
+	tries := 0
+	var search func(i int, cur, rest uint64)
+	search = func(i int, cur, rest uint64) {
+		tries++
+		switch {
+		case tries > bnbTries || cur > upper || cur+rest < target:
+			return
+		case cur >= target:
+			if best == nil || cur-target < bestExcess {
+				best = append([]int{}, selected...)
+				bestExcess = cur - target
+			}
+			return
+		case i == len(coins) || (best != nil && bestExcess == 0):
+			return
+		}
+		selected = append(selected, i)
+		search(i+1, cur+coins[i].value, rest-coins[i].value)
+		selected = selected[:len(selected)-1]
+		search(i+1, cur, rest-coins[i].value)
+	}
+	search(0, 0, rest)
+
+	if best == nil {
+		return nil, ErrNoChangeless
+	}
+	result := make([]*Coin, len(best))
+	for i, j := range best {
+		result[i] = coins[j].coin
+	}
+	if !t.Covered(result) {
+		return nil, ErrNoChangeless
+	}
+	return result, nil
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2016, Shinya Yagyu
+ * All rights reserved.
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice,
+ *    this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ * 3. Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from this
+ *    software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ * ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+ * LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+ * CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+ * SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+ * INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+ * CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/monarj/wallet/key"
+	"github.com/monarj/wallet/msg"
+	"github.com/monarj/wallet/params"
+)
+
+func testCoins(pubs []byte, values ...uint64) []*Coin {
+	coins := make([]*Coin, len(values))
+	for i, v := range values {
+		priv := key.NewPrivateKey(bytes.Repeat([]byte{pubs[i]}, 32))
+		coins[i] = &Coin{
+			Pubkey:  priv.PublicKey.Serialize(),
+			TxHash:  bytes.Repeat([]byte{byte(i)}, 32),
+			Value:   v * params.Unit / 10,
+			TxIndex: uint32(i),
+		}
+	}
+	return coins
+}
+
+func testTarget(amount uint64) *Target {
+	return &Target{
+		Amount:  amount,
+		TxOuts:  []msg.TxOut{{Value: amount, Script: make([]byte, 25)}},
+		FeeRate: params.Fee,
+	}
+}
+
+func values(coins []*Coin) []uint64 {
+	v := make([]uint64, len(coins))
+	for i, c := range coins {
+		v[i] = c.Value * 10 / params.Unit
+	}
+	return v
+}
+
+func equal(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSelector(t *testing.T) {
+	coins := testCoins([]byte{1, 2, 3, 4}, 10, 20, 50, 100)
+	tests := []struct {
+		sel    CoinSelector
+		amount uint64
+		result []uint64
+	}{
+		{SmallestFirst, 4 * params.Unit, []uint64{10, 20, 50}},
+		{LargestFirst, 4 * params.Unit, []uint64{100}},
+		{Privacy, 4 * params.Unit, []uint64{50}},
+	}
+	for _, tt := range tests {
+		sel, err := tt.sel.Select(coins, testTarget(tt.amount))
+		if err != nil {
+			t.Fatal(tt.sel.Name(), err)
+		}
+		if !equal(values(sel), tt.result) {
+			t.Error("illegal selection", tt.sel.Name(), values(sel))
+		}
+	}
+
+	//6 MONA minus fee is paid by 1 and 5 without change.
+	tg := testTarget(0)
+	tg.Amount = 6*params.Unit - tg.Fee(coins[:2], false)
+	tg.TxOuts[0].Value = tg.Amount
+	sel, err := BranchAndBound.Select(coins, tg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(values(sel), []uint64{50, 10}) {
+		t.Error("illegal selection of bnb", values(sel))
+	}
+	if _, err = BranchAndBound.Select(coins, testTarget(params.Unit/2)); err != ErrNoChangeless {
+		t.Error("bnb must fail without changeless selection", err)
+	}
+
+	//coins of an address are spent together.
+	coins = testCoins([]byte{1, 1, 2}, 30, 30, 50)
+	sel, err = Privacy.Select(coins, testTarget(55*params.Unit/10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(values(sel), []uint64{30, 30}) {
+		t.Error("illegal selection of privacy", values(sel))
+	}
+	sel, err = Privacy.Select(coins, testTarget(10*params.Unit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(values(sel), []uint64{30, 30, 50}) {
+		t.Error("illegal selection of privacy", values(sel))
+	}
+
+	for _, s := range []CoinSelector{SmallestFirst, LargestFirst, Privacy, BranchAndBound} {
+		if _, err = s.Select(coins, testTarget(20*params.Unit)); err == nil {
+			t.Error("shortage of coins must be an error", s.Name())
+		}
+	}
+	for _, n := range SelectorNames() {
+		if s, err := SelectorByName(n); err != nil || s.Name() != n {
+			t.Error("cannot get selector", n)
+		}
+	}
+	if _, err = SelectorByName("random"); err == nil {
+		t.Error("unknown selector must be an error")
+	}
+}